@@ -5,22 +5,76 @@ $(document).on('pageinit', '.feed_page', function() {
 	var feedKey = $(this).attr('key');
 	var contents = $(this).find('#contents');
 	var busy = false;
+	var held = false;
 	
 	// エントリをタップしたら既読化
-	$(this).find('.entry').on('tap', function() {
+	$(this).on('tap', '#entries .entry', function() {
 		var self = $(this);
+		
+		// 長押しでスターを付けたときは既読化しない
+		if(held) {
+			held = false;
+			return false;
+		}
 		$.ajax('/api/read', {
 			data: {
-				link: self.attr('href'),
+				id: self.attr('entry_id'),
+				feed_key: feedKey
+			},
+			error: function() {
+				console.log('network error');
+			},
+			success: function() {
+				self.closest('li').remove();
+			}
+		});
+	});
+	
+	// エントリを長押ししたらスターを付ける・外す
+	$(this).on('taphold', '.entry', function() {
+		var self = $(this);
+		var starred = self.find('.star').length > 0;
+		held = self.closest('#entries').length > 0;
+		$.ajax(starred ? '/api/unstar' : '/api/star', {
+			data: {
+				id: self.attr('entry_id'),
+				feed_key: feedKey
+			},
+			error: function() {
+				console.log('network error');
+			},
+			success: function() {
+				if(starred) {
+					self.find('.star').remove();
+				} else {
+					self.find('h3').prepend('<span class="star">★</span>');
+				}
+			}
+		});
+		return false;
+	});
+	
+	// 既読のエントリの「未読に戻す」をタップしたら未読のリストへ戻す
+	$(this).find('.unread').on('tap', function() {
+		var self = $(this);
+		$.ajax('/api/unread', {
+			data: {
+				id: self.attr('entry_id'),
 				feed_key: feedKey
 			},
 			error: function() {
 				console.log('network error');
 			},
 			success: function() {
-				self.parent().parent().parent().remove();
+				var li = self.closest('li');
+				var a = $('<a class="entry" target="_blank"></a>').attr('href', li.find('a').first().attr('href')).attr('entry_id', self.attr('entry_id'));
+				a.append($('<h3></h3>').html(li.find('h3').html()));
+				$('#entries').prepend($('<li></li>').append(a)).listview('refresh');
+				li.remove();
+				$('#read_entries').listview('refresh');
 			}
 		});
+		return false;
 	});
 	
 	// 既読化ボタンをタップしたらすべて既読化
@@ -60,14 +114,40 @@ $(document).on('pageinit', '.feed_page', function() {
 			},
 			dataType: 'json',
 			async: false,
-			success: function(data) {
+			success: function(report) {
+				var result = report.Feeds[0];
+				var data = report.Entries || [];
 				if(data.length == 0) {
-					alert('新着はありませんでした');
+					if(result.Status == 'failed' || result.Status == 'timeout' || result.Status == 'backoff') {
+						alert('フィードを受信できませんでした。' + (result.Error || ''));
+					} else if(result.Status == 'parse_error') {
+						alert('フィードが壊れているため読み込めませんでした。' + (result.Error || ''));
+					} else if(result.Status == 'gone') {
+						alert('このフィードは配信を終了しています');
+					} else if(result.Status == 'not_modified') {
+						alert('フィードは前回から更新されていませんでした');
+					} else {
+						alert('新着はありませんでした');
+					}
 					return;
 				}
 				var entries = $('#entries');
 				for(var i = data.length - 1; i >= 0; i--) {
-					var li = $('<li><a href="' + data[i].Link + '" class="entry" target="_blank">' + data[i].Title + '</a></li>');
+					var a = $('<a href="' + data[i].Link + '" class="entry" target="_blank"></a>').attr('entry_id', data[i].ID);
+					a.append($('<h3></h3>').text(data[i].Title));
+					if(age(data[i].Published) != '') {
+						a.append($('<p class="ui-li-aside"></p>').text(age(data[i].Published)));
+					}
+					if(data[i].EnclosureURL) {
+						a.append($('<p class="episode"></p>').text('エピソード ' + playtime(data[i].Duration)));
+					}
+					if(data[i].Summary) {
+						a.append($('<p class="summary"></p>').text(data[i].Summary));
+					}
+					var li = $('<li></li>').append(a);
+					if(data[i].EnclosureURL) {
+						li.append($('<a class="enclosure" target="_blank">再生</a>').attr('href', data[i].EnclosureURL).attr('type', data[i].EnclosureType));
+					}
 					li.prependTo(entries)
 				}
 				entries.listview('refresh');
@@ -81,4 +161,76 @@ $(document).on('pageinit', '.feed_page', function() {
 			}
 		});
 	});
-});
\ No newline at end of file
+});
+
+/**
+ * スター付きエントリ画面のJavaScript
+ */
+$(document).on('pageinit', '.starred_page', function() {
+	
+	// 「スターを外す」をタップしたら一覧から消す
+	$(this).find('.unstar').on('tap', function() {
+		var self = $(this);
+		$.ajax('/api/unstar', {
+			data: {
+				id: self.attr('entry_id'),
+				feed_key: self.attr('feed_key')
+			},
+			error: function() {
+				console.log('network error');
+			},
+			success: function() {
+				self.closest('li').remove();
+				$('#starred_entries').listview('refresh');
+			}
+		});
+		return false;
+	});
+});
+
+/**
+ * 公開日時を現在からの経過時間で表す
+ * サーバ側の relativeTime と同じ表記にする
+ * @param {string} published RFC3339形式の日時
+ * @returns {string} "5分前" などの文字列　日時が不明なら空文字列
+ */
+function age(published) {
+	var date = new Date(published);
+	var minutes;
+	
+	if(isNaN(date.getTime()) || date.getFullYear() <= 1) {
+		return '';
+	}
+	
+	minutes = Math.floor((new Date().getTime() - date.getTime()) / 60000);
+	if(minutes < 1) {
+		return 'たった今';
+	} else if(minutes < 60) {
+		return minutes + '分前';
+	} else if(minutes < 24 * 60) {
+		return Math.floor(minutes / 60) + '時間前';
+	} else if(minutes < 30 * 24 * 60) {
+		return Math.floor(minutes / (24 * 60)) + '日前';
+	}
+	return date.getFullYear() + '/' + ('0' + (date.getMonth() + 1)).slice(-2) + '/' + ('0' + date.getDate()).slice(-2);
+}
+
+/**
+ * 秒数を再生時間の表記にする
+ * サーバ側の formatDuration と同じ表記にする
+ * @param {number} seconds 秒数
+ * @returns {string} "1:02:03" や "2:03" などの文字列　0なら空文字列
+ */
+function playtime(seconds) {
+	var pad = function(n) {
+		return ('0' + n).slice(-2);
+	};
+	
+	if(!seconds || seconds <= 0) {
+		return '';
+	}
+	if(seconds >= 60 * 60) {
+		return Math.floor(seconds / 3600) + ':' + pad(Math.floor(seconds / 60) % 60) + ':' + pad(seconds % 60);
+	}
+	return Math.floor(seconds / 60) + ':' + pad(seconds % 60);
+}
@@ -12,12 +12,16 @@ $(document).on('pageinit', '.folder_page', function() {
 	var addFeedButton = $(this).find('#add_feed_button');
 	var feedURL = $(this).find('#feed_url');
 	var addFeed = $(this).find('#add_feed');
+	var selectFeed = $(this).find('#select_feed');
 	var editButton = $(this).find('#edit');
 	var feedName = $(this).find('#feed_name');
 	var feedMenu = $(this).find('#feed_menu');
 	var folderNewName = $(this).find('#folder_new_name');
 	var folderMenu = $(this).find('#folder_menu');
 	var editFeed = $(this).find('#edit_feed');
+	var moveTarget = $(this).find('#move_target');
+	var sortMenu = $(this).find('#sort_menu');
+	var dragging = null;
 	var editMode = false;
 	var editTarget = null;
 	var busy = false;
@@ -33,6 +37,10 @@ $(document).on('pageinit', '.folder_page', function() {
 			},
 			dataType: 'json',
 			success: function(data) {
+				if(data.key == '') {
+					alert('フォルダを保存できませんでした。しばらくしてからもう一度お試しください。');
+					return;
+				}
 				contents.append($('<li><div class="folder_icon"></div><a class="item" href="/folder?key=' + data.key + '" key="' + data.key + '" type="folder"><span class="title">' + name + '</span></a></li>')).listview('refresh');
 				addFolder.popup('close');
 				folderName.val('');
@@ -47,35 +55,85 @@ $(document).on('pageinit', '.folder_page', function() {
 	addFeedButton.on('tap', function() {
 		var url = feedURL.val();
 		
-		if(!url.match(/http:\/\/.+/)) {
+		if(!url.match(/https?:\/\/.+/)) {
 			alert('HTTPのURLを入力してください');
 		} else {
-			$.ajax('/api/addfeed', {
-				data: {
-					url: url,
-					folder_key: folderKey
-				},
-				dataType: 'json',
-				success: function(data) {
-					if(data.result == 'nothing_file') {
-						alert('指定されたURLに配信用のファイルが見つかりませんでした。Atom, RSS2.0, RSS1.0 に対応したファイルの場所を指定してください。');
-					} else if(data.result == 'duplicated') {
-						alert('既に登録済みのフィードです')
-					} else {
-						contents.append($('<li><div class="feed_icon"></div><a class="item" href="/feed?key=' + data.key + '"  key="' + data.key + '" type="feed"><span class="title">' + data.name + '</span><span class="ui-li-count">' + data.count + '</span></a></li>')).listview('refresh');
-					}
-					addFeed.popup('close');
-					feedURL.val('');
-				},
-				error: function() {
-					console.log('error');
-				}
-			});
+			requestAddFeed(url, addFeed);
 		}
 		
 		feedURL.val('');
 	});
 	
+	/**
+	 * フィードの追加をサーバへ要求する
+	 * ウェブページに複数のフィードがあった場合は選択肢を表示する
+	 * @param {string} url フィードまたはウェブページのURL
+	 * @param {jQuery} popup 完了時に閉じるポップアップ
+	 */
+	var requestAddFeed = function(url, popup) {
+		$.ajax('/api/addfeed', {
+			data: {
+				url: url,
+				folder_key: folderKey
+			},
+			dataType: 'json',
+			success: function(data) {
+				if(data.result == 'nothing_file') {
+					alert('指定されたURLに配信用のファイルが見つかりませんでした。Atom, RSS2.0, RSS1.0, JSON Feed に対応したファイルの場所を指定してください。');
+				} else if(data.result == 'timeout') {
+					alert('サーバからの応答がありませんでした。しばらくしてからもう一度お試しください。');
+				} else if(data.result == 'too_large') {
+					alert('配信用のファイルが大きすぎるため読み込めませんでした。');
+				} else if(data.result == 'http_error' || data.result == 'fetch_error') {
+					alert('指定されたURLからファイルを受信できませんでした。');
+				} else if(data.result == 'parse_error') {
+					alert('配信用のファイルが壊れているため読み込めませんでした。');
+				} else if(data.result == 'duplicated') {
+					alert('既に登録済みのフィードです')
+				} else if(data.result == 'failed') {
+					alert('フィードを保存できませんでした。しばらくしてからもう一度お試しください。');
+				} else if(data.result == 'select') {
+					showCandidates(data.candidates, popup);
+					return;
+				} else {
+					contents.append($('<li><div class="feed_icon"></div><a class="item" href="/feed?key=' + data.key + '"  key="' + data.key + '" type="feed"><span class="title">' + data.name + '</span><span class="ui-li-count">' + data.count + '</span></a></li>')).listview('refresh');
+				}
+				popup.popup('close');
+				feedURL.val('');
+			},
+			error: function() {
+				console.log('error');
+			}
+		});
+	};
+	
+	/**
+	 * フィードの候補を表示してユーザに選ばせる
+	 * @param {Array} candidates フィードの候補
+	 * @param {jQuery} popup 現在開いているポップアップ
+	 */
+	var showCandidates = function(candidates, popup) {
+		var list = selectFeed.find('#candidates').empty();
+		
+		$.each(candidates, function(i, candidate) {
+			var button = $('<a href="#" data-role="button" data-theme="c"></a>').text(candidate.title || candidate.url);
+			button.on('tap', function() {
+				requestAddFeed(candidate.url, selectFeed);
+				return false;
+			});
+			list.append(button);
+		});
+		list.trigger('create');
+		
+		popup.one('popupafterclose', function() {
+			selectFeed.popup('open', {
+				transition: 'pop',
+				positionTo: 'window'
+			});
+		});
+		popup.popup('close');
+	};
+	
 	// 編集ボタン
 	editButton.on('tap', function() {
 		if(editMode) {
@@ -96,6 +154,9 @@ $(document).on('pageinit', '.folder_page', function() {
 			// メッセージ非表示
 			$('#edit_message').remove();
 			
+			// 並べ替えを終了
+			contents.children('.movable').removeClass('movable').removeAttr('draggable');
+			
 		} else {
 		
 			// 編集モード開始時の処理 //
@@ -131,12 +192,136 @@ $(document).on('pageinit', '.folder_page', function() {
 				$(data).find('.ui-icon').removeClass('ui-icon-arrow-r').addClass('ui-icon-gear');
 			});
 			
+			// ドラッグで並べ替えられるようにする
+			contents.find('a.item[key]').closest('li').addClass('movable').attr('draggable', 'true');
+			
 			// メッセージ表示
-			contents.prepend($('<li id="edit_message" data-role="list-divider">編集したいタイトルをタップ</li>'));
+			contents.prepend($('<li id="edit_message" data-role="list-divider">編集したいタイトルをタップ　長押ししてドラッグすると並べ替え</li>'));
 			contents.listview('refresh');
 		}
 	});
 	
+	/**
+	 * ドラッグ中の項目を指定された項目の前か後ろへ動かす
+	 * @param {jQuery} target 指の下にある項目
+	 * @param {number} y 指の位置
+	 */
+	var placeAt = function(target, y) {
+		if(target.length == 0 || target[0] == dragging[0]) {
+			return;
+		}
+		if(y < target.offset().top - $(window).scrollTop() + target.outerHeight() / 2) {
+			target.before(dragging);
+		} else {
+			target.after(dragging);
+		}
+	};
+	
+	/**
+	 * 今の表示順をフォルダの並び順としてサーバへ保存する
+	 */
+	var saveOrder = function() {
+		var order = $.map(contents.find('a.item[key]'), function(item) {
+			return $(item).attr('key');
+		});
+		
+		$.ajax('/api/reorder', {
+			data: {
+				key: folderKey,
+				order: order
+			},
+			traditional: true,
+			dataType: 'json',
+			success: function(data) {
+				if(data.result == 'success') {
+					sortMenu.find('.sort_mode').buttonMarkup({theme: 'c'});
+					sortMenu.find('[mode=manual]').buttonMarkup({theme: 'b'});
+				} else if(data.result == 'mismatch') {
+					alert('フォルダの中身が変わっているため並べ替えられませんでした。画面を読み込み直してください。');
+				} else {
+					alert('並べ替えを保存できませんでした');
+				}
+			},
+			error: function() {
+				console.log('error');
+			}
+		});
+	};
+	
+	// PCではドラッグ＆ドロップで並べ替える
+	contents.on('dragstart', 'li.movable', function(e) {
+		dragging = $(this);
+		e.originalEvent.dataTransfer.effectAllowed = 'move';
+		e.originalEvent.dataTransfer.setData('text', $(this).find('a.item').attr('key'));
+	});
+	contents.on('dragover', 'li.movable', function(e) {
+		if(dragging == null) {
+			return;
+		}
+		e.preventDefault();
+		placeAt($(this), e.originalEvent.clientY);
+	});
+	contents.on('drop', function(e) {
+		e.preventDefault();
+	});
+	contents.on('dragend', 'li.movable', function() {
+		if(dragging != null) {
+			dragging = null;
+			saveOrder();
+		}
+	});
+	
+	// スマホでは長押ししてから指を動かして並べ替える
+	contents.on('taphold', 'li.movable', function() {
+		dragging = $(this);
+	});
+	contents.on('touchmove', 'li.movable', function(e) {
+		var touch = e.originalEvent.touches[0];
+		if(dragging == null) {
+			return;
+		}
+		e.preventDefault();
+		placeAt($(document.elementFromPoint(touch.clientX, touch.clientY)).closest('li.movable'), touch.clientY);
+	});
+	contents.on('touchend', 'li.movable', function() {
+		if(dragging != null) {
+			dragging = null;
+			saveOrder();
+		}
+	});
+	
+	// 並び順の選択
+	sortMenu.find('.sort_mode').on('tap', function() {
+		var button = $(this);
+		
+		$.ajax('/api/sortfolder', {
+			data: {
+				key: folderKey,
+				sort: button.attr('mode')
+			},
+			dataType: 'json',
+			success: function(data) {
+				if(data.result != 'success') {
+					alert('並び順を変更できませんでした');
+					return;
+				}
+				
+				// 新しい並び順に項目を並べ直す
+				$.each(data.order, function(i, key) {
+					contents.append(contents.find('[key=' + key + ']').closest('li'));
+				});
+				contents.listview('refresh');
+				sortMenu.find('.sort_mode').buttonMarkup({theme: 'c'});
+				button.buttonMarkup({theme: 'b'});
+				sortMenu.popup('close');
+			},
+			error: function() {
+				console.log('error');
+			}
+		});
+		return false;
+	});
+	
 	// フィード名変更ボタン
 	$(this).find('#feed_name_button').on('tap', function() {
 		var name = feedName.val();
@@ -224,6 +409,92 @@ $(document).on('pageinit', '.folder_page', function() {
 		});
 	});
 	
+	// フィード・フォルダの移動ボタン
+	$(this).find('.move_item').on('tap', function() {
+		var key = editTarget.attr('key');
+		var menu = (editTarget.attr('type') == 'feed') ? feedMenu : folderMenu;
+		
+		// フォルダは自分自身とその中へは移動できないので選択肢から外す
+		$.ajax('/api/folders', {
+			data: {
+				exclude: (editTarget.attr('type') == 'folder') ? key : ''
+			},
+			dataType: 'json',
+			success: function(folders) {
+				showTargets(folders, menu);
+			},
+			error: function() {
+				console.log('error');
+			}
+		});
+	});
+	
+	/**
+	 * 移動先のフォルダを表示してユーザに選ばせる
+	 * @param {Array} folders ツリーの順に並べたフォルダ
+	 * @param {jQuery} popup 現在開いているポップアップ
+	 */
+	var showTargets = function(folders, popup) {
+		var list = moveTarget.find('#targets').empty();
+		
+		$.each(folders, function(i, folder) {
+			var indent = new Array(folder.Depth + 1).join('　');
+			var button = $('<a href="#" data-role="button" data-theme="c"></a>').text(indent + (folder.Root ? 'トップ' : folder.Title));
+			if(folder.Key == folderKey) {
+				button.attr('data-theme', 'b');
+			}
+			button.on('tap', function() {
+				requestMove(editTarget, folder.Key);
+				return false;
+			});
+			list.append(button);
+		});
+		list.trigger('create');
+		
+		popup.one('popupafterclose', function() {
+			moveTarget.popup('open', {
+				transition: 'pop',
+				positionTo: 'window'
+			});
+		});
+		popup.popup('close');
+	};
+	
+	/**
+	 * フィードまたはフォルダの移動をサーバへ要求する
+	 * @param {jQuery} target 移動するアイテムのリンク
+	 * @param {string} to 移動先のフォルダのキー
+	 */
+	var requestMove = function(target, to) {
+		$.ajax('/api/move', {
+			data: {
+				key: target.attr('key'),
+				to: to
+			},
+			dataType: 'json',
+			success: function(data) {
+				if(data.result == 'success') {
+					if(to != folderKey) {
+						target.closest('li').remove();
+						contents.listview('refresh');
+					}
+				} else if(data.result == 'descendant') {
+					alert('フォルダを自分自身やその中のフォルダへは移動できません');
+				} else if(data.result == 'root') {
+					alert('トップのフォルダは移動できません');
+				} else if(data.result == 'not_found') {
+					alert('移動先のフォルダが見つかりませんでした。画面を読み込み直してください。');
+				} else {
+					alert('移動できませんでした。しばらくしてからもう一度お試しください。');
+				}
+				moveTarget.popup('close');
+			},
+			error: function() {
+				console.log('error');
+			}
+		});
+	};
+	
 	// フォルダの既読化ボタン
 	$(this).find('#read').on('tap', function() {
 		if(busy) {
@@ -266,19 +537,40 @@ $(document).on('pageinit', '.folder_page', function() {
 			},
 			dataType: 'json',
 			success: function(data) {
-				var updated = false;
-				for(var key in data) {
+				var children = data.Children;
+				var updated = data.New > 0;
+				var modified = false;
+				var failed = (data.Statuses.failed || 0) + (data.Statuses.parse_error || 0) + (data.Statuses.timeout || 0) + (data.Statuses.cancelled || 0);
+				for(var key in children) {
 					var count = $('[key=' + key + ']').find('.ui-li-count');
-					if(count.html() < data[key]) {
-						updated = true;
+					if(children[key].Status != 'not_modified') {
+						modified = true;
+					}
+					count.html(children[key].Count);
+					
+					// 配信が終了したフィードに印をつける
+					if(children[key].Status == 'gone') {
+						var item = $('[key=' + key + ']');
+						if(item.find('.dead_label').length == 0) {
+							item.find('.title').after('<span class="dead_label">配信終了</span>');
+						}
+						item.closest('li').addClass('dead');
 					}
-					count.html(data[key]);
 				}
+				var message;
 				if(updated) {
-					alert('新着エントリを追加しました');
+					message = data.New + '件の新着エントリを追加しました';
+				} else if(!modified) {
+					message = 'フィードは前回から更新されていませんでした';
 				} else {
-					alert('新着はありませんでした');
+					message = '新着はありませんでした';
+				}
+				
+				// 受信に失敗したり時間内に終わらなかったものがあれば知らせる
+				if(failed > 0) {
+					message += '\n' + failed + '件は更新できませんでした。しばらくしてからもう一度お試しください。';
 				}
+				alert(message);
 			},
 			error: function() {
 				console.log('error');
@@ -5,6 +5,8 @@ package okareader
 import (
 	"appengine"
 	"encoding/xml"
+	"strconv"
+	"strings"
 )
 
 /**
@@ -14,6 +16,26 @@ import (
 type Atom struct {
 }
 
+/**
+ * ルート要素が feed ならAtomとして登録する
+ */
+func init() {
+	registerParser("Atom", detectXMLRoot("feed"), new(Atom))
+}
+
+/**
+ * Atomのテキスト要素(summary, content)
+ * @class
+ * @member {string} Type 形式("text"/"html"/"xhtml")
+ * @member {string} Body 要素の文字列
+ * @member {string} InnerXML 要素の中身(xhtmlの場合に使用する)
+ */
+type AtomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+	InnerXML string `xml:",innerxml"`
+}
+
 /**
  * データストアに保存できる形式に変換する
  * @methodOf Atom
@@ -21,23 +43,36 @@ type Atom struct {
  * @param {[]byte} xmldata
  * @returns {Feed} feed フィードリスト
  * @returns {[]Entry} entries エントリリスト
+ * @returns {error} 読み込めなかったときのエラー
  */
-func (this *Atom) encode(c appengine.Context, xmldata []byte) (*Feed, []*Entry) {
-	type EntryTemplate struct {
-		Link struct {
-			Href string `xml:"href,attr"`
-		} `xml:"link"`
-		Title string `xml:"title"`
-		Owner string
-	}
+func (this *Atom) encode(c appengine.Context, xmldata []byte) (*Feed, []*Entry, error) {
 	type FeedLink struct {
 		Rel string `xml:"rel,attr"`
 		Href string `xml:"href,attr"`
+		Type string `xml:"type,attr"`
+		Length string `xml:"length,attr"`
+	}
+	type EntryTemplate struct {
+		Id string `xml:"id"`
+		Link []FeedLink `xml:"link"`
+		Title string `xml:"title"`
+		Summary AtomText `xml:"summary"`
+		Content AtomText `xml:"content"`
+		Published string `xml:"published"`
+		Updated string `xml:"updated"`
+		Issued string `xml:"issued"`
+		Modified string `xml:"modified"`
+		Duration string `xml:"duration"`
+		Owner string
 	}
 	type AtomTemplate struct {
 		Id string `xml:"id"`
 		Title string `xml:"title"`
 		Link []FeedLink `xml:"link"`
+		Updated string `xml:"updated"`
+		Modified string `xml:"modified"`
+		Logo string `xml:"logo"`
+		Icon string `xml:"icon"`
 		Entries []*EntryTemplate `xml:"entry"`
 		Owner string
 	}
@@ -55,27 +90,104 @@ func (this *Atom) encode(c appengine.Context, xmldata []byte) (*Feed, []*Entry)
 	// atomを解析
 	var atomTemplate = new(AtomTemplate)
 	err = xml.Unmarshal(xmldata, atomTemplate)
-	check(c, err)
+	if err != nil {
+		return nil, nil, err
+	}
 	
 	// エントリの変換
 	for _, entryTemplate = range atomTemplate.Entries {
 		entry = new(Entry)
-		entry.Link = entryTemplate.Link.Href
+		entry.ID = strings.TrimSpace(entryTemplate.Id)
+		for _, link = range entryTemplate.Link {
+			switch link.Rel {
+				case "", "alternate":
+					if entry.Link == "" {
+						entry.Link = link.Href
+					}
+				case "enclosure":
+					if entry.EnclosureURL == "" {
+						entry.EnclosureURL = link.Href
+						entry.EnclosureType = link.Type
+						entry.EnclosureLength, _ = strconv.ParseInt(link.Length, 10, 64)
+					}
+			}
+		}
+		entry.Duration = parseDuration(entryTemplate.Duration)
 		entry.Title = entryTemplate.Title
+		entry.Content, entry.ContentType = this.text(entryTemplate.Content)
+		if entryTemplate.Summary.Body != "" {
+			entry.Summary, _ = this.text(entryTemplate.Summary)
+		} else {
+			entry.Summary = entry.Content
+		}
+		entry.Summary = summarize(entry.Summary)
+		
+		// Atom0.3 の issued, modified にも対応する
+		entry.Published = parseDate(entryTemplate.Published)
+		if entry.Published.IsZero() {
+			entry.Published = parseDate(entryTemplate.Issued)
+		}
+		entry.Updated = parseDate(entryTemplate.Updated)
+		if entry.Updated.IsZero() {
+			entry.Updated = parseDate(entryTemplate.Modified)
+		}
+		if entry.Published.IsZero() {
+			entry.Published = entry.Updated
+		}
+		if entry.Updated.IsZero() {
+			entry.Updated = entry.Published
+		}
 		
 		entries = append(entries, entry)
 	}
 	
 	// Atomの変換
 	for _, link = range atomTemplate.Link {
-		if link.Rel == "alternate" {
+		if link.Rel == "alternate" || link.Rel == "" {
 			feed.SiteURL = link.Href
 		} else if link.Rel == "self" {
 			feed.URL = link.Href
+		} else if link.Rel == "hub" && feed.Hub == "" {
+			feed.Hub = link.Href
 		}
 	}
 	feed.Title = atomTemplate.Title
+	feed.Image = atomTemplate.Logo
+	if feed.Image == "" {
+		feed.Image = atomTemplate.Icon
+	}
 	feed.Standard = "Atom"
+	feed.Updated = parseDate(atomTemplate.Updated)
+	if feed.Updated.IsZero() {
+		feed.Updated = parseDate(atomTemplate.Modified)
+	}
+	
+	return feed, entries, nil
+}
+
+/**
+ * Atomのテキスト要素から本文と形式を取り出す
+ * 形式が省略されていたら"text"とする
+ * @methodOf Atom
+ * @param {AtomText} text テキスト要素
+ * @returns {string} 本文
+ * @returns {string} 形式("text"/"html"/"xhtml"のいずれか)
+ */
+func (this *Atom) text(text AtomText) (string, string) {
+	var body string
+	var contentType string
+	
+	switch text.Type {
+		case "html", "text/html":
+			contentType = "html"
+			body = text.Body
+		case "xhtml", "application/xhtml+xml":
+			contentType = "xhtml"
+			body = text.InnerXML
+		default:
+			contentType = "text"
+			body = text.Body
+	}
 	
-	return feed, entries
+	return body, contentType
 }
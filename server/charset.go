@@ -0,0 +1,184 @@
+/**
+ * 文字コードの判定と変換
+ * Shift_JIS や EUC-JP で配信されているフィードを UTF-8 に変換してから解析する
+ */
+package okareader
+import (
+	"bytes"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/unicode"
+	"mime"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+/**
+ * 文字コード名(小文字)と変換方法の対応
+ */
+var encodings = map[string]encoding.Encoding{
+	"shift_jis": japanese.ShiftJIS,
+	"shift-jis": japanese.ShiftJIS,
+	"sjis": japanese.ShiftJIS,
+	"x-sjis": japanese.ShiftJIS,
+	"windows-31j": japanese.ShiftJIS,
+	"cp932": japanese.ShiftJIS,
+	"ms932": japanese.ShiftJIS,
+	"euc-jp": japanese.EUCJP,
+	"eucjp": japanese.EUCJP,
+	"x-euc-jp": japanese.EUCJP,
+	"iso-2022-jp": japanese.ISO2022JP,
+	"csiso2022jp": japanese.ISO2022JP,
+	"iso-8859-1": charmap.ISO8859_1,
+	"latin1": charmap.ISO8859_1,
+	"windows-1252": charmap.Windows1252,
+	"utf-16be": unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM),
+	"utf-16le": unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM),
+}
+
+/**
+ * XML宣言の encoding 属性にマッチする正規表現
+ */
+var xmlEncodingPattern = regexp.MustCompile(`^\s*<\?xml[^>]*?encoding\s*=\s*["']([A-Za-z0-9._\-]+)["']`)
+
+/**
+ * BOM
+ */
+var (
+	bomUTF8 = []byte{0xef, 0xbb, 0xbf}
+	bomUTF16BE = []byte{0xfe, 0xff}
+	bomUTF16LE = []byte{0xff, 0xfe}
+)
+
+/**
+ * データの文字コードを判断する
+ * BOM, XML宣言, HTTPのContent-Typeの順に調べる
+ * 日本のサイトはサーバの設定とファイルの中身が食い違っていることが多いので
+ * HTTPヘッダよりもXML宣言を優先する
+ * @function
+ * @param {[]byte} data 受信したデータ
+ * @param {string} contentType HTTPのContent-Typeヘッダ　わからなければ空文字列
+ * @returns {string} 文字コード名(小文字)　わからなければ空文字列
+ */
+func detectCharset(data []byte, contentType string) string {
+	var match [][]byte
+	var params map[string]string
+	var err error
+	
+	switch {
+		case bytes.HasPrefix(data, bomUTF8):
+			return "utf-8"
+		case bytes.HasPrefix(data, bomUTF16BE):
+			return "utf-16be"
+		case bytes.HasPrefix(data, bomUTF16LE):
+			return "utf-16le"
+	}
+	
+	match = xmlEncodingPattern.FindSubmatch(data)
+	if match != nil {
+		return strings.ToLower(string(match[1]))
+	}
+	
+	if contentType != "" {
+		_, params, err = mime.ParseMediaType(contentType)
+		if err == nil && params["charset"] != "" {
+			return strings.ToLower(params["charset"])
+		}
+	}
+	
+	return ""
+}
+
+/**
+ * データを UTF-8 に変換する
+ * 変換後はXML宣言の encoding も UTF-8 に書き換えるので
+ * そのまま encoding/xml で解析できる
+ * @function
+ * @param {[]byte} data 受信したデータ
+ * @param {string} contentType HTTPのContent-Typeヘッダ　わからなければ空文字列
+ * @returns {[]byte} UTF-8 に変換したデータ
+ */
+func toUTF8(data []byte, contentType string) []byte {
+	var charset string
+	var enc encoding.Encoding
+	var ok bool
+	var result []byte
+	var err error
+	
+	charset = detectCharset(data, contentType)
+	enc, ok = encodings[charset]
+	if !ok && !utf8.Valid(data) {
+		// 文字コードが書かれていなければ日本語の文字コードを推測する
+		enc = guessJapanese(data)
+		ok = enc != nil
+	}
+	
+	if ok {
+		data = bytes.TrimPrefix(data, bomUTF16BE)
+		data = bytes.TrimPrefix(data, bomUTF16LE)
+		result, err = enc.NewDecoder().Bytes(data)
+		if err == nil {
+			data = result
+		}
+	}
+	data = bytes.TrimPrefix(data, bomUTF8)
+	
+	return rewriteXMLEncoding(data)
+}
+
+/**
+ * 文字コードが不明なデータを Shift_JIS または EUC-JP として変換してみて
+ * 変換できない文字が少ない方を返す
+ * @function
+ * @param {[]byte} data 変換するデータ
+ * @returns {encoding.Encoding} 推測した文字コード　どちらでもなければnil
+ */
+func guessJapanese(data []byte) encoding.Encoding {
+	var candidates []encoding.Encoding
+	var candidate encoding.Encoding
+	var result encoding.Encoding
+	var decoded []byte
+	var err error
+	var invalid int
+	var fewest int
+	
+	candidates = []encoding.Encoding{japanese.ShiftJIS, japanese.EUCJP}
+	fewest = -1
+	for _, candidate = range candidates {
+		decoded, err = candidate.NewDecoder().Bytes(data)
+		if err != nil {
+			continue
+		}
+		invalid = bytes.Count(decoded, []byte("\ufffd"))
+		if fewest == -1 || invalid < fewest {
+			fewest = invalid
+			result = candidate
+		}
+	}
+	
+	return result
+}
+
+/**
+ * XML宣言の encoding を UTF-8 に書き換える
+ * @function
+ * @param {[]byte} data UTF-8 のXMLデータ
+ * @returns {[]byte} 書き換えたデータ
+ */
+func rewriteXMLEncoding(data []byte) []byte {
+	var loc []int
+	var result []byte
+	
+	loc = xmlEncodingPattern.FindSubmatchIndex(data)
+	if loc == nil || strings.EqualFold(string(data[loc[2]:loc[3]]), "utf-8") {
+		return data
+	}
+	
+	result = make([]byte, 0, len(data))
+	result = append(result, data[:loc[2]]...)
+	result = append(result, "UTF-8"...)
+	result = append(result, data[loc[3]:]...)
+	return result
+}
@@ -0,0 +1,116 @@
+/**
+ * 文字コードの判定と変換のテスト
+ */
+package okareader
+import (
+	"testing"
+)
+
+/**
+ * BOM があれば XML宣言や Content-Type より優先するか確かめる
+ * @function
+ * @param {*testing.T} t テスト
+ */
+func TestDetectCharsetBOM(t *testing.T) {
+	var boms map[string]string
+	var bom string
+	var expected string
+	var actual string
+	
+	boms = map[string]string{
+		"\xef\xbb\xbf": "utf-8",
+		"\xfe\xff": "utf-16be",
+		"\xff\xfe": "utf-16le",
+	}
+	for bom, expected = range boms {
+		actual = detectCharset([]byte(bom + `<?xml version="1.0" encoding="Shift_JIS"?>`), "text/xml; charset=EUC-JP")
+		if actual != expected {
+			t.Errorf("bom %x: detected %q, want %q", bom, actual, expected)
+		}
+	}
+}
+
+/**
+ * BOM がなければ XML宣言, Content-Type の順に見るか確かめる
+ * @function
+ * @param {*testing.T} t テスト
+ */
+func TestDetectCharsetDeclaration(t *testing.T) {
+	var actual string
+	
+	actual = detectCharset([]byte(`<?xml version='1.0' encoding='EUC-JP'?>`), "")
+	if actual != "euc-jp" {
+		t.Errorf("single quoted declaration: detected %q", actual)
+	}
+	
+	actual = detectCharset([]byte(`<?xml version="1.0" encoding="Shift_JIS"?>`), "text/xml; charset=EUC-JP")
+	if actual != "shift_jis" {
+		t.Errorf("declaration should win over the header: detected %q", actual)
+	}
+	
+	actual = detectCharset([]byte(`<?xml version="1.0"?>`), "text/xml; charset=EUC-JP")
+	if actual != "euc-jp" {
+		t.Errorf("declaration without encoding: detected %q", actual)
+	}
+	
+	actual = detectCharset([]byte(`<rss>`), `application/rss+xml; charset="Windows-31J"`)
+	if actual != "windows-31j" {
+		t.Errorf("quoted header charset: detected %q", actual)
+	}
+	
+	actual = detectCharset([]byte(`<rss>`), "application/rss+xml")
+	if actual != "" {
+		t.Errorf("no charset anywhere: detected %q", actual)
+	}
+}
+
+/**
+ * 変換結果を比べる
+ * @function
+ * @param {*testing.T} t テスト
+ * @param {string} name 失敗したときに表示する名前
+ * @param {string} data 変換するデータ
+ * @param {string} contentType Content-Type ヘッダ
+ * @param {string} expected UTF-8 に変換した結果
+ */
+func checkUTF8(t *testing.T, name string, data string, contentType string, expected string) {
+	var actual string
+	
+	actual = string(toUTF8([]byte(data), contentType))
+	if actual != expected {
+		t.Errorf("%s: got %q, want %q", name, actual, expected)
+	}
+}
+
+/**
+ * 日本語の文字コードを UTF-8 に変換し、XML宣言の encoding を書き換えるか確かめる
+ * @function
+ * @param {*testing.T} t テスト
+ */
+func TestToUTF8(t *testing.T) {
+	var sjis string
+	var eucjp string
+	
+	// 「日本語」
+	sjis = "\x93\xfa\x96\x7b\x8c\xea"
+	eucjp = "\xc6\xfc\xcb\xdc\xb8\xec"
+	
+	checkUTF8(t, "utf-8 is left as is", `<?xml version="1.0" encoding="UTF-8"?><title>日本語</title>`, "", `<?xml version="1.0" encoding="UTF-8"?><title>日本語</title>`)
+	checkUTF8(t, "utf-8 bom is removed", "\xef\xbb\xbf<title>日本語</title>", "", `<title>日本語</title>`)
+	checkUTF8(t, "utf8 alias", `<?xml version="1.0" encoding="utf8"?><title>日本語</title>`, "", `<?xml version="1.0" encoding="UTF-8"?><title>日本語</title>`)
+	checkUTF8(t, "shift_jis", `<?xml version="1.0" encoding="Shift_JIS"?><title>` + sjis + `</title>`, "", `<?xml version="1.0" encoding="UTF-8"?><title>日本語</title>`)
+	checkUTF8(t, "euc-jp", `<?xml version="1.0" encoding='EUC-JP'?><title>` + eucjp + `</title>`, "", `<?xml version="1.0" encoding='UTF-8'?><title>日本語</title>`)
+	checkUTF8(t, "declaration wins", `<?xml version="1.0" encoding="Shift_JIS"?><title>` + sjis + `</title>`, "text/xml; charset=EUC-JP", `<?xml version="1.0" encoding="UTF-8"?><title>日本語</title>`)
+	checkUTF8(t, "header charset", `<title>` + eucjp + `</title>`, "application/rss+xml; charset=euc-jp", `<title>日本語</title>`)
+	checkUTF8(t, "utf-16le", "\xff\xfe<\x00t\x00i\x00t\x00l\x00e\x00>\x00\xe5\x65\x2c\x67\x9e\x8a<\x00/\x00t\x00i\x00t\x00l\x00e\x00>\x00", "", `<title>日本語</title>`)
+}
+
+/**
+ * 文字コードの手がかりがないときに Shift_JIS を推測できるか確かめる
+ * @function
+ * @param {*testing.T} t テスト
+ */
+func TestToUTF8Guess(t *testing.T) {
+	// 「日本語のタイトルです」
+	checkUTF8(t, "guess shift_jis", "<title>\x93\xfa\x96\x7b\x8c\xea\x82\xcc\x83\x5e\x83\x43\x83\x67\x83\x8b\x82\xc5\x82\xb7</title>", "", `<title>日本語のタイトルです</title>`)
+}
@@ -10,9 +10,12 @@ import(
 	"appengine"
 	"appengine/user"
 	"net/http"
+	"net/url"
 	"encoding/json"
 	"mime/multipart"
 	"fmt"
+	"strconv"
+	"time"
 )
 
 /**
@@ -46,6 +49,16 @@ func (this *Controller) handle() {
 		this.feed(w, r)
 	})
 	
+	// スター付きエントリの一覧画面
+	http.HandleFunc("/starred", func(w http.ResponseWriter, r *http.Request) {
+		this.starred(w, r)
+	})
+	
+	// フィードの受信状況画面
+	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		this.health(w, r)
+	})
+	
 	// フォルダの追加
 	http.HandleFunc("/api/addfolder", func(w http.ResponseWriter, r *http.Request) {
 		this.addFolder(w, r)
@@ -61,6 +74,31 @@ func (this *Controller) handle() {
 		this.removeFolder(w, r)
 	})
 	
+	// フィード・フォルダの移動
+	http.HandleFunc("/api/move", func(w http.ResponseWriter, r *http.Request) {
+		this.moveItem(w, r)
+	})
+	
+	// 移動先に選べるフォルダの一覧
+	http.HandleFunc("/api/folders", func(w http.ResponseWriter, r *http.Request) {
+		this.folderList(w, r)
+	})
+	
+	// フォルダの中身の取得
+	http.HandleFunc("/api/folder", func(w http.ResponseWriter, r *http.Request) {
+		this.folderContents(w, r)
+	})
+	
+	// フォルダの中身の並べ替え
+	http.HandleFunc("/api/reorder", func(w http.ResponseWriter, r *http.Request) {
+		this.reorderFolder(w, r)
+	})
+	
+	// フォルダの並び順の変更
+	http.HandleFunc("/api/sortfolder", func(w http.ResponseWriter, r *http.Request) {
+		this.sortFolder(w, r)
+	})
+	
 	// フォルダの既読化
 	http.HandleFunc("/api/readfolder", func(w http.ResponseWriter, r *http.Request) {
 		this.readFolder(w, r)
@@ -86,6 +124,21 @@ func (this *Controller) handle() {
 		this.readEntry(w, r)
 	})
 
+	// １件のエントリを未読に戻す
+	http.HandleFunc("/api/unread", func(w http.ResponseWriter, r *http.Request) {
+		this.unreadEntry(w, r)
+	})
+	
+	// １件のエントリにスターを付ける
+	http.HandleFunc("/api/star", func(w http.ResponseWriter, r *http.Request) {
+		this.starEntry(w, r, true)
+	})
+	
+	// １件のエントリのスターを外す
+	http.HandleFunc("/api/unstar", func(w http.ResponseWriter, r *http.Request) {
+		this.starEntry(w, r, false)
+	})
+	
 	// フィード内のエントリをすべて既読化
 	http.HandleFunc("/api/readall", func(w http.ResponseWriter, r *http.Request) {
 		this.readAll(w, r)
@@ -116,10 +169,74 @@ func (this *Controller) handle() {
 		this.clear(w, r)
 	})
 	
-	// すべてのフォルダのアップデート(1日1回自動)
+	// 受信予定時刻を過ぎたフィードのアップデート(15分ごとに自動)
 	http.HandleFunc("/task/update", func(w http.ResponseWriter, r *http.Request) {
 		this.updateAll(w, r)
 	})
+	
+	// 定期更新の実行記録(管理者のみ)
+	http.HandleFunc("/admin/updates", func(w http.ResponseWriter, r *http.Request) {
+		this.updateLogs(w, r)
+	})
+	
+	// データの整合性の検査と修復(管理者のみ)
+	http.HandleFunc("/admin/integrity", func(w http.ResponseWriter, r *http.Request) {
+		this.integrity(w, r)
+	})
+	
+	// 整合性の検査をユーザごとのタスクに振り分ける
+	http.HandleFunc("/task/integrity", func(w http.ResponseWriter, r *http.Request) {
+		this.enqueueIntegrityChecks(w, r)
+	})
+	
+	// １人のユーザの整合性の検査
+	http.HandleFunc("/task/integrity/user", func(w http.ResponseWriter, r *http.Request) {
+		this.checkUserIntegrity(w, r)
+	})
+	
+	// 受信元の整合性の検査
+	http.HandleFunc("/task/integrity/sources", func(w http.ResponseWriter, r *http.Request) {
+		this.checkSourceIntegrity(w, r)
+	})
+	
+	// 保存期間を過ぎた既読エントリの削除(1日ごとに自動)
+	http.HandleFunc("/task/purge", func(w http.ResponseWriter, r *http.Request) {
+		this.purgeReadEntries(w, r)
+	})
+	
+	// 受信元を持たない古いフィードの登録(1日ごとに自動)
+	http.HandleFunc("/task/sources", func(w http.ResponseWriter, r *http.Request) {
+		this.subscribeLegacyFeeds(w, r)
+	})
+	
+	// フォルダの未読数の数え直しをユーザごとのタスクに振り分ける(1日ごとに自動)
+	http.HandleFunc("/task/recount", func(w http.ResponseWriter, r *http.Request) {
+		this.enqueueRecounts(w, r)
+	})
+	
+	// １人のユーザのフォルダの未読数の数え直し
+	http.HandleFunc("/task/recount/user", func(w http.ResponseWriter, r *http.Request) {
+		this.recountUnread(w, r)
+	})
+	
+	// WebSub のハブからの購読確認とプッシュ
+	http.HandleFunc("/websub", func(w http.ResponseWriter, r *http.Request) {
+		this.websub(w, r)
+	})
+	
+	// WebSub の購読の更新(1時間ごとに自動)
+	http.HandleFunc("/task/websub", func(w http.ResponseWriter, r *http.Request) {
+		this.renewSubscriptions(w, r)
+	})
+	
+	// 開発用のWebSubハブ
+	if appengine.IsDevAppServer() {
+		http.HandleFunc("/dev/hub", func(w http.ResponseWriter, r *http.Request) {
+			var hub *StubHub
+			hub = new(StubHub)
+			hub.handle(w, r)
+		})
+	}
 }
 
 /**
@@ -181,17 +298,57 @@ func (this *Controller) folder(w http.ResponseWriter, r *http.Request) {
  * @param {http.ResponseWriter} w 応答先
  * @param {*http.Request} r リクエスト
  * @param {HTTP GET} key エンコード済みのフィードキー
+ * @param {HTTP GET} read "1" なら既読のエントリも表示する
  */
 func (this *Controller) feed(w http.ResponseWriter, r *http.Request) {
 	var c appengine.Context
 	var view *View
 	var feedKey string
+	var showRead bool
+	
+	c = appengine.NewContext(r)
+	feedKey = r.FormValue("key")
+	showRead = r.FormValue("read") == "1"
+	
+	view = new(View)
+	view.showFeed(c, feedKey, showRead, w)
+}
+
+/**
+ * http://okareader.appspot.com/starred へアクセスしたらスター付きエントリを表示
+ * すべてのフィードのスター付きエントリを１つの仮想フォルダとして表示する
+ * @methodOf Controller
+ * @param {http.ResponseWriter} w 応答先
+ * @param {*http.Request} r リクエスト
+ */
+func (this *Controller) starred(w http.ResponseWriter, r *http.Request) {
+	var c appengine.Context
+	var view *View
+	
+	c = appengine.NewContext(r)
+	
+	view = new(View)
+	view.showStarred(c, w)
+}
+
+/**
+ * http://okareader.appspot.com/health へアクセスしたらフィードの受信状況を表示
+ * フィードのキーはGETで渡される
+ * @methodOf Controller
+ * @param {http.ResponseWriter} w 応答先
+ * @param {*http.Request} r リクエスト
+ * @param {HTTP GET} key エンコード済みのフィードキー
+ */
+func (this *Controller) health(w http.ResponseWriter, r *http.Request) {
+	var c appengine.Context
+	var view *View
+	var feedKey string
 	
 	c = appengine.NewContext(r)
 	feedKey = r.FormValue("key")
 	
 	view = new(View)
-	view.showFeed(c, feedKey, w)
+	view.showHealth(c, feedKey, w)
 }
 
 /**
@@ -282,6 +439,174 @@ func (this *Controller) removeFolder(w http.ResponseWriter, r *http.Request) {
 	dao.removeFolder(c, key)
 }
 
+/**
+ * フォルダの中身を並び順に従って返す
+ * @methodOf Controller
+ * @param {http.ResponseWriter} w 応答先
+ * @param {*http.Request} r リクエスト
+ * @param {HTTP GET} key フォルダのキー
+ * @returns {AJAX JSON} JSONオブジェクト
+ *     "Key" フォルダのキー
+ *     "Title" フォルダ名
+ *     "Sort" 並び順
+ *     "Children" 並び順に並べた中身(Key, Type, Title, Count, Updated)の配列
+ */
+func (this *Controller) folderContents(w http.ResponseWriter, r *http.Request) {
+	var c appengine.Context
+	var dao *DAO
+	var key string
+	var folder *Folder
+	var response []byte
+	var err error
+	
+	key = r.FormValue("key")
+	
+	c = appengine.NewContext(r)
+	dao = new(DAO)
+	folder = dao.getFolder(c, key)
+	
+	response, err = json.Marshal(map[string]interface{}{
+		"Key": key,
+		"Title": folder.Title,
+		"Sort": folder.Sort,
+		"Children": dao.getChildren(c, folder),
+	})
+	check(c, err)
+	
+	fmt.Fprintf(w, "%s", response)
+}
+
+/**
+ * フォルダの中身をユーザが指定した順に並べ替える
+ * 並び順は手動("manual")になる
+ * @methodOf Controller
+ * @param {http.ResponseWriter} w 応答先
+ * @param {*http.Request} r リクエスト
+ * @param {HTTP GET} key フォルダのキー
+ * @param {HTTP GET} order 並べ替えた後の中身のキー(中身の数だけ繰り返す)
+ * @returns {AJAX JSON} JSONオブジェクト
+ *     "result"
+ *         "success" 並べ替えた
+ *         "mismatch" 指定された中身が今の中身と違う(画面を読み込み直す必要がある)
+ *         "not_found" フォルダが見つからない
+ *         "forbidden" フォルダがログインユーザのものではない
+ *         "failed" 保存できなかった
+ */
+func (this *Controller) reorderFolder(w http.ResponseWriter, r *http.Request) {
+	var c appengine.Context
+	var dao *DAO
+	var key string
+	var result string
+	
+	r.ParseForm()
+	key = r.FormValue("key")
+	
+	c = appengine.NewContext(r)
+	dao = new(DAO)
+	result = dao.reorderFolder(c, key, r.Form["order"])
+	fmt.Fprintf(w, `{"result":"%s"}`, result)
+}
+
+/**
+ * フォルダの並び順を変更する
+ * @methodOf Controller
+ * @param {http.ResponseWriter} w 応答先
+ * @param {*http.Request} r リクエスト
+ * @param {HTTP GET} key フォルダのキー
+ * @param {HTTP GET} sort 並び順("manual"/"title"/"unread"/"updated"/"folders_first"のいずれか)
+ * @returns {AJAX JSON} JSONオブジェクト
+ *     "result" "success", "invalid", "not_found", "forbidden" のいずれか
+ *     "order" 新しい並び順に並べた中身のキーの配列("success"のときのみ)
+ */
+func (this *Controller) sortFolder(w http.ResponseWriter, r *http.Request) {
+	var c appengine.Context
+	var dao *DAO
+	var key string
+	var result map[string]interface{}
+	var order []string
+	var child *FolderChild
+	var response []byte
+	var err error
+	
+	key = r.FormValue("key")
+	
+	c = appengine.NewContext(r)
+	dao = new(DAO)
+	result = make(map[string]interface{})
+	result["result"] = dao.setFolderSort(c, key, r.FormValue("sort"))
+	if result["result"] == "success" {
+		order = make([]string, 0)
+		for _, child = range dao.getChildren(c, dao.getFolder(c, key)) {
+			order = append(order, child.Key)
+		}
+		result["order"] = order
+	}
+	
+	response, err = json.Marshal(result)
+	check(c, err)
+	
+	fmt.Fprintf(w, "%s", response)
+}
+
+/**
+ * フィードまたはフォルダを別のフォルダへ移動する
+ * @methodOf Controller
+ * @param {http.ResponseWriter} w 応答先
+ * @param {*http.Request} r リクエスト
+ * @param {HTTP GET} key 移動するフィードまたはフォルダのキー
+ * @param {HTTP GET} to 移動先のフォルダのキー
+ * @returns {AJAX JSON} JSONオブジェクト
+ *     "result"
+ *         "success" 移動した
+ *         "not_found" 移動するものか移動先が見つからない
+ *         "root" ルートフォルダは移動できない
+ *         "descendant" フォルダを自分自身かその中へは移動できない
+ *         "forbidden" 他のユーザのフォルダへは移動できない
+ *         "failed" 保存できなかった
+ */
+func (this *Controller) moveItem(w http.ResponseWriter, r *http.Request) {
+	var c appengine.Context
+	var dao *DAO
+	var key string
+	var to string
+	var result string
+	
+	key = r.FormValue("key")
+	to = r.FormValue("to")
+	
+	c = appengine.NewContext(r)
+	dao = new(DAO)
+	result = dao.moveItem(c, key, to)
+	fmt.Fprintf(w, `{"result":"%s"}`, result)
+}
+
+/**
+ * 移動先に選べるフォルダの一覧を返す
+ * @methodOf Controller
+ * @param {http.ResponseWriter} w 応答先
+ * @param {*http.Request} r リクエスト
+ * @param {HTTP GET} exclude 一覧に含めないフォルダのキー(移動するフォルダ)
+ * @returns {AJAX JSON} ツリーの順に並べたフォルダ(Key, Title, Depth, Root)の配列
+ */
+func (this *Controller) folderList(w http.ResponseWriter, r *http.Request) {
+	var c appengine.Context
+	var u *user.User
+	var dao *DAO
+	var list []*FolderNode
+	var response []byte
+	var err error
+	
+	c = appengine.NewContext(r)
+	u = user.Current(c)
+	dao = new(DAO)
+	
+	list = dao.getFolderList(c, u, r.FormValue("exclude"))
+	response, err = json.Marshal(list)
+	check(c, err)
+	
+	fmt.Fprintf(w, "%s", response)
+}
+
 /**
  * フィードの登録
  * @methodOf Controller
@@ -292,11 +617,19 @@ func (this *Controller) removeFolder(w http.ResponseWriter, r *http.Request) {
  * @returns {AJAX JSON} JSONオブジェクト
  *     "result"
  *         "nothing_file" 指定されたURLに配信用ファイルが存在しない
+ *         "timeout" 受信がタイムアウトした
+ *         "too_large" ファイルが大きすぎる
+ *         "http_error" サーバがエラーを返した
+ *         "fetch_error" その他の理由で受信できなかった
+ *         "parse_error" フィードだが壊れていて読み込めなかった
+ *         "select" ウェブページに複数のフィードがあるのでユーザに選ばせる
  *         "duplicated" 既に同じフィードが存在する
+ *         "failed" 保存できなかった
  *         "success" 登録成功
  *     "key" 追加したフィードのキー
  *     "name" 追加したフィードのタイトル
  *     "count" 追加したフィード内のエントリ数
+ *     "candidates" フィードの候補("select"のときのみ)
  */
 func (this *Controller) addFeed(w http.ResponseWriter, r *http.Request) {
 	var url string
@@ -308,7 +641,11 @@ func (this *Controller) addFeed(w http.ResponseWriter, r *http.Request) {
 	var feedKey string
 	var duplicated bool
 	var xml []byte
-	var feedType string
+	var fetched *FetchResult
+	var discovery *Discovery
+	var candidates []*Candidate
+	var response []byte
+	var err error
 	
 	c = appengine.NewContext(r)
 	dao = new(DAO)
@@ -318,36 +655,109 @@ func (this *Controller) addFeed(w http.ResponseWriter, r *http.Request) {
 	folderKey = r.FormValue("folder_key")
 	
 	// XML取得
-	xml = getXML(c, url)
+	fetched, err = fetch(c, url)
+	if err != nil {
+		check(c, err)
+		fmt.Fprintf(w, `{"result":"%s"}`, this.fetchError(err))
+		return
+	}
+	xml = fetched.Body
 	
 	// フィード取得
-	feedType = dao.getType(c, xml)
-	switch feedType {
-		case "Atom":
-			var atom *Atom
-			atom = new(Atom)
-			feed, entries = atom.encode(c, xml)
-		case "RSS2.0":
-			var rss2 *RSS2
-			rss2 = new(RSS2)
-			feed, entries = rss2.encode(c, xml)
-		case "RSS1.0":
-			var rss1 *RSS1
-			rss1 = new(RSS1)
-			feed, entries = rss1.encode(c, xml)
-		case "etc":
+	feed, entries, err = parseFeed(c, xml, "")
+	if err != nil {
+		check(c, err)
+		fmt.Fprintf(w, `{"result":"parse_error"}`)
+		return
+	}
+	if feed == nil {
+		
+		// フィードでなければウェブページとみなしてフィードを探す
+		discovery = new(Discovery)
+		candidates = discovery.find(c, url, xml)
+		if len(candidates) == 0 {
+			fmt.Fprintf(w, `{"result":"nothing_file"}`)
+			return
+		}
+		if len(candidates) > 1 {
+			response, err = json.Marshal(map[string]interface{}{
+				"result": "select",
+				"candidates": candidates,
+			})
+			check(c, err)
+			fmt.Fprintf(w, "%s", response)
+			return
+		}
+		
+		// 候補が１つだけならそのフィードを追加する
+		url = candidates[0].URL
+		fetched, err = fetch(c, url)
+		if err != nil {
+			check(c, err)
+			fmt.Fprintf(w, `{"result":"%s"}`, this.fetchError(err))
+			return
+		}
+		feed, entries, err = parseFeed(c, fetched.Body, "")
+		if err != nil {
+			check(c, err)
+			fmt.Fprintf(w, `{"result":"parse_error"}`)
+			return
+		}
+		if feed == nil {
 			fmt.Fprintf(w, `{"result":"nothing_file"}`)
 			return
+		}
 	}
+	
+	// 恒久的に移転していれば移転先のURLで登録する
 	feed.URL = url
+	if fetched.PermanentURL != "" {
+		feed.URL = fetched.PermanentURL
+	}
 	
 	// フィード追加を試みる
 	feedKey, duplicated = dao.registerFeed(c, feed, entries, folderKey)
 	if duplicated {
 		fmt.Fprintf(w, `{"result":"duplicated"}`)
+	} else if feedKey == "" {
+		fmt.Fprintf(w, `{"result":"failed"}`)
 	} else {
-		fmt.Fprintf(w, `{"result":"success", "key":"%s", "name":"%s", "count":%d}`, feedKey, feed.Title, len(entries))
+		response, err = json.Marshal(map[string]interface{}{
+			"result": "success",
+			"key": feedKey,
+			"name": feed.Title,
+			"count": len(entries),
+		})
+		check(c, err)
+		fmt.Fprintf(w, "%s", response)
+	}
+}
+
+/**
+ * 受信エラーをクライアントへ返す結果の文字列に変換する
+ * @methodOf Controller
+ * @param {error} err fetch が返したエラー
+ * @returns {string} "nothing_file" / "timeout" / "too_large" / "http_error" / "fetch_error" のいずれか
+ */
+func (this *Controller) fetchError(err error) string {
+	var result string
+	
+	switch e := err.(type) {
+		case *HTTPStatusError:
+			if e.StatusCode == http.StatusNotFound || e.StatusCode == http.StatusGone {
+				result = "nothing_file"
+			} else {
+				result = "http_error"
+			}
+		case *TimeoutError:
+			result = "timeout"
+		case *TooLargeError:
+			result = "too_large"
+		default:
+			result = "fetch_error"
 	}
+	
+	return result
 }
 
 /**
@@ -370,25 +780,70 @@ func (this *Controller) removeFeed(w http.ResponseWriter, r *http.Request) {
 }
 
 /**
- * エントリを既読化（削除）する
+ * エントリを既読化する
  * @methodOf Controller
  * @param {http.ResponseWriter} w 応答先
  * @param {*http.Request} r リクエスト
- * @param {HTTP GET} link 既読化するエントリのURL
+ * @param {HTTP GET} id 既読化するエントリのID
  * @param {HTTP GET} feed_key エントリが含まれるフィードキー
  */
 func (this *Controller) readEntry(w http.ResponseWriter, r *http.Request) {
 	var c appengine.Context
-	var link string
+	var id string
+	var feedKey string
+	var dao *DAO
+	
+	c = appengine.NewContext(r)
+	id = r.FormValue("id")
+	feedKey = r.FormValue("feed_key")
+	dao = new(DAO)
+	
+	dao.markEntry(c, id, feedKey, true)
+}
+
+/**
+ * 既読のエントリを未読に戻す
+ * @methodOf Controller
+ * @param {http.ResponseWriter} w 応答先
+ * @param {*http.Request} r リクエスト
+ * @param {HTTP GET} id 未読に戻すエントリのID
+ * @param {HTTP GET} feed_key エントリが含まれるフィードキー
+ */
+func (this *Controller) unreadEntry(w http.ResponseWriter, r *http.Request) {
+	var c appengine.Context
+	var id string
+	var feedKey string
+	var dao *DAO
+	
+	c = appengine.NewContext(r)
+	id = r.FormValue("id")
+	feedKey = r.FormValue("feed_key")
+	dao = new(DAO)
+	
+	dao.markEntry(c, id, feedKey, false)
+}
+
+/**
+ * エントリにスターを付ける、または外す
+ * @methodOf Controller
+ * @param {http.ResponseWriter} w 応答先
+ * @param {*http.Request} r リクエスト
+ * @param {bool} starred スターを付けるならtrue、外すならfalse
+ * @param {HTTP GET} id エントリのID
+ * @param {HTTP GET} feed_key エントリが含まれるフィードキー
+ */
+func (this *Controller) starEntry(w http.ResponseWriter, r *http.Request, starred bool) {
+	var c appengine.Context
+	var id string
 	var feedKey string
 	var dao *DAO
 	
 	c = appengine.NewContext(r)
-	link = r.FormValue("link")
+	id = r.FormValue("id")
 	feedKey = r.FormValue("feed_key")
 	dao = new(DAO)
 	
-	dao.removeEntry(c, link, feedKey)
+	dao.starEntry(c, id, feedKey, starred)
 }
 
 /**
@@ -452,13 +907,15 @@ func (this *Controller) clear(w http.ResponseWriter, r *http.Request) {
  * @param {http.ResponseWriter} w 応答先
  * @param {*http.Request} r リクエスト
  * @param {HTTP GET} key フィードキー
- * @returns {AJAX JSON} 追加したエントリリスト
+ * @returns {AJAX JSON} 更新報告(UpdateReport)　追加したエントリリストは Entries に入る
  */
 func (this *Controller) updateFeed(w http.ResponseWriter, r *http.Request) {
 	var key string
 	var c appengine.Context
 	var dao *DAO
-	var newEntries []*Entry
+	var updateResult *UpdateResult
+	var report *UpdateReport
+	var started time.Time
 	var result []byte
 	var err error
 	
@@ -466,9 +923,12 @@ func (this *Controller) updateFeed(w http.ResponseWriter, r *http.Request) {
 	
 	c = appengine.NewContext(r)
 	dao = new(DAO)
-	newEntries = dao.updateFeed(c, key, nil)
+	started = time.Now()
+	updateResult = dao.updateFeed(c, key, started.Add(feedDeadline))
 	
-	result, err = json.Marshal(newEntries)
+	report = makeReport(started, []*UpdateResult{updateResult})
+	report.Entries = updateResult.Entries
+	result, err = json.Marshal(report)
 	check(c, err)
 	
 	fmt.Fprintf(w, "%s", result)
@@ -480,13 +940,16 @@ func (this *Controller) updateFeed(w http.ResponseWriter, r *http.Request) {
  * @param {http.ResponseWriter} w 応答先
  * @param {*http.Request} r リクエスト
  * @param {HTTP GET} key フォルダのキー
- * @returns {AJAX JSON} フォルダの直下の各アイテムの更新件数
+ * @returns {AJAX JSON} 更新報告(UpdateReport)
+ *     Feeds にはフォルダ以下で更新したすべてのフィードの結果が、
+ *     Children にはフォルダの直下の各アイテムの更新結果(Status)と更新後の件数(Count)が入る
+ *     Status は updated, no_new_entries, not_modified, failed, gone, backoff, timeout, cancelled のいずれか
  */
 func (this *Controller) updateFolder(w http.ResponseWriter, r *http.Request) {
 	var key string
 	var dao *DAO
 	var c appengine.Context
-	var result map[string]int
+	var result *UpdateReport
 	var response []byte
 	var err error
 	
@@ -494,8 +957,7 @@ func (this *Controller) updateFolder(w http.ResponseWriter, r *http.Request) {
 	dao = new(DAO)
 	c = appengine.NewContext(r)
 	
-	result = make(map[string]int)
-	result = dao.updateFolder(c, key, nil)
+	result = dao.updateFolder(c, key)
 	
 	response, err = json.Marshal(result)
 	check(c, err)
@@ -568,17 +1030,326 @@ func (this *Controller) importXML(w http.ResponseWriter, r *http.Request) {
 }
 
 /**
- * すべてのフォルダを一斉に更新する
- * cronによって1日1回定期的に実行する
+ * 受信予定時刻を過ぎたフィードを更新する
+ * cronによって15分ごとに実行する
  * アプリにアクセスしないことによって抜けてしまうエントリがでないようにするため
+ * 受信予定時刻はフィードごとに更新頻度から決める
+ * 更新報告は実行記録として保存され、/admin/updates で確認できる
  * @methodOf Controller
  * @param {http.ResponseWriter} w 応答先
  * @param {*http.Request} r リクエスト
+ * @returns {JSON} 更新報告(UpdateReport)
  */
 func (this *Controller) updateAll(w http.ResponseWriter, r *http.Request) {
+	var c appengine.Context
+	var dao *DAO
+	var report *UpdateReport
+	var response []byte
+	var err error
+	
+	c = appengine.NewContext(r)
+	dao = new(DAO)
+	report = dao.updateAll(c)
+	
+	response, err = json.Marshal(report)
+	check(c, err)
+	
+	fmt.Fprintf(w, "%s", response)
+}
+
+/**
+ * 定期更新の実行記録の一覧画面
+ * key を指定した場合はその回の更新報告を表示する
+ * @methodOf Controller
+ * @param {http.ResponseWriter} w 応答先
+ * @param {*http.Request} r リクエスト
+ * @param {HTTP GET} key 実行記録のキー(省略可)
+ */
+func (this *Controller) updateLogs(w http.ResponseWriter, r *http.Request) {
+	var c appengine.Context
+	var view *View
+	var key string
+	
+	c = appengine.NewContext(r)
+	key = r.FormValue("key")
+	
+	view = new(View)
+	if key == "" {
+		view.showUpdateLogs(c, w)
+	} else {
+		view.showUpdateReport(c, key, w)
+	}
+}
+
+/**
+ * フォルダ・フィード・エントリ・受信元の整合性の検査画面
+ * GET では最後に行った検査の結果を表示する
+ * POST では検査を始め、repair=1 が指定されたときは見つかった問題も修復する
+ * 検査はタスクキューでユーザごとに行うので、結果は終わったものから表示される
+ * @methodOf Controller
+ * @param {http.ResponseWriter} w 応答先
+ * @param {*http.Request} r リクエスト
+ * @param {HTTP POST} repair 修復するなら "1"
+ */
+func (this *Controller) integrity(w http.ResponseWriter, r *http.Request) {
+	var c appengine.Context
+	var dao *DAO
+	var view *View
+	var err error
+	
+	c = appengine.NewContext(r)
+	if r.Method == "POST" {
+		dao = new(DAO)
+		err = dao.startIntegrityCheck(c, r.FormValue("repair") == "1")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/admin/integrity", http.StatusSeeOther)
+		return
+	}
+	
+	view = new(View)
+	view.showIntegrity(c, w)
+}
+
+/**
+ * 整合性の検査の対象になるユーザを queryPageSize 人ずつ検査のタスクに振り分ける
+ * 失敗したらタスクキューにやり直させる
+ * @methodOf Controller
+ * @param {http.ResponseWriter} w 応答先
+ * @param {*http.Request} r リクエスト
+ * @param {HTTP POST} run 検査全体を始めた日時(UNIX時間)
+ * @param {HTTP POST} repair 修復するなら "1"
+ * @param {HTTP POST} cursor 続きから振り分けるときのカーソル
+ * @param {HTTP POST} page 何組目か
+ */
+func (this *Controller) enqueueIntegrityChecks(w http.ResponseWriter, r *http.Request) {
+	var c appengine.Context
+	var dao *DAO
+	var run int64
+	var page int
+	var err error
+	
+	c = appengine.NewContext(r)
+	dao = new(DAO)
+	run, _ = strconv.ParseInt(r.FormValue("run"), 10, 64)
+	page, _ = strconv.Atoi(r.FormValue("page"))
+	err = dao.enqueueIntegrityChecks(c, run, r.FormValue("repair") == "1", r.FormValue("cursor"), page)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+/**
+ * １人のユーザの整合性を検査し、結果を実行記録に保存する
+ * @methodOf Controller
+ * @param {http.ResponseWriter} w 応答先
+ * @param {*http.Request} r リクエスト
+ * @param {HTTP POST} run 検査全体を始めた日時(UNIX時間)
+ * @param {HTTP POST} repair 修復するなら "1"
+ * @param {HTTP POST} owner ユーザID
+ */
+func (this *Controller) checkUserIntegrity(w http.ResponseWriter, r *http.Request) {
+	var c appengine.Context
+	var dao *DAO
+	var owner string
+	var run int64
+	var report *IntegrityReport
+	
+	c = appengine.NewContext(r)
+	dao = new(DAO)
+	owner = r.FormValue("owner")
+	run, _ = strconv.ParseInt(r.FormValue("run"), 10, 64)
+	report = dao.checkIntegrity(c, owner, r.FormValue("repair") == "1")
+	dao.saveIntegrityLog(c, run, join("user-", owner), report)
+}
+
+/**
+ * 受信元を queryPageSize 件ずつ検査し、結果を実行記録に保存する
+ * 続きがあれば次の組を検査するタスクを追加する
+ * @methodOf Controller
+ * @param {http.ResponseWriter} w 応答先
+ * @param {*http.Request} r リクエスト
+ * @param {HTTP POST} run 検査全体を始めた日時(UNIX時間)
+ * @param {HTTP POST} repair 修復するなら "1"
+ * @param {HTTP POST} cursor 続きから検査するときのカーソル
+ * @param {HTTP POST} page 何組目か
+ */
+func (this *Controller) checkSourceIntegrity(w http.ResponseWriter, r *http.Request) {
+	var c appengine.Context
+	var dao *DAO
+	var repair bool
+	var run int64
+	var page int
+	var report *IntegrityReport
+	var cursor string
+	var err error
+	
+	c = appengine.NewContext(r)
+	dao = new(DAO)
+	repair = r.FormValue("repair") == "1"
+	run, _ = strconv.ParseInt(r.FormValue("run"), 10, 64)
+	page, _ = strconv.Atoi(r.FormValue("page"))
+	report, cursor = dao.checkSources(c, r.FormValue("cursor"), repair)
+	dao.saveIntegrityLog(c, run, join("sources-", strconv.Itoa(page)), report)
+	
+	if cursor != "" {
+		page++
+		err = dao.addIntegrityTask(c, "/task/integrity/sources", run, repair, url.Values{"cursor": {cursor}, "page": {strconv.Itoa(page)}}, join("sources-", strconv.Itoa(page)))
+		check(c, err)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+/**
+ * WebSub のハブからのリクエストを処理する
+ * GET は購読・購読解除の確認で、申し込んだ内容と一致すれば hub.challenge をそのまま返す
+ * POST は更新されたフィードのプッシュで、署名が正しければ購読しているフィードに配る
+ * 署名が正しくなくてもハブが再送しないように成功を返す
+ * @methodOf Controller
+ * @param {http.ResponseWriter} w 応答先
+ * @param {*http.Request} r リクエスト
+ * @param {HTTP GET} key エンコード済みの受信元キー
+ */
+func (this *Controller) websub(w http.ResponseWriter, r *http.Request) {
+	var c appengine.Context
+	var dao *DAO
+	var websub *WebSub
+	var key string
+	var mode string
+	var lease int
+	var source *Source
+	var body []byte
+	var delivered map[string][]*Entry
+	var err error
+	
+	c = appengine.NewContext(r)
+	dao = new(DAO)
+	websub = new(WebSub)
+	key = r.FormValue("key")
+	
+	if r.Method == "GET" {
+		mode = r.FormValue("hub.mode")
+		if mode == "denied" {
+			c.Warningf("websub denied: %s %s", r.FormValue("hub.topic"), r.FormValue("hub.reason"))
+			return
+		}
+		lease, _ = strconv.Atoi(r.FormValue("hub.lease_seconds"))
+		if !dao.verifySubscription(c, key, mode, r.FormValue("hub.topic"), lease) {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, "%s", r.FormValue("hub.challenge"))
+		return
+	}
+	
+	body, err = readAll(r.Body)
+	if err != nil {
+		check(c, err)
+		return
+	}
+	source = dao.getSource(c, key)
+	if !source.Push || !websub.validSignature(source.HubSecret, body, r.Header.Get("X-Hub-Signature")) {
+		c.Warningf("websub: invalid signature for %s", source.URL)
+		return
+	}
+	
+	delivered = dao.receivePush(c, key, toUTF8(body, r.Header.Get("Content-Type")))
+	c.Infof("websub: pushed to %d feeds from %s", len(delivered), source.URL)
+}
+
+/**
+ * 保存期間を過ぎた既読エントリを削除する
+ * cronによって1日ごとに実行する
+ * 保存期間は app.yaml の環境変数 READ_RETENTION_DAYS で変更できる
+ * @methodOf Controller
+ * @param {http.ResponseWriter} w 応答先
+ * @param {*http.Request} r リクエスト
+ */
+func (this *Controller) purgeReadEntries(w http.ResponseWriter, r *http.Request) {
+	var c appengine.Context
+	var dao *DAO
+	c = appengine.NewContext(r)
+	dao = new(DAO)
+	dao.purgeReadEntries(c)
+}
+
+/**
+ * 受信元を持たない古いフィードを受信元に登録して定期更新の対象にする
+ * cronによって1日ごとに実行する
+ * @methodOf Controller
+ * @param {http.ResponseWriter} w 応答先
+ * @param {*http.Request} r リクエスト
+ */
+func (this *Controller) subscribeLegacyFeeds(w http.ResponseWriter, r *http.Request) {
+	var c appengine.Context
+	var dao *DAO
+	c = appengine.NewContext(r)
+	dao = new(DAO)
+	dao.subscribeLegacyFeeds(c)
+}
+
+/**
+ * フォルダの未読数の数え直しをユーザごとのタスクに振り分ける
+ * 未読数はエントリが増減するたびに差分を足して保存しているので、ずれたときのためにcronによって1日ごとに実行する
+ * ユーザが多いときは queryPageSize 人ずつ、続きを振り分けるタスクを追加しながら振り分ける
+ * 失敗したらタスクキューにやり直させる
+ * @methodOf Controller
+ * @param {http.ResponseWriter} w 応答先
+ * @param {*http.Request} r リクエスト
+ * @param {HTTP POST} run 振り分けを始めた日時(UNIX時間)　cronからの実行では省略する
+ * @param {HTTP POST} cursor 続きから振り分けるときのカーソル
+ * @param {HTTP POST} page 何組目か
+ */
+func (this *Controller) enqueueRecounts(w http.ResponseWriter, r *http.Request) {
+	var c appengine.Context
+	var dao *DAO
+	var run string
+	var page int
+	var err error
+	
+	c = appengine.NewContext(r)
+	dao = new(DAO)
+	run = r.FormValue("run")
+	if run == "" {
+		run = strconv.FormatInt(time.Now().Unix(), 10)
+	}
+	page, _ = strconv.Atoi(r.FormValue("page"))
+	err = dao.enqueueOwners(c, "/task/recount/user", "/task/recount", url.Values{"run": {run}}, join("recount-", run, "-"), r.FormValue("cursor"), page)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+/**
+ * １人のユーザのフォルダの未読数を数え直す
+ * @methodOf Controller
+ * @param {http.ResponseWriter} w 応答先
+ * @param {*http.Request} r リクエスト
+ * @param {HTTP POST} owner ユーザID
+ */
+func (this *Controller) recountUnread(w http.ResponseWriter, r *http.Request) {
+	var c appengine.Context
+	var dao *DAO
+	c = appengine.NewContext(r)
+	dao = new(DAO)
+	dao.recountUnread(c, r.FormValue("owner"))
+}
+
+/**
+ * 購読期限が近づいた WebSub の購読を更新する
+ * cronによって1時間ごとに実行する
+ * @methodOf Controller
+ * @param {http.ResponseWriter} w 応答先
+ * @param {*http.Request} r リクエスト
+ */
+func (this *Controller) renewSubscriptions(w http.ResponseWriter, r *http.Request) {
 	var c appengine.Context
 	var dao *DAO
 	c = appengine.NewContext(r)
 	dao = new(DAO)
-	dao.updateAll(c)
+	dao.renewSubscriptions(c)
 }
@@ -0,0 +1,322 @@
+/**
+ * 日付文字列の解析と表示
+ * フィードによって日付の書き方がバラバラなので考えられる形式を順番に試す
+ */
+package okareader
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/**
+ * RFC 3339 (W3CDTF, dc:date) の形式
+ * 後ろのものほど省略が多い
+ */
+var w3cLayouts = []string{
+	time.RFC3339Nano,
+	time.RFC3339,
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04Z0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"2006-01",
+}
+
+/**
+ * RFC 822 (RSS2.0 の pubDate) の形式
+ * 曜日とタイムゾーン名は事前に取り除いてから解析する
+ */
+var rfc822Layouts = []string{
+	"2 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04:05 -07:00",
+	"2 Jan 2006 15:04 -0700",
+	"2 Jan 06 15:04:05 -0700",
+	"2 Jan 06 15:04 -0700",
+	"2 January 2006 15:04:05 -0700",
+	"2 January 2006 15:04 -0700",
+	"2 Jan 2006 15:04:05",
+	"2 Jan 2006 15:04",
+	"2 Jan 2006",
+	"Jan 2 2006 15:04:05 -0700",
+	"Jan 2 15:04:05 -0700 2006",
+	"Jan 2 15:04:05 2006",
+}
+
+/**
+ * タイムゾーン名と時差の対応
+ */
+var zoneOffsets = map[string]string{
+	"UT": "+0000",
+	"UTC": "+0000",
+	"GMT": "+0000",
+	"Z": "+0000",
+	"JST": "+0900",
+	"KST": "+0900",
+	"EST": "-0500",
+	"EDT": "-0400",
+	"CST": "-0600",
+	"CDT": "-0500",
+	"MST": "-0700",
+	"MDT": "-0600",
+	"PST": "-0800",
+	"PDT": "-0700",
+	"CET": "+0100",
+	"CEST": "+0200",
+	"BST": "+0100",
+}
+
+/**
+ * 日本語の日付にマッチする正規表現
+ * 2013年3月5日(火) 午後1時23分, 2013/03/05 13:23:45 など
+ */
+var japaneseDatePattern = regexp.MustCompile(`(\d{4})\s*[年/.\-]\s*(\d{1,2})\s*[月/.\-]\s*(\d{1,2})\s*日?\s*(?:[(（][^)）]*[)）])?\s*(午前|午後|AM|PM|am|pm)?\s*(?:(\d{1,2})\s*[:：時]\s*(\d{1,2})\s*分?\s*(?:[:：]?\s*(\d{1,2})\s*秒?)?)?`)
+
+/**
+ * 日本時間
+ */
+var jst = time.FixedZone("JST", 9 * 60 * 60)
+
+/**
+ * 日付文字列を解析する
+ * RFC 3339, RFC 822 とそれらの崩れた形式、日本語の日付に対応する
+ * @function
+ * @param {string} str 日付文字列
+ * @returns {time.Time} 解析した日時　解析できなければゼロ値
+ */
+func parseDate(str string) time.Time {
+	var result time.Time
+	var err error
+	var layout string
+	var normalized string
+	
+	str = strings.TrimSpace(str)
+	if str == "" {
+		return result
+	}
+	
+	// RFC 3339
+	for _, layout = range w3cLayouts {
+		result, err = time.Parse(layout, str)
+		if err == nil {
+			return result
+		}
+	}
+	
+	// RFC 822
+	normalized = normalizeRFC822(str)
+	for _, layout = range rfc822Layouts {
+		result, err = time.Parse(layout, normalized)
+		if err == nil {
+			return result
+		}
+	}
+	
+	// 日本語の日付
+	result = parseJapaneseDate(str)
+	
+	return result
+}
+
+/**
+ * RFC 822 形式の日付を解析しやすい形に整える
+ * 曜日を取り除き、タイムゾーン名を時差に置き換える
+ * @function
+ * @param {string} str 日付文字列
+ * @returns {string} 整形した日付文字列
+ */
+func normalizeRFC822(str string) string {
+	var fields []string
+	var result []string
+	var field string
+	var offset string
+	var ok bool
+	var i int
+	
+	str = strings.Replace(str, ",", " ", -1)
+	fields = strings.Fields(str)
+	result = make([]string, 0, len(fields))
+	for i, field = range fields {
+		
+		// 先頭の曜日は読み飛ばす
+		if i == 0 && isWeekday(field) {
+			continue
+		}
+		
+		// "(JST)" のような括弧書きは読み飛ばす
+		if strings.HasPrefix(field, "(") {
+			continue
+		}
+		
+		// "GMT+0900" は時差だけを残す
+		if len(field) > 3 && (field[3] == '+' || field[3] == '-') && zoneOffsets[strings.ToUpper(field[:3])] != "" {
+			field = field[3:]
+		}
+		
+		offset, ok = zoneOffsets[strings.ToUpper(field)]
+		if ok {
+			field = offset
+		}
+		result = append(result, field)
+	}
+	
+	return strings.Join(result, " ")
+}
+
+/**
+ * 曜日名かどうか調べる
+ * @function
+ * @param {string} str 調べる文字列
+ * @returns {bool} 曜日名ならtrue
+ */
+func isWeekday(str string) bool {
+	var day time.Weekday
+	var name string
+	
+	str = strings.ToLower(strings.TrimRight(str, "."))
+	for day = time.Sunday; day <= time.Saturday; day++ {
+		name = strings.ToLower(day.String())
+		if str == name || str == name[:3] {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * 日本語の日付を解析する
+ * タイムゾーンが書かれていないので日本時間とみなす
+ * @function
+ * @param {string} str 日付文字列
+ * @returns {time.Time} 解析した日時　解析できなければゼロ値
+ */
+func parseJapaneseDate(str string) time.Time {
+	var match []string
+	var numbers [6]int
+	var i int
+	var result time.Time
+	
+	match = japaneseDatePattern.FindStringSubmatch(str)
+	if match == nil {
+		return result
+	}
+	
+	// 年月日時分秒の順に数値化する
+	numbers[0], _ = strconv.Atoi(match[1])
+	numbers[1], _ = strconv.Atoi(match[2])
+	numbers[2], _ = strconv.Atoi(match[3])
+	for i = 5; i <= 7; i++ {
+		if match[i] != "" {
+			numbers[i - 2], _ = strconv.Atoi(match[i])
+		}
+	}
+	
+	// 午後なら12時間進める
+	switch match[4] {
+		case "午後", "PM", "pm":
+			if numbers[3] < 12 {
+				numbers[3] = numbers[3] + 12
+			}
+		case "午前", "AM", "am":
+			if numbers[3] == 12 {
+				numbers[3] = 0
+			}
+	}
+	
+	if numbers[1] < 1 || numbers[1] > 12 || numbers[2] < 1 || numbers[2] > 31 {
+		return result
+	}
+	
+	result = time.Date(numbers[0], time.Month(numbers[1]), numbers[2], numbers[3], numbers[4], numbers[5], 0, jst)
+	return result
+}
+
+/**
+ * 日時を現在からの経過時間で表す
+ * @function
+ * @param {time.Time} t 日時
+ * @param {time.Time} now 現在時刻
+ * @returns {string} "5分前" などの文字列　日時がゼロ値なら空文字列
+ */
+func relativeTime(t time.Time, now time.Time) string {
+	var d time.Duration
+	var result string
+	
+	if t.IsZero() {
+		return ""
+	}
+	
+	d = now.Sub(t)
+	switch {
+		case d < time.Minute:
+			result = "たった今"
+		case d < time.Hour:
+			result = fmt.Sprintf("%d分前", int(d / time.Minute))
+		case d < 24 * time.Hour:
+			result = fmt.Sprintf("%d時間前", int(d / time.Hour))
+		case d < 30 * 24 * time.Hour:
+			result = fmt.Sprintf("%d日前", int(d / (24 * time.Hour)))
+		default:
+			result = t.In(jst).Format("2006/01/02")
+	}
+	
+	return result
+}
+
+/**
+ * 再生時間を秒数に変換する
+ * itunes:duration の "1:02:03", "62:03", "3723" のいずれの形式にも対応する
+ * @function
+ * @param {string} str 再生時間
+ * @returns {int} 秒数　解析できなければ0
+ */
+func parseDuration(str string) int {
+	var parts []string
+	var part string
+	var n int
+	var err error
+	var result int
+	
+	str = strings.TrimSpace(str)
+	if str == "" {
+		return 0
+	}
+	
+	parts = strings.Split(str, ":")
+	if len(parts) > 3 {
+		return 0
+	}
+	for _, part = range parts {
+		n, err = strconv.Atoi(strings.Split(part, ".")[0])
+		if err != nil {
+			return 0
+		}
+		result = result * 60 + n
+	}
+	
+	return result
+}
+
+/**
+ * 秒数を再生時間の表記にする
+ * @function
+ * @param {int} seconds 秒数
+ * @returns {string} "1:02:03" や "2:03" などの文字列　0なら空文字列
+ */
+func formatDuration(seconds int) string {
+	if seconds <= 0 {
+		return ""
+	}
+	if seconds >= 60 * 60 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds / 3600, seconds / 60 % 60, seconds % 60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds / 60, seconds % 60)
+}
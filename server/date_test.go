@@ -0,0 +1,93 @@
+/**
+ * 日付文字列の解析のテスト
+ */
+package okareader
+import (
+	"testing"
+	"time"
+)
+
+/**
+ * フィードでよく見る形式がすべて 2013/3/5 13:23:45 JST と解析されるか確かめる
+ * @function
+ * @param {*testing.T} t テスト
+ */
+func TestParseDateFormats(t *testing.T) {
+	var expected time.Time
+	var input string
+	var actual time.Time
+	
+	expected = time.Date(2013, 3, 5, 13, 23, 45, 0, jst)
+	for _, input = range []string{
+		"2013-03-05T13:23:45+09:00",
+		"2013-03-05T04:23:45Z",
+		"2013-03-05T13:23:45+0900",
+		"2013-03-05 13:23:45 +0900",
+		"Tue, 05 Mar 2013 13:23:45 +0900",
+		"Tue, 05 Mar 2013 04:23:45 GMT",
+		"Tue, 05 Mar 2013 13:23:45 JST",
+		"Tue, 5 Mar 2013 13:23:45 GMT+0900",
+	} {
+		actual = parseDate(input)
+		if !actual.Equal(expected) {
+			t.Errorf("parseDate(%q) = %v, want %v", input, actual, expected)
+		}
+	}
+}
+
+/**
+ * タイムゾーンのない日付と日本語の日付を確かめる
+ * タイムゾーンがなければ日本時間とみなす
+ * @function
+ * @param {*testing.T} t テスト
+ */
+func TestParseDateLocal(t *testing.T) {
+	var actual time.Time
+	
+	actual = parseDate("2013/03/05 13:23:45")
+	if !actual.Equal(time.Date(2013, 3, 5, 13, 23, 45, 0, jst)) {
+		t.Errorf("slash separated date was parsed as %v", actual)
+	}
+	
+	actual = parseDate("Tuesday, 05 March 2013 13:23 +0900")
+	if !actual.Equal(time.Date(2013, 3, 5, 13, 23, 0, 0, jst)) {
+		t.Errorf("long day and month names were parsed as %v", actual)
+	}
+	
+	actual = parseDate("2013年3月5日(火) 午後1時23分")
+	if !actual.Equal(time.Date(2013, 3, 5, 13, 23, 0, 0, jst)) {
+		t.Errorf("japanese afternoon was parsed as %v", actual)
+	}
+	
+	// 午前12時は0時
+	actual = parseDate("2013年3月5日 午前12時05分")
+	if !actual.Equal(time.Date(2013, 3, 5, 0, 5, 0, 0, jst)) {
+		t.Errorf("japanese midnight was parsed as %v", actual)
+	}
+	
+	actual = parseDate("  2013年12月24日  ")
+	if !actual.Equal(time.Date(2013, 12, 24, 0, 0, 0, 0, jst)) {
+		t.Errorf("japanese date with spaces was parsed as %v", actual)
+	}
+	
+	// ISO 8601 の日付だけのものは UTC の0時
+	actual = parseDate("2013-03-05")
+	if !actual.Equal(time.Date(2013, 3, 5, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("date only was parsed as %v", actual)
+	}
+}
+
+/**
+ * 解析できない文字列にはゼロ値を返すか確かめる
+ * @function
+ * @param {*testing.T} t テスト
+ */
+func TestParseDateInvalid(t *testing.T) {
+	var input string
+	
+	for _, input = range []string{"", "not a date", "2013年13月5日"} {
+		if !parseDate(input).IsZero() {
+			t.Errorf("parseDate(%q) = %v, want zero time", input, parseDate(input))
+		}
+	}
+}
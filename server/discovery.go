@@ -0,0 +1,280 @@
+/**
+ * ウェブページからフィードを探す
+ * ブログのトップページなどのURLが入力されたときに使用する
+ */
+package okareader
+import (
+	"appengine"
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+/**
+ * フィードの自動検出
+ * @class
+ */
+type Discovery struct {
+}
+
+/**
+ * 見つかったフィードの候補
+ * @class
+ * @member {string} URL フィードの場所
+ * @member {string} Title フィードのタイトル
+ * @member {string} Type フィードのMIMEタイプ
+ */
+type Candidate struct {
+	URL string `json:"url"`
+	Title string `json:"title"`
+	Type string `json:"type"`
+}
+
+/**
+ * link要素にマッチする正規表現
+ */
+var linkPattern = regexp.MustCompile(`(?is)<link\b[^>]*>`)
+
+/**
+ * 要素の属性にマッチする正規表現
+ */
+var attributePattern = regexp.MustCompile(`(?s)([a-zA-Z_:\-]+)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+
+/**
+ * フィードとして扱うMIMEタイプ
+ */
+var feedTypes = map[string]bool{
+	"application/rss+xml": true,
+	"application/atom+xml": true,
+	"application/rdf+xml": true,
+	"application/feed+json": true,
+}
+
+/**
+ * link要素が見つからなかったときに試すパス
+ */
+var commonFeedPaths = []string{
+	"/feed",
+	"/rss.xml",
+	"/atom.xml",
+	"/index.rdf",
+	"/feed.json",
+}
+
+/**
+ * よく使われるパスを調べるときの制限時間
+ * すべてのパスを同時に調べるので、全体でもこの時間で終わる
+ */
+const probeTimeout = 10 * time.Second
+
+/**
+ * ウェブページからフィードの候補を探す
+ * link rel="alternate" で宣言されたフィードを返す
+ * 宣言がなければよく使われるパスにフィードがあるか調べる
+ * @methodOf Discovery
+ * @param {appengine.Context} c コンテキスト
+ * @param {string} pageURL ウェブページのURL
+ * @param {[]byte} page ウェブページのHTML
+ * @returns {[]*Candidate} フィードの候補
+ */
+func (this *Discovery) find(c appengine.Context, pageURL string, page []byte) []*Candidate {
+	var candidates []*Candidate
+	
+	candidates = this.findLinks(pageURL, page)
+	if len(candidates) == 0 {
+		candidates = this.probe(c, pageURL)
+	}
+	
+	return candidates
+}
+
+/**
+ * HTMLの link rel="alternate" からフィードを探す
+ * @methodOf Discovery
+ * @param {string} pageURL ウェブページのURL　相対URLの解決に使う
+ * @param {[]byte} page ウェブページのHTML
+ * @returns {[]*Candidate} フィードの候補
+ */
+func (this *Discovery) findLinks(pageURL string, page []byte) []*Candidate {
+	var candidates []*Candidate
+	var candidate *Candidate
+	var base *url.URL
+	var href *url.URL
+	var tag []byte
+	var attributes map[string]string
+	var rel string
+	var mediaType string
+	var err error
+	var found map[string]bool
+	
+	candidates = make([]*Candidate, 0)
+	found = make(map[string]bool)
+	
+	base, err = url.Parse(pageURL)
+	if err != nil {
+		return candidates
+	}
+	
+	for _, tag = range linkPattern.FindAll(page, -1) {
+		attributes = this.attributes(tag)
+		
+		rel = strings.ToLower(attributes["rel"])
+		mediaType = strings.ToLower(strings.TrimSpace(attributes["type"]))
+		if !this.hasToken(rel, "alternate") || !feedTypes[mediaType] || attributes["href"] == "" {
+			continue
+		}
+		
+		href, err = base.Parse(attributes["href"])
+		if err != nil || found[href.String()] {
+			continue
+		}
+		found[href.String()] = true
+		
+		candidate = new(Candidate)
+		candidate.URL = href.String()
+		candidate.Title = attributes["title"]
+		candidate.Type = mediaType
+		candidates = append(candidates, candidate)
+	}
+	
+	return candidates
+}
+
+/**
+ * よく使われるパスにフィードがあるか調べる
+ * すべてのパスを同時に受信し、probeTimeout までに見つかったものだけを返す
+ * リダイレクトされて同じフィードにたどり着いたものは、commonFeedPaths で先にあるパスだけを残す
+ * @methodOf Discovery
+ * @param {appengine.Context} c コンテキスト
+ * @param {string} pageURL ウェブページのURL
+ * @returns {[]*Candidate} 見つかったフィード
+ */
+func (this *Discovery) probe(c appengine.Context, pageURL string) []*Candidate {
+	type ProbeResult struct {
+		Index int
+		Candidate *Candidate
+		FinalURL string
+	}
+	var candidates []*Candidate
+	var base *url.URL
+	var target *url.URL
+	var path string
+	var results chan *ProbeResult
+	var found []*ProbeResult
+	var result *ProbeResult
+	var timer <-chan time.Time
+	var received int
+	var expired bool
+	var finalURLs map[string]bool
+	var i int
+	var err error
+	
+	candidates = make([]*Candidate, 0)
+	
+	base, err = url.Parse(pageURL)
+	if err != nil {
+		return candidates
+	}
+	
+	// 制限時間を過ぎて返ってきた結果で止まらないように、全件分のバッファを持たせる
+	results = make(chan *ProbeResult, len(commonFeedPaths))
+	for i, path = range commonFeedPaths {
+		target, err = base.Parse(path)
+		if err != nil {
+			results <- &ProbeResult{Index: i}
+			continue
+		}
+		go func(index int, targetURL string) {
+			var result *ProbeResult
+			var fetched *FetchResult
+			var feed *Feed
+			var err error
+			
+			result = &ProbeResult{Index: index}
+			defer func() {
+				recover()
+				results <- result
+			}()
+			
+			fetched, err = fetchWithin(c, targetURL, probeTimeout)
+			if err != nil {
+				return
+			}
+			feed, _, err = parseFeed(c, fetched.Body, "")
+			if err != nil || feed == nil {
+				return
+			}
+			result.Candidate = &Candidate{targetURL, feed.Title, feed.Standard}
+			result.FinalURL = fetched.FinalURL
+		}(i, target.String())
+	}
+	
+	found = make([]*ProbeResult, len(commonFeedPaths))
+	timer = time.After(probeTimeout)
+	for received < len(commonFeedPaths) && !expired {
+		select {
+			case result = <-results:
+				found[result.Index] = result
+				received++
+			case <-timer:
+				expired = true
+		}
+	}
+	
+	finalURLs = make(map[string]bool)
+	for _, result = range found {
+		if result == nil || result.Candidate == nil || finalURLs[normalizeURL(result.FinalURL)] {
+			continue
+		}
+		finalURLs[normalizeURL(result.FinalURL)] = true
+		candidates = append(candidates, result.Candidate)
+	}
+	
+	return candidates
+}
+
+/**
+ * 要素の属性を取り出す
+ * @methodOf Discovery
+ * @param {[]byte} tag 要素の開始タグ
+ * @returns {map[string]string} 属性名(小文字)と値の対応
+ */
+func (this *Discovery) attributes(tag []byte) map[string]string {
+	var result map[string]string
+	var match [][]byte
+	var value []byte
+	
+	result = make(map[string]string)
+	for _, match = range attributePattern.FindAllSubmatch(tag, -1) {
+		value = match[2]
+		if value == nil {
+			value = match[3]
+		}
+		if value == nil {
+			value = match[4]
+		}
+		result[strings.ToLower(string(match[1]))] = html.UnescapeString(string(value))
+	}
+	
+	return result
+}
+
+/**
+ * 空白区切りの属性値(rel など)に指定した値が含まれるか調べる
+ * @methodOf Discovery
+ * @param {string} value 属性値
+ * @param {string} token 探す値
+ * @returns {bool} 含まれていればtrue
+ */
+func (this *Discovery) hasToken(value string, token string) bool {
+	var field string
+	
+	for _, field = range strings.Fields(value) {
+		if field == token {
+			return true
+		}
+	}
+	return false
+}
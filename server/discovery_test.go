@@ -0,0 +1,79 @@
+/**
+ * フィードの自動検出のテスト
+ */
+package okareader
+import (
+	"testing"
+)
+
+/**
+ * https://example.com/blog/ にあるページとして link要素を探す
+ * @function
+ * @param {string} page ページのHTML
+ * @returns {[]*Candidate} 見つかった候補
+ */
+func findLinksIn(page string) []*Candidate {
+	var discovery *Discovery
+	
+	discovery = new(Discovery)
+	return discovery.findLinks("https://example.com/blog/", []byte(page))
+}
+
+/**
+ * RSS と Atom の link要素を順番通りに取り出し、相対URLを解決するか確かめる
+ * @function
+ * @param {*testing.T} t テスト
+ */
+func TestFindLinksAlternate(t *testing.T) {
+	var candidates []*Candidate
+	
+	candidates = findLinksIn(`<head><link rel="alternate" type="application/rss+xml" title="RSS" href="/rss.xml"><link rel="alternate" type="application/atom+xml" title="Atom" href="https://example.com/atom.xml"></head>`)
+	if len(candidates) != 2 {
+		t.Fatalf("found %d candidates, want 2", len(candidates))
+	}
+	if *candidates[0] != (Candidate{"https://example.com/rss.xml", "RSS", "application/rss+xml"}) {
+		t.Errorf("first candidate = %+v", *candidates[0])
+	}
+	if *candidates[1] != (Candidate{"https://example.com/atom.xml", "Atom", "application/atom+xml"}) {
+		t.Errorf("second candidate = %+v", *candidates[1])
+	}
+}
+
+/**
+ * 大文字の要素名, 属性の順番, 引用符のない属性, 複数の rel を受け付けるか確かめる
+ * @function
+ * @param {*testing.T} t テスト
+ */
+func TestFindLinksLooseMarkup(t *testing.T) {
+	var candidates []*Candidate
+	
+	candidates = findLinksIn(`<LINK HREF='feed.json' TYPE='application/feed+json' REL='alternate' TITLE='JSON &amp; more'>`)
+	if len(candidates) != 1 || *candidates[0] != (Candidate{"https://example.com/blog/feed.json", "JSON & more", "application/feed+json"}) {
+		t.Errorf("upper case link: got %v", candidates)
+	}
+	
+	candidates = findLinksIn(`<link rel="alternate home" type=application/rdf+xml href=index.rdf />`)
+	if len(candidates) != 1 || *candidates[0] != (Candidate{"https://example.com/blog/index.rdf", "", "application/rdf+xml"}) {
+		t.Errorf("unquoted link: got %v", candidates)
+	}
+}
+
+/**
+ * 同じフィードを指す link要素は一つにまとめ、フィードでないものは無視するか確かめる
+ * @function
+ * @param {*testing.T} t テスト
+ */
+func TestFindLinksSkips(t *testing.T) {
+	var candidates []*Candidate
+	
+	candidates = findLinksIn(`<link rel="alternate" type="application/rss+xml" href="/rss.xml"><link rel="alternate" type="application/rss+xml" href="https://example.com/rss.xml">`)
+	if len(candidates) != 1 {
+		t.Errorf("duplicate links: found %d candidates, want 1", len(candidates))
+	}
+	
+	// スタイルシート, 別言語のページ, href のないもの
+	candidates = findLinksIn(`<link rel="stylesheet" type="text/css" href="/style.css"><link rel="alternate" hreflang="en" href="/en/"><link rel="alternate" type="application/rss+xml">`)
+	if len(candidates) != 0 {
+		t.Errorf("links that are not feeds: found %v", candidates)
+	}
+}
@@ -0,0 +1,391 @@
+/**
+ * フィードやウェブページの受信
+ * 受信サイズの上限、タイムアウト、圧縮に対応する
+ */
+package okareader
+import (
+	"appengine"
+	"appengine/urlfetch"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/**
+ * 受信するデータの最大バイト数
+ */
+const maxFetchSize = 5 * 1024 * 1024
+
+/**
+ * 受信のタイムアウト
+ */
+const fetchTimeout = 30 * time.Second
+
+/**
+ * たどるリダイレクトの最大回数
+ */
+const maxRedirects = 10
+
+/**
+ * 同じ移転先へ何回続けてリダイレクトされたらフィードのURLを書き換えるか
+ */
+const redirectThreshold = 3
+
+/**
+ * 受信結果
+ * @class
+ * @member {string} URL 受信したURL
+ * @member {string} FinalURL リダイレクトをたどった後の最終的なURL
+ * @member {string} PermanentURL 恒久的なリダイレクト(301, 308)の移転先　リダイレクトされなければ空文字列
+ * @member {int} StatusCode HTTPのステータスコード
+ * @member {http.Header} Header HTTPの応答ヘッダ
+ * @member {[]byte} Body 受信したデータ(UTF-8に変換済み)
+ */
+type FetchResult struct {
+	URL string
+	FinalURL string
+	PermanentURL string
+	StatusCode int
+	Header http.Header
+	Body []byte
+}
+
+/**
+ * 成功以外のHTTPステータスが返ってきたときのエラー
+ * @class
+ * @member {string} URL 受信しようとしたURL
+ * @member {int} StatusCode HTTPのステータスコード
+ */
+type HTTPStatusError struct {
+	URL string
+	StatusCode int
+}
+
+/**
+ * エラーメッセージ
+ * @methodOf HTTPStatusError
+ * @returns {string} エラーメッセージ
+ */
+func (this *HTTPStatusError) Error() string {
+	return fmt.Sprintf("fetch %s: HTTP status %d", this.URL, this.StatusCode)
+}
+
+/**
+ * 受信がタイムアウトしたときのエラー
+ * @class
+ * @member {string} URL 受信しようとしたURL
+ */
+type TimeoutError struct {
+	URL string
+}
+
+/**
+ * エラーメッセージ
+ * @methodOf TimeoutError
+ * @returns {string} エラーメッセージ
+ */
+func (this *TimeoutError) Error() string {
+	return fmt.Sprintf("fetch %s: timeout", this.URL)
+}
+
+/**
+ * 受信データが大きすぎるときのエラー
+ * @class
+ * @member {string} URL 受信しようとしたURL
+ * @member {int64} Limit 最大バイト数
+ */
+type TooLargeError struct {
+	URL string
+	Limit int64
+}
+
+/**
+ * エラーメッセージ
+ * @methodOf TooLargeError
+ * @returns {string} エラーメッセージ
+ */
+func (this *TooLargeError) Error() string {
+	return fmt.Sprintf("fetch %s: larger than %d bytes", this.URL, this.Limit)
+}
+
+/**
+ * 指定されたURLからデータを受信する
+ * 受信したデータは展開してから UTF-8 に変換する
+ * @function
+ * @param {appengine.Context} c コンテキスト
+ * @param {string} url URL
+ * @returns {*FetchResult} 受信結果　エラーのときはnil
+ * @returns {error} *HTTPStatusError, *TimeoutError, *TooLargeError またはその他のエラー
+ */
+func fetch(c appengine.Context, url string) (*FetchResult, error) {
+	return fetchConditional(c, url, "", "")
+}
+
+/**
+ * 前回から変更されている場合だけデータを受信する
+ * If-None-Match, If-Modified-Since を送信し、変更がなければ
+ * StatusCode が 304 で Body が nil の受信結果を返す
+ * @function
+ * @param {appengine.Context} c コンテキスト
+ * @param {string} url URL
+ * @param {string} etag 前回の ETag　なければ空文字列
+ * @param {string} lastModified 前回の Last-Modified　なければ空文字列
+ * @returns {*FetchResult} 受信結果　エラーのときはnil
+ * @returns {error} *HTTPStatusError, *TimeoutError, *TooLargeError またはその他のエラー
+ */
+func fetchConditional(c appengine.Context, url string, etag string, lastModified string) (*FetchResult, error) {
+	return fetchRequest(c, url, etag, lastModified, fetchTimeout)
+}
+
+/**
+ * タイムアウトを指定してデータを受信する
+ * 短い時間で見切りをつけたい場合に使う
+ * @function
+ * @param {appengine.Context} c コンテキスト
+ * @param {string} url URL
+ * @param {time.Duration} timeout タイムアウト
+ * @returns {*FetchResult} 受信結果　エラーのときはnil
+ * @returns {error} *HTTPStatusError, *TimeoutError, *TooLargeError またはその他のエラー
+ */
+func fetchWithin(c appengine.Context, url string, timeout time.Duration) (*FetchResult, error) {
+	return fetchRequest(c, url, "", "", timeout)
+}
+
+/**
+ * データを受信する
+ * fetch, fetchConditional, fetchWithin の共通部分
+ * @function
+ * @param {appengine.Context} c コンテキスト
+ * @param {string} url URL
+ * @param {string} etag 前回の ETag　なければ空文字列
+ * @param {string} lastModified 前回の Last-Modified　なければ空文字列
+ * @param {time.Duration} timeout タイムアウト
+ * @returns {*FetchResult} 受信結果　エラーのときはnil
+ * @returns {error} *HTTPStatusError, *TimeoutError, *TooLargeError またはその他のエラー
+ */
+func fetchRequest(c appengine.Context, url string, etag string, lastModified string, timeout time.Duration) (*FetchResult, error) {
+	var client *http.Client
+	var request *http.Request
+	var response *http.Response
+	var body []byte
+	var result *FetchResult
+	var permanentURL string
+	var permanent bool
+	var err error
+	
+	client = httpClient(c)
+	client.Transport = &urlfetch.Transport{Context: c, Deadline: timeout}
+	
+	// 最初から続けて恒久的なリダイレクトだった場合だけ移転先として記録する
+	// 途中で一時的なリダイレクト(302, 303, 307)があればそれ以降は記録しない
+	permanent = true
+	client.CheckRedirect = func(next *http.Request, via []*http.Request) error {
+		if len(via) >= maxRedirects {
+			return fmt.Errorf("fetch %s: too many redirects", url)
+		}
+		if permanent && next.Response != nil && isPermanentRedirect(next.Response.StatusCode) {
+			permanentURL = next.URL.String()
+		} else {
+			permanent = false
+		}
+		return nil
+	}
+	
+	request, err = http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept-Encoding", "gzip, deflate")
+	if etag != "" {
+		request.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		request.Header.Set("If-Modified-Since", lastModified)
+	}
+	
+	response, err = client.Do(request)
+	if err != nil {
+		if isTimeout(err) {
+			return nil, &TimeoutError{url}
+		}
+		return nil, err
+	}
+	defer response.Body.Close()
+	
+	result = new(FetchResult)
+	result.URL = url
+	result.FinalURL = response.Request.URL.String()
+	result.PermanentURL = permanentURL
+	result.StatusCode = response.StatusCode
+	result.Header = response.Header
+	
+	if response.StatusCode == http.StatusNotModified {
+		return result, nil
+	}
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return nil, &HTTPStatusError{url, response.StatusCode}
+	}
+	if response.ContentLength > maxFetchSize {
+		return nil, &TooLargeError{url, maxFetchSize}
+	}
+	
+	body, err = readAll(response.Body)
+	if err == nil {
+		body, err = decompress(body, response.Header.Get("Content-Encoding"))
+	}
+	if err != nil {
+		if isTimeout(err) {
+			return nil, &TimeoutError{url}
+		}
+		if err == errTooLarge {
+			return nil, &TooLargeError{url, maxFetchSize}
+		}
+		return nil, err
+	}
+	
+	result.Body = toUTF8(body, response.Header.Get("Content-Type"))
+	
+	return result, nil
+}
+
+/**
+ * タイムアウトを設定したHTTPクライアントを作る
+ * @function
+ * @param {appengine.Context} c コンテキスト
+ * @returns {*http.Client} HTTPクライアント
+ */
+func httpClient(c appengine.Context) *http.Client {
+	var client *http.Client
+	
+	client = new(http.Client)
+	client.Transport = &urlfetch.Transport{Context: c, Deadline: fetchTimeout}
+	return client
+}
+
+/**
+ * 指定されたURLからXMLファイルを受信して返す
+ * エラーの種類を区別しなくてよい場合に使う
+ * @function
+ * @param {appengine.Context} c コンテキスト
+ * @param {string} url URL
+ * @returns {[]byte} 受信したXMLデータ、取得できなかったら nil を返す
+ */
+func getXML(c appengine.Context, url string) []byte {
+	var result *FetchResult
+	var err error
+	
+	result, err = fetch(c, url)
+	check(c, err)
+	if err != nil {
+		return nil
+	}
+	return result.Body
+}
+
+/**
+ * 最大バイト数を超えたことを表す内部エラー
+ */
+var errTooLarge = errors.New("too large")
+
+/**
+ * 最大バイト数までデータを読み込む
+ * @function
+ * @param {io.Reader} reader 読み込み元
+ * @returns {[]byte} 読み込んだデータ
+ * @returns {error} 最大バイト数を超えたら errTooLarge
+ */
+func readAll(reader io.Reader) ([]byte, error) {
+	var data []byte
+	var err error
+	
+	data, err = ioutil.ReadAll(io.LimitReader(reader, maxFetchSize + 1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxFetchSize {
+		return nil, errTooLarge
+	}
+	return data, nil
+}
+
+/**
+ * Content-Encoding に従ってデータを展開する
+ * deflate は zlib 形式と生の deflate 形式の両方が使われているのでどちらにも対応する
+ * @function
+ * @param {[]byte} data 受信したデータ
+ * @param {string} encoding Content-Encoding ヘッダ
+ * @returns {[]byte} 展開したデータ
+ * @returns {error} エラー
+ */
+func decompress(data []byte, encoding string) ([]byte, error) {
+	var reader io.ReadCloser
+	var err error
+	
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+		case "gzip", "x-gzip":
+			reader, err = gzip.NewReader(bytes.NewReader(data))
+		case "deflate":
+			reader, err = zlib.NewReader(bytes.NewReader(data))
+			if err != nil {
+				reader, err = flate.NewReader(bytes.NewReader(data)), nil
+			}
+		default:
+			return data, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	
+	return readAll(reader)
+}
+
+/**
+ * 配信が終了した(410 Gone)ことを表すエラーかどうか調べる
+ * @function
+ * @param {error} err fetch が返したエラー
+ * @returns {bool} 410 Gone ならtrue
+ */
+func isGone(err error) bool {
+	var statusErr *HTTPStatusError
+	var ok bool
+	
+	statusErr, ok = err.(*HTTPStatusError)
+	return ok && statusErr.StatusCode == http.StatusGone
+}
+
+/**
+ * 恒久的なリダイレクトを表すステータスコードかどうか調べる
+ * @function
+ * @param {int} statusCode HTTPのステータスコード
+ * @returns {bool} 301 または 308 ならtrue
+ */
+func isPermanentRedirect(statusCode int) bool {
+	return statusCode == http.StatusMovedPermanently || statusCode == 308
+}
+
+/**
+ * タイムアウトによるエラーかどうか調べる
+ * @function
+ * @param {error} err エラー
+ * @returns {bool} タイムアウトならtrue
+ */
+func isTimeout(err error) bool {
+	var netErr net.Error
+	var ok bool
+	
+	netErr, ok = err.(net.Error)
+	if ok && netErr.Timeout() {
+		return true
+	}
+	return strings.Contains(err.Error(), "DEADLINE_EXCEEDED") || strings.Contains(strings.ToLower(err.Error()), "timeout")
+}
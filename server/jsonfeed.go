@@ -0,0 +1,189 @@
+/**
+ * JSON Feed 1.0/1.1 の読み込み
+ * jsonデータを受け取ってFeedとEntryリストを返す
+ */
+package okareader
+import (
+	"appengine"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+/**
+ * JSON Feed
+ * @class
+ */
+type JSONFeed struct {
+}
+
+/**
+ * 中身が JSON Feed なら登録する
+ */
+func init() {
+	var jsonFeed *JSONFeed
+	jsonFeed = new(JSONFeed)
+	registerParser("JSONFeed", jsonFeed.detect, jsonFeed)
+}
+
+/**
+ * JSON Feed のバージョンを表すURLの接頭辞
+ */
+const jsonFeedVersion = "://jsonfeed.org/version/1"
+
+/**
+ * JSONをFeedオブジェクトに変換する
+ * @methodOf JSONFeed
+ * @param {appengine.Context} c コンテキスト
+ * @param {[]byte} jsondata 変換するJSONデータ
+ * @returns {*Feed} 変換結果のフィード
+ * @returns {[]*Entry} 変換結果のエントリ
+ * @returns {error} 読み込めなかったときのエラー
+ */
+func (this *JSONFeed) encode(c appengine.Context, jsondata []byte) (*Feed, []*Entry, error) {
+	type Item struct {
+		ID json.RawMessage `json:"id"`
+		URL string `json:"url"`
+		ExternalURL string `json:"external_url"`
+		Title string `json:"title"`
+		ContentHTML string `json:"content_html"`
+		ContentText string `json:"content_text"`
+		Summary string `json:"summary"`
+		DatePublished string `json:"date_published"`
+		DateModified string `json:"date_modified"`
+		Attachments []struct {
+			URL string `json:"url"`
+			MIMEType string `json:"mime_type"`
+			SizeInBytes int64 `json:"size_in_bytes"`
+			DurationInSeconds float64 `json:"duration_in_seconds"`
+		} `json:"attachments"`
+	}
+	type Template struct {
+		Version string `json:"version"`
+		Title string `json:"title"`
+		HomePageURL string `json:"home_page_url"`
+		FeedURL string `json:"feed_url"`
+		Icon string `json:"icon"`
+		Hubs []struct {
+			Type string `json:"type"`
+			URL string `json:"url"`
+		} `json:"hubs"`
+		Items []*Item `json:"items"`
+	}
+	var template *Template
+	var feed *Feed
+	var entries []*Entry
+	var entry *Entry
+	var item *Item
+	var err error
+	var i int
+	
+	template = new(Template)
+	err = json.Unmarshal(jsondata, template)
+	if err != nil {
+		return nil, nil, err
+	}
+	
+	feed = new(Feed)
+	feed.Title = template.Title
+	feed.URL = template.FeedURL
+	feed.SiteURL = template.HomePageURL
+	feed.Image = template.Icon
+	feed.Standard = "JSONFeed"
+	
+	// WebSub のハブ
+	for i = range template.Hubs {
+		if strings.EqualFold(template.Hubs[i].Type, "WebSub") || strings.EqualFold(template.Hubs[i].Type, "PubSubHubbub") {
+			feed.Hub = template.Hubs[i].URL
+			break
+		}
+	}
+	
+	entries = make([]*Entry, len(template.Items))
+	for i, item = range template.Items {
+		entry = new(Entry)
+		
+		// id は文字列だが数値で書かれていることもある
+		err = json.Unmarshal(item.ID, &entry.ID)
+		if err != nil {
+			entry.ID = string(item.ID)
+		}
+		entry.Link = item.URL
+		if entry.Link == "" {
+			entry.Link = item.ExternalURL
+		}
+		
+		// 本文はHTMLを優先する
+		if item.ContentHTML != "" {
+			entry.Content = item.ContentHTML
+			entry.ContentType = "html"
+		} else {
+			entry.Content = item.ContentText
+			entry.ContentType = "text"
+		}
+		if item.Summary != "" {
+			entry.Summary = summarize(item.Summary)
+		} else {
+			entry.Summary = summarize(entry.Content)
+		}
+		
+		// タイトルは省略できるので要約で代用する
+		entry.Title = item.Title
+		if entry.Title == "" {
+			entry.Title = entry.Summary
+		}
+		
+		entry.Published = parseDate(item.DatePublished)
+		entry.Updated = parseDate(item.DateModified)
+		if entry.Published.IsZero() {
+			entry.Published = entry.Updated
+		}
+		if entry.Updated.IsZero() {
+			entry.Updated = entry.Published
+		}
+		
+		// 最初の添付ファイルをポッドキャストのエピソードとする
+		if len(item.Attachments) > 0 {
+			entry.EnclosureURL = item.Attachments[0].URL
+			entry.EnclosureType = item.Attachments[0].MIMEType
+			entry.EnclosureLength = item.Attachments[0].SizeInBytes
+			entry.Duration = int(item.Attachments[0].DurationInSeconds)
+		}
+		
+		if entry.Published.After(feed.Updated) {
+			feed.Updated = entry.Published
+		}
+		entries[i] = entry
+	}
+	
+	return feed, entries, nil
+}
+
+/**
+ * データが JSON Feed かどうか調べる
+ * 先頭が { で始まり version が jsonfeed.org のURLであれば JSON Feed とみなす
+ * @methodOf JSONFeed
+ * @param {[]byte} data 調べるデータ
+ * @returns {bool} JSON Feed ならtrue
+ */
+func (this *JSONFeed) detect(data []byte) bool {
+	type Checker struct {
+		Version interface{} `json:"version"`
+	}
+	var checker *Checker
+	var err error
+	
+	data = bytes.TrimLeft(data, "\xef\xbb\xbf \t\r\n")
+	if len(data) == 0 || data[0] != '{' {
+		return false
+	}
+	
+	checker = new(Checker)
+	err = json.Unmarshal(data, checker)
+	if err != nil {
+		return false
+	}
+	
+	return strings.Contains(fmt.Sprint(checker.Version), jsonFeedVersion)
+}
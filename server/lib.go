@@ -4,12 +4,22 @@
 package okareader
 import (
 	"appengine"
-	"appengine/urlfetch"
-	"net/http"
 	"strings"
-	"log"
+	"html"
+	"net/url"
+	"regexp"
 )
 
+/**
+ * 要約の最大文字数
+ */
+const summaryLength = 200
+
+/**
+ * HTMLタグにマッチする正規表現
+ */
+var tagPattern = regexp.MustCompile(`<[^>]*>`)
+
 /**
  * エラーチェック
  * エラーがあればコンソールに出力する
@@ -50,31 +60,21 @@ func removeItem(s []string, target string) []string {
 }
 
 /**
- * 指定されたURLからXMLファイルを受信して返す
+ * スライスに指定された要素が含まれているか調べる
  * @function
- * @param {appengine.Context} c コンテキスト
- * @param {string} url URL
- * @returns {[]byte} 受信したXMLデータ、取得できなかったら nil を返す
+ * @param {[]string} s 対象のスライス
+ * @param {string} target 探す文字列
+ * @returns {bool} 含まれていればtrue
  */
-func getXML(c appengine.Context, url string) []byte {
-	var client *http.Client
-	var response *http.Response
-	var err error
-	var result []byte
+func contains(s []string, target string) bool {
+	var str string
 	
-	client = urlfetch.Client(c)
-	response, err = client.Get(url)
-	check(c, err)
-	if err != nil {
-		log.Printf("URLからファイルを取得出来ませんでした")
-		result = nil
-	} else {
-		result = make([]byte, response.ContentLength)
-		_, err = response.Body.Read(result)
-		check(c, err)
+	for _, str = range s {
+		if str == target {
+			return true
+		}
 	}
-	
-	return result
+	return false
 }
 
 /**
@@ -108,4 +108,56 @@ func join(str ...string) string {
 		result = strings.Join([]string{result, str[i]}, "")
 	}
 	return result
-}
\ No newline at end of file
+}
+
+/**
+ * HTMLからタグを取り除いて要約用のテキストにする
+ * 長すぎる場合は summaryLength 文字で切り詰める
+ * @function
+ * @param {string} str HTMLまたはテキスト
+ * @returns {string} 要約
+ */
+func summarize(str string) string {
+	var result string
+	var runes []rune
+	
+	result = tagPattern.ReplaceAllString(str, " ")
+	result = html.UnescapeString(result)
+	result = strings.Join(strings.Fields(result), " ")
+	
+	runes = []rune(result)
+	if len(runes) > summaryLength {
+		result = join(string(runes[:summaryLength]), "…")
+	}
+	
+	return result
+}
+
+/**
+ * 同じフィードを指すURLが同じ文字列になるように正規化する
+ * スキームとホスト名を小文字にし、既定のポート番号とフラグメントを取り除く
+ * @function
+ * @param {string} str URL
+ * @returns {string} 正規化したURL　解析できなければそのまま返す
+ */
+func normalizeURL(str string) string {
+	var u *url.URL
+	var err error
+	
+	u, err = url.Parse(strings.TrimSpace(str))
+	if err != nil || u.Host == "" {
+		return str
+	}
+	
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	if (u.Scheme == "http" && strings.HasSuffix(u.Host, ":80")) || (u.Scheme == "https" && strings.HasSuffix(u.Host, ":443")) {
+		u.Host = u.Host[:strings.LastIndex(u.Host, ":")]
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	u.Fragment = ""
+	
+	return u.String()
+}
@@ -6,17 +6,43 @@ package okareader
 import (
 	"appengine"
 	"appengine/datastore"
+	"appengine/taskqueue"
 	"appengine/user"
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/xml"
-	"log"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 /**
  * MVCでいうModelの役割
  * データ操作全般
  * @class
+ * @member {*unreadBatch} unread まとめて更新している間の未読数の差分　nilなら差分をすぐに書き込む
  */
 type DAO struct {
+	unread *unreadBatch
+}
+
+/**
+ * まとめて更新している間に溜めておく未読数の差分
+ * ワーカープールから同時に足されるので lock で守る
+ * @class
+ * @member {sync.Mutex} lock 差分を書き換えるときのロック
+ * @member {map[string]int} deltas フィードの親フォルダのキーと未読数の差分の対応
+ */
+type unreadBatch struct {
+	lock sync.Mutex
+	deltas map[string]int
 }
 
 /**
@@ -27,6 +53,10 @@ type DAO struct {
  * @member {[]string} Children 子への参照キーリスト
  * @member {string} Owner フォルダ作成者のユーザID
  * @member {string} Parent 親フォルダへの参照キー
+ * @member {string} Sort 中身の並び順("manual"/"title"/"unread"/"updated"/"folders_first"のいずれか)　空なら"manual"
+ * @member {int} Unread フォルダ以下にある未読エントリの総数
+ *     表示のたびに数え直さないよう、フィードの未読エントリが増減するたびに差分を足しておく
+ *     ずれたときは recountUnread で数え直す
  */
 type Folder struct {
 	Type string
@@ -34,42 +64,365 @@ type Folder struct {
 	Children []string
 	Owner string
 	Parent string
+	Sort string
+	Unread int
 }
 
+/**
+ * フォルダの中身の並び順
+ * "manual" は Children の順(ユーザが並べ替えた順)のまま表示する
+ */
+var sortModes = []string{"manual", "title", "unread", "updated", "folders_first"}
+
 /**
  * フィード
+ * ユーザごとの購読を表す　受信は同じURLのフィードをまとめた Source で行う
  * @class
  * @member {string} Title フィードのタイトル
- * @member {[]string} Entries エントリのキーリスト
+ * @member {[]string} Entries 未読エントリのキーリスト　この長さがフィードの未読数になる
+ *     長さを変えたときは、保存した後で addUnread で親フォルダの未読数にも差分を足す
+ * @member {[]string} ReadEntries 既読エントリのキーリスト(既読にした新しい順)
  * @member {string} Owner 所有者のユーザID
  * @member {string} Parent 親フォルダへの参照キー
- * @member {string} Standard フィードの規格("Atom"/"RSS1.0"/"RSS2.0"のいずれか)
- * @member {string} FinalEntry 最後に取得したエントリのキー
+ * @member {string} Standard フィードの規格("Atom"/"RSS1.0"/"RSS2.0"/"JSONFeed"のいずれか)
+ * @member {string} FinalEntry 最後に取得したエントリのID　旧形式で、受信元を作るときに Source へ引き継ぐためだけに残している
  * @member {string} URL フィードファイルの場所
  * @member {string} SiteURL ウェブページの場所
+ * @member {time.Time} Updated フィードの最終更新日時
+ * @member {string} Image フィードのアートワークの場所
+ * @member {string} Source 受信元への参照キー
+ * @member {int} TTL RSS2.0 の ttl で指定された受信間隔(分)　解析結果としてだけ使い保存しない
+ * @member {[]int} SkipHours RSS2.0 の skipHours で指定された受信しない時刻(GMT)　保存しない
+ * @member {int} UpdateInterval sy:updatePeriod, sy:updateFrequency で指定された更新間隔(分)　保存しない
+ * @member {string} Hub WebSub のハブのURL　保存しない
  */
 type Feed struct {
 	Title string
 	Entries []string
+	ReadEntries []string
 	Owner string
 	Parent string
 	Standard string
 	FinalEntry string
 	URL string
 	SiteURL string
+	Updated time.Time
+	Image string
+	Source string
+	TTL int `datastore:"-"`
+	SkipHours []int `datastore:"-"`
+	UpdateInterval int `datastore:"-"`
+	Hub string `datastore:"-"`
+}
+
+/**
+ * フィードの受信元
+ * 同じURLのフィードを購読しているユーザ全員で共有し、１回の受信で全員に配る
+ * キーは作ったときの正規化したURL　移転した受信元は NormalizedURL で探す
+ * @class
+ * @member {string} URL フィードファイルの場所
+ * @member {string} NormalizedURL 現在のURLを正規化したもの　移転してキーと違うURLになった受信元を探すのに使う
+ * @member {string} Standard フィードの規格
+ * @member {[]string} Subscribers このURLを購読しているフィードのキーリスト
+ * @member {string} FinalEntry 最後に配ったエントリのID　旧形式で、Seen へ移行したら空にする
+ * @member {[]SeenEntry} Seen 配ったことのあるエントリ(最後に見かけた日時の新しい順)
+ * @member {string} ETag 前回受信したときの ETag ヘッダ
+ * @member {string} LastModified 前回受信したときの Last-Modified ヘッダ
+ * @member {string} RedirectURL 恒久的なリダイレクトの移転先　移転が確定するまで保持する
+ * @member {int} RedirectCount 同じ移転先へ続けてリダイレクトされた回数
+ * @member {bool} Dead 配信が終了している(410 Gone が返ってきた)かどうか
+ * @member {time.Time} LastFetch 最後に受信を試みた日時
+ * @member {time.Time} LastSuccess 最後に受信に成功した日時
+ * @member {int} FailureCount 連続して受信に失敗した回数
+ * @member {string} LastError 最後に失敗したときのエラーメッセージ
+ * @member {time.Time} NextFetch 次に受信する日時　失敗が続いたときは間隔を空ける
+ * @member {[]FetchLog} History 最近の受信結果(古い順, 最大 fetchHistorySize 件)
+ * @member {int} TTL RSS2.0 の ttl で指定された受信間隔(分)
+ * @member {[]int} SkipHours RSS2.0 の skipHours で指定された受信しない時刻(GMT)
+ * @member {int} UpdateInterval sy:updatePeriod, sy:updateFrequency で指定された更新間隔(分)
+ * @member {int} PostInterval 実際の平均投稿間隔(分)
+ * @member {string} Hub WebSub のハブのURL
+ * @member {string} Topic WebSub で購読するフィードのURL(フィード自身が示すURL)
+ * @member {string} HubSecret プッシュされたデータの署名の検証に使う秘密鍵
+ * @member {bool} Push ハブに購読を申し込んだかどうか
+ * @member {time.Time} LeaseExpires ハブの購読期限
+ */
+type Source struct {
+	URL string
+	NormalizedURL string
+	Standard string
+	Subscribers []string
+	FinalEntry string
+	Seen []SeenEntry `datastore:",noindex"`
+	ETag string
+	LastModified string
+	RedirectURL string
+	RedirectCount int
+	Dead bool
+	LastFetch time.Time
+	LastSuccess time.Time
+	FailureCount int
+	LastError string `datastore:",noindex"`
+	NextFetch time.Time
+	History []FetchLog
+	TTL int
+	SkipHours []int
+	UpdateInterval int
+	PostInterval int
+	Hub string
+	Topic string
+	HubSecret string `datastore:",noindex"`
+	Push bool
+	LeaseExpires time.Time
+}
+
+/**
+ * エントリの本文
+ * 購読者全員で共有するので受信元(Source)の子として１つだけ保存する
+ * @class
+ * @member {string} Content エントリの本文
+ * @member {string} ContentType 本文の形式("text"/"html"/"xhtml"のいずれか)
+ * @member {time.Time} Created 保存した日時
+ * @member {int} Stars この本文を参照しているスター付きエントリの数　1以上なら保存期間を過ぎても削除しない
+ */
+type Item struct {
+	Content string `datastore:",noindex"`
+	ContentType string
+	Created time.Time
+	Stars int
+}
+
+/**
+ * 受信元ごとに本文を保存しておく期間
+ */
+const itemRetention = 30 * 24 * time.Hour
+
+/**
+ * 配ったことのあるエントリの記録
+ * フィードの中の位置に関係なく、未知のエントリだけを新着とするために使う
+ * @class
+ * @member {string} ID エントリのIDのハッシュ値
+ * @member {time.Time} Time 最後にフィードの中で見かけた日時
+ */
+type SeenEntry struct {
+	ID string
+	Time time.Time
+}
+
+/**
+ * フィードから消えたエントリを配信済みとして覚えておく期間
+ * これより長く消えていたエントリが再び現れると新着として扱う
+ */
+const seenRetention = 90 * 24 * time.Hour
+
+/**
+ * 配信済みとして覚えておくエントリの最大数
+ * フィードに現在含まれているエントリはこれを超えても忘れない
+ */
+const maxSeenEntries = 1000
+
+/**
+ * フィードの受信結果の記録
+ * @class
+ * @member {time.Time} Time 受信を試みた日時
+ * @member {int} StatusCode HTTPのステータスコード　応答がなかった場合は0
+ * @member {string} Error エラーメッセージ　成功した場合は空文字列
+ */
+type FetchLog struct {
+	Time time.Time
+	StatusCode int
+	Error string `datastore:",noindex"`
 }
 
+/**
+ * フィードごとに保存する受信結果の件数
+ */
+const fetchHistorySize = 10
+
+/**
+ * 受信に失敗したときに次の受信まで空ける最初の間隔
+ * 失敗が続くと倍々に延ばす
+ */
+const fetchBackoff = 30 * time.Minute
+
+/**
+ * 受信に失敗したときに空ける間隔の上限
+ */
+const maxFetchBackoff = 24 * time.Hour
+
+/**
+ * cronの１回の実行で更新する受信元の最大数
+ */
+const maxFeedsPerRun = 100
+
 /**
  * エントリ
+ * フィードの子として保存し、キー名は ID のハッシュ(seenID)にする
+ * 以前のエントリは親を持たず、キーも自動で割り当てたものになっている
  * @class
+ * @member {string} ID エントリを識別する文字列(guid, Atomのid, rdf:about など)
  * @member {string} Link エントリのURL
  * @member {string} Title エントリのタイトル
+ * @member {string} Summary エントリの要約(タグを除いたテキスト)
+ * @member {string} Content エントリの本文　Item に保存するのでここには保存しない
+ * @member {string} ContentType 本文の形式("text"/"html"/"xhtml"のいずれか)　保存しない
+ * @member {string} Item 本文(Item)への参照キー
+ * @member {time.Time} Published 公開日時
+ * @member {time.Time} Updated 更新日時
+ * @member {string} EnclosureURL 添付ファイル(ポッドキャストの音声など)の場所
+ * @member {string} EnclosureType 添付ファイルのMIMEタイプ
+ * @member {int64} EnclosureLength 添付ファイルのバイト数
+ * @member {int} Duration 添付ファイルの再生時間(秒)
  * @member {string} Owner 所有者のユーザID
+ * @member {bool} Read 既読かどうか
+ * @member {time.Time} ReadAt 既読にした日時
+ * @member {string} Feed 登録されているフィードのキー
+ * @member {bool} Starred スター付き(あとで読む)かどうか　既読にしても保存期間を過ぎても削除しない
+ * @member {time.Time} StarredAt スターを付けた日時
  */
 type Entry struct {
+	ID string
 	Link string
 	Title string
+	Summary string `datastore:",noindex"`
+	Content string `datastore:"-"`
+	ContentType string `datastore:"-"`
+	Item string
+	Published time.Time
+	Updated time.Time
+	EnclosureURL string
+	EnclosureType string
+	EnclosureLength int64
+	Duration int
 	Owner string
+	Read bool
+	ReadAt time.Time
+	Feed string
+	Starred bool
+	StarredAt time.Time
+}
+
+/**
+ * 既読エントリを保存しておく期間の既定値
+ * app.yaml の環境変数 READ_RETENTION_DAYS で日数を変更できる
+ */
+const defaultReadRetention = 30 * 24 * time.Hour
+
+/**
+ * 既読エントリを保存しておく期間
+ * @function
+ * @returns {time.Duration} 環境変数 READ_RETENTION_DAYS の日数　指定がなければ defaultReadRetention
+ */
+func readRetention() time.Duration {
+	var days int
+	var err error
+	
+	days, err = strconv.Atoi(os.Getenv("READ_RETENTION_DAYS"))
+	if err != nil || days <= 0 {
+		return defaultReadRetention
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+/**
+ * エントリを公開日時の新しい順に並べるためのリスト
+ * 公開日時がわからないエントリは最後に並べる
+ * @class
+ */
+type EntriesByDate []*Entry
+
+/**
+ * エントリ数
+ * @methodOf EntriesByDate
+ */
+func (this EntriesByDate) Len() int {
+	return len(this)
+}
+
+/**
+ * エントリの入れ替え
+ * @methodOf EntriesByDate
+ */
+func (this EntriesByDate) Swap(i int, j int) {
+	this[i], this[j] = this[j], this[i]
+}
+
+/**
+ * i番目のエントリがj番目より新しければtrue
+ * @methodOf EntriesByDate
+ */
+func (this EntriesByDate) Less(i int, j int) bool {
+	return this[i].Published.After(this[j].Published)
+}
+
+/**
+ * フォルダの中身の１件
+ * 表示やJSONで返すときに並び替えるために使う
+ * @class
+ * @member {string} Key フィードまたはフォルダのキー
+ * @member {string} Type "feed" または "folder"
+ * @member {string} Title タイトル
+ * @member {int} Count 未読エントリ数(フォルダなら中のフィードすべての合計)
+ * @member {time.Time} Updated 最新のエントリの日時(フォルダなら中のフィードのうち最も新しいもの)
+ *     フォルダの日時は中のフィードをすべて読み込んで求めるので、更新順に並べるときだけ入れる
+ */
+type FolderChild struct {
+	Key string
+	Type string
+	Title string
+	Count int
+	Updated time.Time
+}
+
+/**
+ * フォルダの中身を並び順に従って並べるためのリスト
+ * 比べる値が同じものは Children の順のままにする
+ * @class
+ * @member {[]*FolderChild} children フォルダの中身
+ * @member {string} mode 並び順
+ */
+type ChildrenBy struct {
+	children []*FolderChild
+	mode string
+}
+
+/**
+ * 中身の数
+ * @methodOf ChildrenBy
+ */
+func (this *ChildrenBy) Len() int {
+	return len(this.children)
+}
+
+/**
+ * 中身の入れ替え
+ * @methodOf ChildrenBy
+ */
+func (this *ChildrenBy) Swap(i int, j int) {
+	this.children[i], this.children[j] = this.children[j], this.children[i]
+}
+
+/**
+ * i番目の中身をj番目より前に並べるならtrue
+ * @methodOf ChildrenBy
+ */
+func (this *ChildrenBy) Less(i int, j int) bool {
+	var a *FolderChild
+	var b *FolderChild
+	
+	a = this.children[i]
+	b = this.children[j]
+	switch this.mode {
+		case "title":
+			return strings.ToLower(a.Title) < strings.ToLower(b.Title)
+		case "unread":
+			return a.Count > b.Count
+		case "updated":
+			return a.Updated.After(b.Updated)
+		case "folders_first":
+			return a.Type == "folder" && b.Type != "folder"
+	}
+	return false
 }
 
 /**
@@ -91,1020 +444,5189 @@ type Node struct {
 }
 
 /**
- * フォルダの新規登録
- * @methofOf DAO
- * @param c {appengine.Context} コンテクスト
- * @param u {*user.User} ユーザ
- * @param title {string} フォルダ名
- * @param root {bool} ルートフォルダならtrue
- * @param encodedParentKey {string} 追加先の親フォルダのキー
- * @returns {string} 追加したフォルダのキーをエンコードした文字列
+ * フォルダのツリーを平らに並べたときの１つのフォルダ
+ * @class
+ * @member {string} Key フォルダのキー
+ * @member {string} Title フォルダのタイトル
+ * @member {int} Depth ルートフォルダからの深さ(ルートは0)
+ * @member {bool} Root ルートフォルダかどうか
  */
-func (this *DAO) registerFolder(c appengine.Context, u *user.User, title string, root bool, encodedParentKey string) string {
-	var folder *Folder
-	var key *datastore.Key
-	var err error
-	var encodedKey string
-	var parentFolder *Folder
-	var parentKey *datastore.Key
+type FolderNode struct {
+	Key string
+	Title string
+	Depth int
+	Root bool
+}
+
+/**
+ * フィード・フォルダの更新結果
+ * @class
+ * @member {string} Key フィードまたは受信元のキー
+ * @member {string} Title フィード名
+ * @member {string} URL 受信したURL
+ * @member {string} Status 更新結果
+ *     "updated" 新着エントリがあった
+ *     "no_new_entries" 新着エントリがなかった
+ *     "not_modified" サーバ上のフィードが前回から変更されていなかった
+ *     "failed" フィードを受信できなかった
+ *     "parse_error" フィードを受信したが壊れていて読み込めなかった
+ *     "gone" フィードの配信が終了していた(410 Gone)
+ *     "backoff" 受信の失敗が続いているため今回は受信しなかった
+ *     "timeout" 制限時間内に更新が終わらなかった
+ *     "cancelled" フォルダ全体の制限時間を過ぎたため更新しなかった
+ * @member {int} Count 更新後のエントリ件数
+ * @member {int} New 新着エントリの件数
+ * @member {int} StatusCode HTTPのステータスコード　応答がなかった場合は0
+ * @member {string} Error 受信や解析のエラーメッセージ
+ * @member {string} RedirectURL 恒久的なリダイレクト(301, 308)の移転先
+ * @member {int64} Duration 所要時間(ミリ秒)
+ * @member {[]*Entry} Entries 追加したエントリ(フィードのみ)
+ */
+type UpdateResult struct {
+	Key string `json:",omitempty"`
+	Title string `json:",omitempty"`
+	URL string `json:",omitempty"`
+	Status string
+	Count int
+	New int
+	StatusCode int `json:",omitempty"`
+	Error string `json:",omitempty"`
+	RedirectURL string `json:",omitempty"`
+	Duration int64
+	Entries []*Entry `json:"-"`
+}
+
+/**
+ * 更新処理全体の報告
+ * @class
+ * @member {time.Time} Started 開始日時
+ * @member {int64} Duration 所要時間(ミリ秒)
+ * @member {int} Total 更新を試みたフィードまたは受信元の数
+ * @member {int} New 新着エントリの合計
+ * @member {map[string]int} Statuses 更新結果(Status)ごとの件数
+ * @member {[]*UpdateResult} Feeds フィードまたは受信元ごとの更新結果
+ * @member {map[string]*UpdateResult} Children フォルダ直下の各フォルダ、フィードの更新結果(フォルダの更新のみ)
+ * @member {[]*Entry} Entries 追加したエントリ(フィードの更新のみ)
+ */
+type UpdateReport struct {
+	Started time.Time
+	Duration int64
+	Total int
+	New int
+	Statuses map[string]int
+	Feeds []*UpdateResult
+	Children map[string]*UpdateResult `json:",omitempty"`
+	Entries []*Entry `json:",omitempty"`
+}
+
+/**
+ * 定期更新の実行記録
+ * 管理画面で一覧するための集計と、報告全体のJSONを保存する
+ * @class
+ * @member {time.Time} Started 開始日時
+ * @member {int64} Duration 所要時間(ミリ秒)
+ * @member {int} Total 更新を試みた受信元の数
+ * @member {int} New 新着エントリの合計
+ * @member {int} Failed 失敗、解析エラーまたはタイムアウトした受信元の数
+ * @member {[]byte} Report 更新報告(UpdateReport)のJSON
+ */
+type UpdateLog struct {
+	Started time.Time
+	Duration int64
+	Total int
+	New int
+	Failed int
+	Report []byte `datastore:",noindex"`
+}
+
+/**
+ * 定期更新の実行記録を保存しておく期間
+ */
+const updateLogRetention = 7 * 24 * time.Hour
+
+/**
+ * 管理画面に表示する実行記録の件数
+ */
+const updateLogSize = 100
+
+/**
+ * 更新結果を集計して報告にまとめる
+ * @function
+ * @param {time.Time} started 開始日時
+ * @param {[]*UpdateResult} results フィードまたは受信元ごとの更新結果
+ * @returns {*UpdateReport} 更新報告
+ */
+func makeReport(started time.Time, results []*UpdateResult) *UpdateReport {
+	var report *UpdateReport
+	var result *UpdateResult
 	
-	// 追加するフォルダの作成
-	folder = new(Folder)
-	folder.Owner = u.ID
-	folder.Parent = encodedParentKey
-	folder.Children = make([]string, 0)
-	if root {
-		folder.Type = "root"
-		folder.Title = "root"
-	} else {
-		folder.Type = "other"
-		folder.Title = title
+	report = new(UpdateReport)
+	report.Started = started
+	report.Duration = milliseconds(time.Since(started))
+	report.Total = len(results)
+	report.Statuses = make(map[string]int)
+	report.Feeds = results
+	for _, result = range results {
+		report.New = report.New + result.New
+		report.Statuses[result.Status]++
 	}
+	return report
+}
+
+/**
+ * 所要時間をミリ秒にする
+ * @function
+ * @param {time.Duration} d 所要時間
+ * @returns {int64} ミリ秒
+ */
+func milliseconds(d time.Duration) int64 {
+	return int64(d / time.Millisecond)
+}
+
+/**
+ * 複数の更新結果をまとめてフォルダの更新結果にする
+ * updated, no_new_entries, gone, failed, parse_error, timeout, cancelled, backoff, not_modified の順に優先する
+ * @function
+ * @param {[]string} statuses 子の更新結果
+ * @returns {string} フォルダの更新結果
+ */
+func mergeStatus(statuses []string) string {
+	var priority []string
+	var status string
+	var found map[string]bool
 	
-	// 追加するフォルダをデータストアに保存
-	key = datastore.NewIncompleteKey(c, "folder", nil)
-	key, err = datastore.Put(c, key, folder)
-	check(c, err)
-	
-	encodedKey = key.Encode()
-	
-	// 親フォルダの子に登録
-	if !root {
-		
-		// 親のChildrenに子のキーを追加して上書きする
-		parentKey, err = datastore.DecodeKey(encodedParentKey)
-		check(c, err)
-		
-		parentFolder = new(Folder)
-		err = datastore.Get(c, parentKey, parentFolder)
-		check(c, err)
-		
-		parentFolder.Children = append(parentFolder.Children, encodedKey)
-		
-		_, err = datastore.Put(c, parentKey, parentFolder)
-		check(c, err)
+	found = make(map[string]bool)
+	for _, status = range statuses {
+		found[status] = true
 	}
 	
-	return encodedKey
+	priority = []string{"updated", "no_new_entries", "gone", "failed", "parse_error", "timeout", "cancelled", "backoff", "not_modified"}
+	for _, status = range priority {
+		if found[status] {
+			return status
+		}
+	}
+	return "no_new_entries"
 }
 
 /**
- * フォルダの削除
- * 中身も全て削除する
- * rootフォルダは削除不可
+ * トランザクションが他の処理と競合したときに最初からやり直す回数
+ */
+const transactionAttempts = 5
+
+/**
+ * GetMulti, PutMulti, DeleteMulti で一度に扱えるエンティティの数
+ */
+const maxBatchSize = 500
+
+/**
+ * クエリの結果をカーソルで読み進めるときに一度に読み込むエンティティの数
+ * ユーザをタスクに振り分けるときと受信元の検査は、この数ずつ別のタスクで行う
+ */
+const queryPageSize = 100
+
+/**
+ * 処理をトランザクションの中で実行する
+ * 複数のエンティティグループ(25個まで)にまたがってよい
+ * 他の処理と競合したら transactionAttempts 回まで f を最初からやり直すので、
+ * f は必ずデータストアからの読み込みからやり直し、外の変数へは結果を代入するだけにすること
  * @methodOf DAO
  * @param {appengine.Context} c コンテキスト
- * @param {string} encodedKey 削除するフォルダのキーをエンコードした文字列
+ * @param {func(appengine.Context) error} f 処理　渡されたコンテキストでデータストアにアクセスする
+ * @returns {error} 最後に試したときのエラー
  */
-func (this *DAO) removeFolder(c appengine.Context, encodedKey string) {
-	var err error
-	var key *datastore.Key
+func (this *DAO) transaction(c appengine.Context, f func(tc appengine.Context) error) error {
+	return datastore.RunInTransaction(c, f, &datastore.TransactionOptions{XG: true, Attempts: transactionAttempts})
+}
+
+/**
+ * フォルダを読み込んで書き換え、トランザクションの中で保存する
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {*datastore.Key} key フォルダのキー
+ * @param {func(*Folder) bool} modify フォルダを書き換える処理　保存しなくてよければfalseを返す
+ *     競合したときは読み込み直したフォルダでもう一度呼ばれる
+ * @returns {*Folder} 保存したフォルダ
+ * @returns {error} エラー
+ */
+func (this *DAO) modifyFolder(c appengine.Context, key *datastore.Key, modify func(folder *Folder) bool) (*Folder, error) {
 	var folder *Folder
-	var childKey string
-	var childType string
-	var encodedParentKey string
-	var parentKey *datastore.Key
-	var parentFolder *Folder
-	
-	key, err = datastore.DecodeKey(encodedKey)
-	check(c, err)
-	
-	folder = new(Folder)
-	err = datastore.Get(c, key, folder)
-	check(c, err)
-	
-	// 親からの参照を削除
-	encodedParentKey = folder.Parent
-	parentKey, err = datastore.DecodeKey(encodedParentKey)
-	check(c, err)
-	parentFolder = new(Folder)
-	err = datastore.Get(c, parentKey, parentFolder)
-	check(c, err)
-	parentFolder.Children = removeItem(parentFolder.Children, encodedKey)
-	_, err = datastore.Put(c, parentKey, parentFolder)
+	var err error
 	
-	// 子を削除
-	for _, childKey = range folder.Children {
-		childType, _ = this.getItem(c, childKey)
-		if childType == "folder" {
-			this.removeFolder(c, childKey)
-		} else if childType == "feed" {
-			this.removeFeed(c, childKey)
+	err = this.transaction(c, func(tc appengine.Context) error {
+		var err error
+		
+		folder = new(Folder)
+		err = datastore.Get(tc, key, folder)
+		if err != nil || !modify(folder) {
+			return err
 		}
-	}
-	
-	err = datastore.Delete(c, key)
+		_, err = datastore.Put(tc, key, folder)
+		return err
+	})
 	check(c, err)
+	
+	return folder, err
 }
 
 /**
- * フォルダの取得
+ * フィードを読み込んで書き換え、トランザクションの中で保存する
+ * 未読エントリの数が変わったときは、保存した後で親フォルダの未読数にも差分を足す
  * @methodOf DAO
  * @param {appengine.Context} c コンテキスト
- * @param {string} encodedKey 取得したいフォルダのキーをエンコードした文字列
+ * @param {*datastore.Key} key フィードのキー
+ * @param {func(*Feed) bool} modify フィードを書き換える処理　保存しなくてよければfalseを返す
+ *     競合したときは読み込み直したフィードでもう一度呼ばれる
+ * @returns {*Feed} 保存したフィード
+ * @returns {error} エラー
  */
-func (this *DAO) getFolder(c appengine.Context, encodedKey string) *Folder {
-	var key *datastore.Key
+func (this *DAO) modifyFeed(c appengine.Context, key *datastore.Key, modify func(feed *Feed) bool) (*Feed, error) {
+	var feed *Feed
+	var delta int
 	var err error
-	var folder *Folder
-	
-	key, err = datastore.DecodeKey(encodedKey)
-	check(c, err)
 	
-	folder = new(Folder)
-	err = datastore.Get(c, key, folder)
+	err = this.transaction(c, func(tc appengine.Context) error {
+		var err error
+		
+		feed = new(Feed)
+		delta = 0
+		err = datastore.Get(tc, key, feed)
+		if err != nil {
+			return err
+		}
+		delta = -len(feed.Entries)
+		if !modify(feed) {
+			delta = 0
+			return nil
+		}
+		delta = delta + len(feed.Entries)
+		_, err = datastore.Put(tc, key, feed)
+		return err
+	})
 	check(c, err)
+	if err == nil {
+		this.addUnread(c, feed.Parent, delta)
+	}
 	
-	return folder
+	return feed, err
 }
 
 /**
- * フォルダ名の変更
+ * 受信元を読み込んで書き換え、トランザクションの中で保存する
  * @methodOf DAO
  * @param {appengine.Context} c コンテキスト
- * @param {string} encodedKey フォルダのキー
- * @param {string} name 新しいフォルダ名
+ * @param {*datastore.Key} key 受信元のキー
+ * @param {func(*Source) bool} modify 受信元を書き換える処理　保存しなくてよければfalseを返す
+ *     競合したときは読み込み直した受信元でもう一度呼ばれる
+ * @returns {*Source} 保存した受信元
+ * @returns {error} エラー
  */
-func (this *DAO) renameFolder(c appengine.Context, encodedKey string, name string) {
-	var key *datastore.Key
-	var folder *Folder
+func (this *DAO) modifySource(c appengine.Context, key *datastore.Key, modify func(source *Source) bool) (*Source, error) {
+	var source *Source
 	var err error
 	
-	key, err = datastore.DecodeKey(encodedKey)
-	check(c, err)
-	
-	folder = new(Folder)
-	err = datastore.Get(c, key, folder)
+	err = this.transaction(c, func(tc appengine.Context) error {
+		var err error
+		
+		source = new(Source)
+		err = datastore.Get(tc, key, source)
+		if err != nil || !modify(source) {
+			return err
+		}
+		_, err = datastore.Put(tc, key, source)
+		return err
+	})
 	check(c, err)
 	
-	folder.Title = name
-	_, err = datastore.Put(c, key, folder)
-	check(c, err)
+	return source, err
 }
 
 /**
- * フォルダ・フィードの取得
- * フォルダの中身を表示するときなど取り出す対象がどちらかわからないときに使用する
+ * 親フォルダの Children から子への参照を外す
+ * トランザクションの中で呼ぶ　親がない(ルートフォルダ)か既に削除されていれば何もしない
  * @methodOf DAO
- * @param {appengine.Context} c コンテキスト
- * @param {string} encodedKey アイテムのエンコード済みのキー
- * @returns {string} 取得したアイテムがフォルダなら"folder",フィードなら"feed"
- * @returns {*Folder or *Feed} 取得したフォルダまたはフィードオブジェクト
+ * @param {appengine.Context} tc トランザクションのコンテキスト
+ * @param {string} encodedParentKey 親フォルダのキー
+ * @param {string} encodedKey 外す子のキー
+ * @returns {error} エラー
  */
-func (this *DAO) getItem(c appengine.Context, encodedKey string) (string, interface{}) {
-	var key *datastore.Key
+func (this *DAO) detach(tc appengine.Context, encodedParentKey string, encodedKey string) error {
+	var parentKey *datastore.Key
+	var parent *Folder
 	var err error
-	type Item struct {
-		Title string
-		Owner string
-		Entries []string
-		Children []string
-		Type string
-		Id string
-		Count int
-		URL string
-		Standard string
-		Parent string
-		SiteURL string
-		FinalEntry string
-	}
-	var item *Item
-	var itemType string
-	
-	key, err = datastore.DecodeKey(encodedKey)
-	check(c, err)
 	
-	item = new(Item)
-	err = datastore.Get(c, key, item)
-	check(c, err)
+	parentKey, err = datastore.DecodeKey(encodedParentKey)
+	if err != nil {
+		return nil
+	}
+	parent = new(Folder)
+	err = datastore.Get(tc, parentKey, parent)
+	if err == datastore.ErrNoSuchEntity {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	parent.Children = removeItem(parent.Children, encodedKey)
+	_, err = datastore.Put(tc, parentKey, parent)
+	return err
+}
+
+/**
+ * エンティティを maxBatchSize 件ずつ削除する
+ * トランザクションで一度に削除できる数を超えることがあるので、トランザクションの外で使う
+ * @function
+ * @param {appengine.Context} c コンテキスト
+ * @param {[]*datastore.Key} keys 削除するエンティティのキー
+ * @returns {error} 削除できなかったときの最初のエラー
+ */
+func deleteBatches(c appengine.Context, keys []*datastore.Key) error {
+	var start int
+	var end int
+	var err error
+	var first error
 	
-	if item.Type == "" {
-		// 要素はFeed
-		item.Count = len(item.Entries)
-		itemType = "feed"
-	} else {
-		// 要素はフォルダ
-		itemType = "folder"
-		item.Count = this.getEntriesCount(c, encodedKey)
+	for start = 0; start < len(keys); start = end {
+		end = start + maxBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		err = datastore.DeleteMulti(c, keys[start:end])
+		if err != nil && first == nil {
+			first = err
+		}
 	}
+	return first
+}
+
+/**
+ * GetMulti などが返したエラーから i 番目のエンティティのエラーを取り出す
+ * エンティティごとのエラーでなければ、すべてのエンティティが同じエラーになったとみなす
+ * @function
+ * @param {error} err GetMulti などが返したエラー
+ * @param {int} i エンティティの位置
+ * @returns {error} i 番目のエンティティのエラー
+ */
+func errorAt(err error, i int) error {
+	var errs appengine.MultiError
+	var ok bool
 	
-	return itemType, item
+	errs, ok = err.(appengine.MultiError)
+	if ok {
+		return errs[i]
+	}
+	return err
 }
 
 /**
- * 指定されたフォルダ以下にあるエントリの総数を返す
- * @methodOf DAO
+ * クエリに合うエンティティのキーを queryPageSize 件ずつカーソルで読み進めて１つずつ渡す
+ * 読み込みに失敗したらそこでやめる
+ * @function
  * @param {appengine.Context} c コンテキスト
- * @param {string} folderKey エンコード済みのフォルダキー
- * @returns {int} エントリの総数
+ * @param {*datastore.Query} query クエリ
+ * @param {func(*datastore.Key)} f キーを受け取る関数
+ * @returns {error} 読み込みに失敗したときのエラー
  */
-func (this *DAO) getEntriesCount(c appengine.Context, folderKey string) int {
+func eachKey(c appengine.Context, query *datastore.Query, f func(*datastore.Key)) error {
+	var page *datastore.Query
+	var iterator *datastore.Iterator
+	var cursor datastore.Cursor
 	var key *datastore.Key
+	var count int
 	var err error
-	var folder *Folder
-	var sum int
-	var itemType string
-	var childKey string
-
-	key, err = datastore.DecodeKey(folderKey)
-	check(c, err)
 	
-	folder = new(Folder)
-	err = datastore.Get(c, key, folder)
-	check(c, err)
-
-	sum = 0
-	for _, childKey = range folder.Children {
-		itemType, _ = this.getItem(c, childKey)
-		
-		if itemType == "folder" {
-			sum = sum + this.getEntriesCount(c, childKey)
-		} else if itemType == "feed" {
-			sum = sum + len(this.getEntries(c, childKey))
+	query = query.KeysOnly().Limit(queryPageSize)
+	page = query
+	for {
+		iterator = page.Run(c)
+		count = 0
+		for {
+			key, err = iterator.Next(nil)
+			if err == datastore.Done {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			f(key)
+			count++
+		}
+		if count < queryPageSize {
+			return nil
 		}
+		cursor, err = iterator.Cursor()
+		if err != nil {
+			return err
+		}
+		page = query.Start(cursor)
 	}
+}
+
+/**
+ * 古いエントリを読み込んだときの ErrFieldMismatch を無視する
+ * 以前は本文をエントリに保存していたので、古いエントリには今はない Content プロパティがある
+ * @function
+ * @param {error} err datastore.Get が返したエラー
+ * @returns {error} ErrFieldMismatch なら nil、それ以外はそのまま
+ */
+func ignoreMismatch(err error) error {
+	var mismatch *datastore.ErrFieldMismatch
 	
-	return sum
+	mismatch, _ = err.(*datastore.ErrFieldMismatch)
+	if mismatch != nil {
+		return nil
+	}
+	return err
 }
 
 /**
- * ルートフォルダを取得
+ * フォルダとその祖先のフォルダの未読数に差分を足す
+ * フィードの未読エントリが増減したときに、フィードを保存した後で呼ぶ
+ * まとめて更新している間は差分を溜めておき、flushUnread でフォルダごとに１回だけ書き込む
+ * フォルダごとに別のトランザクションで書き換えるので、途中で失敗して生じたずれは recountUnread で直す
  * @methodOf DAO
  * @param {appengine.Context} c コンテキスト
- * @param {*user.User} u ユーザオブジェクト
- * @returns {string} ルートフォルダのキー
- * @returns {*Folder} ルートフォルダ
+ * @param {string} encodedKey 差分を足すフォルダのキー　フィードの親フォルダ
+ * @param {int} delta 未読エントリの増減
  */
-func (this *DAO) getRootFolder(c appengine.Context, u *user.User) (string, *Folder) {
-	var root *Folder
-	var query *datastore.Query
-	var iterator *datastore.Iterator
-	var err error
-	var key *datastore.Key
-	var encodedKey string
+func (this *DAO) addUnread(c appengine.Context, encodedKey string, delta int) {
+	var batch *unreadBatch
+	var totals map[string]int
 	
-	root = new(Folder)
-	query = datastore.NewQuery("folder").Filter("Type =", "root").Filter("Owner =", u.ID)
-	iterator = query.Run(c)
-	key, err = iterator.Next(root)
-	check(c, err)
+	if delta == 0 || encodedKey == "" {
+		return
+	}
 	
-	if key != nil {
-		encodedKey = key.Encode()
+	batch = this.unread
+	if batch != nil {
+		batch.lock.Lock()
+		batch.deltas[encodedKey] = batch.deltas[encodedKey] + delta
+		batch.lock.Unlock()
+		return
 	}
 	
-	return encodedKey, root
+	totals = make(map[string]int)
+	this.sumAncestors(c, encodedKey, delta, totals, make(map[string]string))
+	this.writeUnread(c, totals)
+}
+
+/**
+ * 未読数の差分を溜め始める
+ * 多くのフィードを並行して更新すると同じ祖先のフォルダ(特にルートフォルダ)への書き込みが競合するので、
+ * 更新し終えてから flushUnread でまとめて書き込む
+ * @methodOf DAO
+ */
+func (this *DAO) batchUnread() {
+	this.unread = &unreadBatch{deltas: make(map[string]int)}
 }
 
 /**
- * フォルダの中身を取得する
+ * 溜めておいた未読数の差分を祖先のフォルダまで合計して、フォルダごとに１回ずつ書き込む
  * @methodOf DAO
  * @param {appengine.Context} c コンテキスト
- * @param {*Folder} folder 親フォルダ
- * @returns {[]interface{}} フォルダの中身を配列化したもの
  */
-func (this *DAO) getChildren(c appengine.Context, folder *Folder) []interface{} {
-	var err error
-	var children []interface{}
-	var keys []*datastore.Key
+func (this *DAO) flushUnread(c appengine.Context) {
+	var batch *unreadBatch
+	var totals map[string]int
+	var parents map[string]string
 	var encodedKey string
-	var key *datastore.Key
+	var delta int
 	
-	keys = make([]*datastore.Key, 0)
-	for _, encodedKey = range folder.Children {
-		key, err = datastore.DecodeKey(encodedKey)
-		check(c, err)
-		keys = append(keys, key)
+	batch = this.unread
+	this.unread = nil
+	if batch == nil {
+		return
 	}
 	
-	err = datastore.GetMulti(c, keys, children)
-	check(c, err)
-	return children
+	totals = make(map[string]int)
+	parents = make(map[string]string)
+	batch.lock.Lock()
+	for encodedKey, delta = range batch.deltas {
+		this.sumAncestors(c, encodedKey, delta, totals, parents)
+	}
+	batch.lock.Unlock()
+	this.writeUnread(c, totals)
 }
 
 /**
- * フィードをデータストアに追加
- * 既に存在するフィードは無視する
+ * フォルダとその祖先のフォルダそれぞれに足す差分を合計する
  * @methodOf DAO
  * @param {appengine.Context} c コンテキスト
- * @param {*Feed} feed 登録するフィードオブジェクト
- * @param {[]*Entry} entries フィードのエントリリスト
- * @param {string} to 追加先のフォルダのキー
- * @returns {string} 追加したフィードのキーをエンコードしたもの　重複していたら空文字列
- * @returnss {bool} 重複していた場合はtrue
+ * @param {string} encodedKey 差分を足すフォルダのキー
+ * @param {int} delta 未読エントリの増減
+ * @param {map[string]int} totals フォルダのキーと合計した差分の対応　ここに足す
+ * @param {map[string]string} parents 読み込んだフォルダの親のキー　同じフォルダを何度も読み込まないために使う
  */
-func (this *DAO) registerFeed(c appengine.Context, feed *Feed, entries []*Entry, to string) (string, bool) {
-	var key *datastore.Key
-	var encodedKey string
-	var err error
-	var parentFolderKey *datastore.Key
-	var parentFolder *Folder
-	var duplicated bool
-	var u *user.User
-	
-	key = datastore.NewIncompleteKey(c, "feed", nil)
+func (this *DAO) sumAncestors(c appengine.Context, encodedKey string, delta int, totals map[string]int, parents map[string]string) {
+	var visited map[string]bool
+	var parent string
+	var ok bool
 	
-	// 重複していたら登録しない
-	duplicated = this.exist(c, feed)
-	if duplicated {
-		encodedKey = ""
-	} else {
-		// ユーザID追加
-		u = user.Current(c)
-		feed.Owner = u.ID
-		
-		// フィード保存
-		feed.Parent = to
-		key, err = datastore.Put(c, key, feed)
-		check(c, err)
-		encodedKey = key.Encode()
-		
-		// 親フォルダ取得
-		parentFolderKey, err = datastore.DecodeKey(to)
-		check(c, err)
-		parentFolder = new(Folder)
-		err = datastore.Get(c, parentFolderKey, parentFolder)
-		check(c, err)
-		
-		// 親フォルダの子に追加
-		parentFolder.Children = append(parentFolder.Children, encodedKey)
-		_, err = datastore.Put(c, parentFolderKey, parentFolder)
-		check(c, err)
-		
-		// エントリを追加
-		this.registerEntries(c, entries, encodedKey)
+	// 親をたどりながら足していく　Parent が循環していても止まるようにする
+	visited = make(map[string]bool)
+	for encodedKey != "" && !visited[encodedKey] {
+		visited[encodedKey] = true
+		totals[encodedKey] = totals[encodedKey] + delta
+		parent, ok = parents[encodedKey]
+		if !ok {
+			parent = this.getFolder(c, encodedKey).Parent
+			parents[encodedKey] = parent
+		}
+		encodedKey = parent
 	}
-	
-	return encodedKey, duplicated
 }
 
 /**
- * フィード名を変更する
+ * フォルダごとに合計した差分を未読数に足す
+ * 負になったら差分の記録がずれているので、0にして recountUnread で数え直すまで警告を残す
  * @methodOf DAO
  * @param {appengine.Context} c コンテキスト
- * @param {string} encodedKey エンコード済みのフィードキー
- * @param {string} name 新しい名前
+ * @param {map[string]int} totals フォルダのキーと差分の対応
  */
-func (this *DAO) renameFeed(c appengine.Context, encodedKey string, name string) {
+func (this *DAO) writeUnread(c appengine.Context, totals map[string]int) {
+	var encodedKey string
+	var delta int
 	var key *datastore.Key
 	var err error
-	var feed *Feed
-	
-	key, err = datastore.DecodeKey(encodedKey)
-	check(c, err)
-	
-	feed = new(Feed)
-	err = datastore.Get(c, key, feed)
-	check(c, err)
 	
-	feed.Title = name
-	_, err = datastore.Put(c, key, feed)
-	check(c, err)
+	for encodedKey, delta = range totals {
+		if delta == 0 {
+			continue
+		}
+		key, err = datastore.DecodeKey(encodedKey)
+		check(c, err)
+		if err != nil {
+			continue
+		}
+		this.modifyFolder(c, key, func(folder *Folder) bool {
+			folder.Unread = folder.Unread + delta
+			if folder.Unread < 0 {
+				c.Warningf("unread count of %s drifted to %d", encodedKey, folder.Unread)
+				folder.Unread = 0
+			}
+			return true
+		})
+	}
 }
 
 /**
- * フィードの削除
- * @methodOf DAO
- * @param {appengine.Context} c コンテキスト
- * @param {string} encodedKey エンコード済みのフィードキー
+ * フォルダの新規登録
+ * フォルダの保存と親フォルダの Children への追加はトランザクションでまとめて行う
+ * @methofOf DAO
+ * @param c {appengine.Context} コンテクスト
+ * @param u {*user.User} ユーザ
+ * @param title {string} フォルダ名
+ * @param root {bool} ルートフォルダならtrue
+ * @param encodedParentKey {string} 追加先の親フォルダのキー
+ * @returns {string} 追加したフォルダのキーをエンコードした文字列　保存できなければ空文字列
  */
-func (this *DAO) removeFeed(c appengine.Context, encodedKey string) {
+func (this *DAO) registerFolder(c appengine.Context, u *user.User, title string, root bool, encodedParentKey string) string {
+	var folder *Folder
 	var key *datastore.Key
 	var err error
-	var feed *Feed
-	var parent *Folder
+	var encodedKey string
 	var parentKey *datastore.Key
-	var encodedParentKey string
-	var encodedEntryKey string
-	var entryKey *datastore.Key
 	
-	// フィードを取得
-	key, err = datastore.DecodeKey(encodedKey)
-	check(c, err)
+	// 追加するフォルダの作成
+	folder = new(Folder)
+	folder.Owner = u.ID
+	folder.Parent = encodedParentKey
+	folder.Children = make([]string, 0)
+	if root {
+		folder.Type = "root"
+		folder.Title = "root"
+	} else {
+		folder.Type = "other"
+		folder.Title = title
+	}
 	
-	feed = new(Feed)
-	err = datastore.Get(c, key, feed)
+	// ルートフォルダには親がないのでそのまま保存する
+	if root {
+		key, err = datastore.Put(c, datastore.NewIncompleteKey(c, "folder", nil), folder)
+		check(c, err)
+		if err != nil {
+			return ""
+		}
+		return key.Encode()
+	}
 	
-	// 親フォルダからの参照を削除
-	encodedParentKey = feed.Parent
 	parentKey, err = datastore.DecodeKey(encodedParentKey)
 	check(c, err)
+	if err != nil {
+		return ""
+	}
 	
-	parent = new(Folder)
-	err = datastore.Get(c, parentKey, parent)
-	check(c, err)
-	
-	parent.Children = removeItem(parent.Children, encodedKey)
-	_, err = datastore.Put(c, parentKey, parent)
+	// 追加するフォルダを保存して親のChildrenに子のキーを追加する
+	err = this.transaction(c, func(tc appengine.Context) error {
+		var parentFolder *Folder
+		var err error
+		
+		parentFolder = new(Folder)
+		err = datastore.Get(tc, parentKey, parentFolder)
+		if err != nil {
+			return err
+		}
+		key, err = datastore.Put(tc, datastore.NewIncompleteKey(tc, "folder", nil), folder)
+		if err != nil {
+			return err
+		}
+		encodedKey = key.Encode()
+		parentFolder.Children = append(parentFolder.Children, encodedKey)
+		_, err = datastore.Put(tc, parentKey, parentFolder)
+		return err
+	})
 	check(c, err)
-	
-	// フィードに含まれるエントリを削除
-	for _, encodedEntryKey = range feed.Entries {
-		entryKey, err = datastore.DecodeKey(encodedEntryKey)
-		check(c, err)
-		err = datastore.Delete(c, entryKey)
-		check(c, err)
+	if err != nil {
+		return ""
 	}
 	
-	// フィードを削除
-	err = datastore.Delete(c, key)
-	check(c, err)
+	return encodedKey
 }
 
 /**
- * フォルダの既読化
+ * フォルダの削除
+ * 中身も全て削除する
+ * 中身を削除してから、親からの参照の削除とフォルダの削除をトランザクションでまとめて行うので、
+ * 途中で止まっても削除済みのものへの参照は残らない
  * @methodOf DAO
  * @param {appengine.Context} c コンテキスト
- * @param {string} encodedKey
+ * @param {string} encodedKey 削除するフォルダのキーをエンコードした文字列
  */
-func (this *DAO) readFolder(c appengine.Context, encodedKey string) {
-	var key *datastore.Key
+func (this *DAO) removeFolder(c appengine.Context, encodedKey string) {
 	var err error
+	var key *datastore.Key
 	var folder *Folder
-	var childKey string
-	var childType string
+	var remaining []string
+	var removeChildren func(children []string)
 	
-	// フォルダを取得する
 	key, err = datastore.DecodeKey(encodedKey)
 	check(c, err)
 	
 	folder = new(Folder)
 	err = datastore.Get(c, key, folder)
 	check(c, err)
+	if err != nil {
+		return
+	}
 	
-	// フォルダ以下にあるすべてのフィードを既読化
-	for _, childKey = range folder.Children {
-		childType, _ = this.getItem(c, childKey)
-		if childType == "feed" {
-			this.readFeed(c, childKey)
-		} else if childType == "folder" {
-			this.readFolder(c, childKey)
+	removeChildren = func(children []string) {
+		var childKey string
+		var childType string
+		
+		for _, childKey = range children {
+			childType, _ = this.getItem(c, childKey)
+			if childType == "folder" {
+				this.removeFolder(c, childKey)
+			} else if childType == "feed" {
+				this.removeFeed(c, childKey)
+			}
+		}
+	}
+	
+	// 子を削除　子は削除されるときに自分でこのフォルダの Children から外れる
+	removeChildren(folder.Children)
+	
+	// 親からの参照を削除してフォルダを削除
+	err = this.transaction(c, func(tc appengine.Context) error {
+		var current *Folder
+		var err error
+		
+		current = new(Folder)
+		err = datastore.Get(tc, key, current)
+		if err != nil {
+			return err
+		}
+		err = this.detach(tc, current.Parent, encodedKey)
+		if err != nil {
+			return err
 		}
+		remaining = current.Children
+		return datastore.Delete(tc, key)
+	})
+	check(c, err)
+	
+	// 削除している間に追加されたものも削除する
+	if err == nil {
+		removeChildren(remaining)
 	}
 }
 
 /**
- * フィードの既読化
+ * 移動を受け付けなかったときにトランザクションを取り消すための内部エラー
+ */
+var errMoveRefused = errors.New("move refused")
+
+/**
+ * フィードまたはフォルダを別のフォルダへ移動する
+ * 移動元と移動先のフォルダの Children と、移動するものの Parent をトランザクションでまとめて書き換える
+ * ルートフォルダの移動と、フォルダを自分自身やその中のフォルダへ移動することはできない
+ * 移動先の祖先はトランザクションの外でたどり、トランザクションの中では移動先の親が変わっていないことだけを確かめる
+ * これで逆向きの移動が同時に行われても循環しない
+ * エントリはフィードに付いたまま移動する
  * @methodOf DAO
  * @param {appengine.Context} c コンテキスト
- * @param {string} encodedKey フィードのキー
+ * @param {string} encodedKey 移動するフィードまたはフォルダのキー
+ * @param {string} to 移動先のフォルダのキー
+ * @returns {string} 移動の結果
+ *     "success" 移動した(すでに移動先にある場合も含む)
+ *     "not_found" 移動するものか移動先のフォルダが見つからない
+ *     "root" ルートフォルダは移動できない
+ *     "descendant" フォルダを自分自身かその中のフォルダへ移動しようとした
+ *     "forbidden" 移動するもの、移動元、移動先のどれかがログインユーザのものではない
+ *     "failed" 保存できなかったか、移動中に移動先の親が変わった
  */
-func (this *DAO) readFeed(c appengine.Context, encodedKey string) {
-	var entries []*Entry
-	var entry *Entry
-	
-	entries = this.getEntries(c, encodedKey)
-	for _, entry = range entries {
-		this.removeEntry(c, entry.Link, encodedKey)
-	}
-}
-
-/**
- * 複数のエントリをフィードに一括で新規追加する
- * @methodOf DAO
- * @param {appengine.Context} c コンテキスト
- * @param {[]*Entry} entries 追加するエントリ配列
- * @param {string} to 追加先のフィードのキー
- * @returns {[]string} 追加したエントリのキー配列
- */
-func (this *DAO) registerEntries(c appengine.Context, entries []*Entry, to string) []string {
-	var entry *Entry
+func (this *DAO) moveItem(c appengine.Context, encodedKey string, to string) string {
 	var key *datastore.Key
-	var result []string
-	var err error
-	var i int
-	var feed *Feed
-	var feedKey *datastore.Key
+	var destKey *datastore.Key
+	var ancestorKey *datastore.Key
+	var ancestorFolder *Folder
 	var u *user.User
-	
-	if len(entries) == 0 {
-		return nil
-	}
-	
-	feedKey, err = datastore.DecodeKey(to)
-	feed = this.getFeed(c, to)
+	var result string
+	var from string
+	var ancestor string
+	var destParent string
+	var visited map[string]bool
+	var unread int
+	var err error
 	
 	u = user.Current(c)
+	if u == nil {
+		return "forbidden"
+	}
+	key, err = datastore.DecodeKey(encodedKey)
+	if err != nil || (key.Kind() != "feed" && key.Kind() != "folder") {
+		return "not_found"
+	}
+	destKey, err = datastore.DecodeKey(to)
+	if err != nil || destKey.Kind() != "folder" {
+		return "not_found"
+	}
 	
-	result = make([]string, len(entries))
-		
-	for i, entry = range entries {
-		entry.Owner = u.ID
-		key = datastore.NewIncompleteKey(c, "entry", nil)
-		key, err = datastore.Put(c, key, entry)
-		check(c, err)
-		result[i] = key.Encode()
+	// 移動先から親をたどって移動するフォルダ自身に行き着いたら、その中への移動になる
+	if key.Kind() == "folder" {
+		visited = make(map[string]bool)
+		for ancestor = to; ancestor != "" && !visited[ancestor]; ancestor = ancestorFolder.Parent {
+			if ancestor == encodedKey {
+				return "descendant"
+			}
+			visited[ancestor] = true
+			ancestorKey, err = datastore.DecodeKey(ancestor)
+			if err == nil {
+				ancestorFolder = new(Folder)
+				err = datastore.Get(c, ancestorKey, ancestorFolder)
+			}
+			if err != nil {
+				check(c, err)
+				if ancestor == to {
+					return "not_found"
+				}
+				return "failed"
+			}
+			if ancestor == to {
+				destParent = ancestorFolder.Parent
+			}
+		}
 	}
-	feed.Entries = prepend(feed.Entries, result)
 	
-	// 最新のエントリを保存
-	feed.FinalEntry = entries[0].Link
+	err = this.transaction(c, func(tc appengine.Context) error {
+		var feed *Feed
+		var folder *Folder
+		var dest *Folder
+		var parent *Folder
+		var parentKey *datastore.Key
+		var item interface{}
+		var encodedParentKey string
+		var owner string
+		var err error
+		
+		from = ""
+		dest = new(Folder)
+		err = datastore.Get(tc, destKey, dest)
+		if err != nil {
+			result = "not_found"
+			return err
+		}
+		
+		if key.Kind() == "feed" {
+			feed = new(Feed)
+			err = datastore.Get(tc, key, feed)
+			encodedParentKey, owner, item, unread = feed.Parent, feed.Owner, feed, len(feed.Entries)
+		} else {
+			folder = new(Folder)
+			err = datastore.Get(tc, key, folder)
+			encodedParentKey, owner, item, unread = folder.Parent, folder.Owner, folder, folder.Unread
+			if err == nil && folder.Type == "root" {
+				result = "root"
+				return errMoveRefused
+			}
+		}
+		if err != nil {
+			result = "not_found"
+			return err
+		}
+		if owner != u.ID || dest.Owner != u.ID {
+			result = "forbidden"
+			return errMoveRefused
+		}
+		
+		// 祖先をたどったあとに移動先が別のフォルダへ移されていたら、循環するかもしれないので移動しない
+		if folder != nil && (dest.Parent != destParent || dest.Parent == encodedKey) {
+			c.Warningf("destination %s was moved during the move of %s", to, encodedKey)
+			result = "failed"
+			return errMoveRefused
+		}
+		
+		if encodedParentKey == to {
+			result = "success"
+			return nil
+		}
+		
+		// 移動元のフォルダから外す
+		parentKey, err = datastore.DecodeKey(encodedParentKey)
+		if err != nil {
+			return err
+		}
+		parent = new(Folder)
+		err = datastore.Get(tc, parentKey, parent)
+		if err != nil {
+			return err
+		}
+		if parent.Owner != u.ID {
+			result = "forbidden"
+			return errMoveRefused
+		}
+		parent.Children = removeItem(parent.Children, encodedKey)
+		
+		// 移動先のフォルダの最後に加える
+		dest.Children = append(dest.Children, encodedKey)
+		if feed != nil {
+			feed.Parent = to
+		} else {
+			folder.Parent = to
+		}
 		
-	_, err = datastore.Put(c, feedKey, feed)
+		_, err = datastore.PutMulti(tc, []*datastore.Key{parentKey, destKey}, []*Folder{parent, dest})
+		if err != nil {
+			return err
+		}
+		_, err = datastore.Put(tc, key, item)
+		if err != nil {
+			return err
+		}
+		from = encodedParentKey
+		result = "success"
+		return nil
+	})
 	
+	if err == errMoveRefused {
+		return result
+	}
+	check(c, err)
+	if err != nil && result != "not_found" {
+		result = "failed"
+	}
+	
+	// 移動したものの未読数を移動元から移動先へ付け替える
+	if err == nil && from != "" {
+		this.addUnread(c, from, -unread)
+		this.addUnread(c, to, unread)
+	}
 	return result
 }
 
 /**
- * 指定されたフィードのエントリをすべて返す
+ * ユーザのすべてのフォルダをツリーの順に並べて返す
+ * 各フォルダの中は、そのフォルダの並び順に従う
+ * 移動先の選択肢として使う
  * @methodOf DAO
  * @param {appengine.Context} c コンテキスト
- * @param {string} feedKey エンコード済みのフィードキー
- * @returns {[]*Entry} エントリ配列
+ * @param {*user.User} u ユーザ
+ * @param {string} exclude このフォルダとその中のフォルダは含めない　空文字列ならすべて含める
+ * @returns {[]*FolderNode} フォルダのリスト
  */
-func (this *DAO) getEntries(c appengine.Context, feedKey string) []*Entry {
-	var feed *Feed
-	var entryKey string
-	var entry *Entry
-	var key *datastore.Key
-	var err error
-	var entries []*Entry
+func (this *DAO) getFolderList(c appengine.Context, u *user.User, exclude string) []*FolderNode {
+	var rootKey string
+	var list []*FolderNode
+	var visited map[string]bool
+	var walk func(key string, depth int)
 	
-	entries = make([]*Entry, 0)
+	rootKey, _ = this.getRootFolder(c, u)
+	list = make([]*FolderNode, 0)
 	
-	feed = this.getFeed(c, feedKey)
-	for _, entryKey = range feed.Entries {
-		key, err = datastore.DecodeKey(entryKey)
-		check(c, err)
-		
-		entry = new(Entry)
-		err = datastore.Get(c, key, entry)
-		check(c, err)
+	// 壊れたデータで循環していても同じフォルダを二度たどらない
+	visited = make(map[string]bool)
+	walk = func(key string, depth int) {
+		var folder *Folder
+		var child *FolderChild
 		
-		entries = append(entries, entry)
+		if key == exclude || visited[key] {
+			return
+		}
+		visited[key] = true
+		folder = this.getFolder(c, key)
+		list = append(list, &FolderNode{key, folder.Title, depth, folder.Type == "root"})
+		for _, child = range this.getChildren(c, folder) {
+			if child.Type == "folder" {
+				walk(child.Key, depth + 1)
+			}
+		}
 	}
+	walk(rootKey, 0)
 	
-	return entries
+	return list
 }
 
 /**
- * 指定されたエントリを削除する
+ * フォルダの取得
  * @methodOf DAO
  * @param {appengine.Context} c コンテキスト
- * @param {string} link 削除するエントリのURL
- * @param {string} feedKey エントリが登録されているフィードのキー
+ * @param {string} encodedKey 取得したいフォルダのキーをエンコードした文字列
  */
-func (this *DAO) removeEntry(c appengine.Context, link string, feedKey string) {
-	var query *datastore.Query
-	var iterator *datastore.Iterator
+func (this *DAO) getFolder(c appengine.Context, encodedKey string) *Folder {
 	var key *datastore.Key
 	var err error
-	var feed *Feed
-	var encodedEntryKey string
-	
-	query = datastore.NewQuery("entry").Filter("Link =", link)
-	iterator = query.Run(c)
-	key, err = iterator.Next(nil)
-	check(c, err)
-	
-	err = datastore.Delete(c, key)
-	check(c, err)
-	
-	encodedEntryKey = key.Encode()
+	var folder *Folder
 	
-	key, err = datastore.DecodeKey(feedKey)
+	key, err = datastore.DecodeKey(encodedKey)
 	check(c, err)
 	
-	feed = new(Feed)
-	err = datastore.Get(c, key, feed)
+	folder = new(Folder)
+	err = datastore.Get(c, key, folder)
 	check(c, err)
 	
-	feed.Entries = removeItem(feed.Entries, encodedEntryKey)
-	datastore.Put(c, key, feed)
+	return folder
 }
 
 /**
- * フィードをデータストアから読み出す
+ * フォルダ名の変更
  * @methodOf DAO
  * @param {appengine.Context} c コンテキスト
- * @param {string} feedKey エンコード済みのフィードキー
- * @retruns {*Feed} フィード
+ * @param {string} encodedKey フォルダのキー
+ * @param {string} name 新しいフォルダ名
  */
-func (this *DAO) getFeed(c appengine.Context, feedKey string) *Feed {
-	var feed *Feed
-	var err error
+func (this *DAO) renameFolder(c appengine.Context, encodedKey string, name string) {
 	var key *datastore.Key
-
-	feed = new(Feed)
-	key, err = datastore.DecodeKey(feedKey)
-	check(c, err)
+	var err error
 	
-	err = datastore.Get(c, key, feed)
+	key, err = datastore.DecodeKey(encodedKey)
 	check(c, err)
 	
-	return feed
+	this.modifyFolder(c, key, func(folder *Folder) bool {
+		folder.Title = name
+		return true
+	})
 }
 
 /**
- * 指定されたキーのデータが既に存在するか調べる
- * フィードやエントリなど重複させたくないデータはこの関数を使ってチェックする
+ * フォルダ・フィードの取得
+ * フォルダの中身を表示するときなど取り出す対象がどちらかわからないときに使用する
  * @methodOf DAO
  * @param {appengine.Context} c コンテキスト
- * @param {*Feed} feed フィード
- * @returns {bool} 重複していたらtrue
+ * @param {string} encodedKey アイテムのエンコード済みのキー
+ * @returns {string} 取得したアイテムがフォルダなら"folder",フィードなら"feed"
+ * @returns {*Folder or *Feed} 取得したフォルダまたはフィードオブジェクト
  */
-func (this *DAO) exist(c appengine.Context, feed *Feed) bool {
-	var result bool
+func (this *DAO) getItem(c appengine.Context, encodedKey string) (string, interface{}) {
+	var key *datastore.Key
 	var err error
-	var query *datastore.Query
-	var u *user.User
-	var count int
+	type FeedItem struct {
+		*Feed
+		Count int
+	}
+	type FolderItem struct {
+		*Folder
+		Count int
+	}
+	var feed *Feed
+	var folder *Folder
+	var item interface{}
+	var itemType string
 	
-	u = user.Current(c)
-	query = datastore.NewQuery("feed").Filter("URL =", feed.URL).Filter("Owner =", u.ID)
-	count, err = query.Count(c)
+	key, err = datastore.DecodeKey(encodedKey)
 	check(c, err)
 	
-	if count == 0 {
-		result = false
+	if key.Kind() == "feed" {
+		// 要素はFeed
+		feed = new(Feed)
+		err = datastore.Get(c, key, feed)
+		check(c, err)
+		item = &FeedItem{feed, len(feed.Entries)}
+		itemType = "feed"
 	} else {
-		result = true
+		// 要素はフォルダ
+		folder = new(Folder)
+		err = datastore.Get(c, key, folder)
+		check(c, err)
+		item = &FolderItem{folder, folder.Unread}
+		itemType = "folder"
 	}
 	
-	return result
+	return itemType, item
 }
 
 /**
- * すべてのデータを削除する
- * デバッグ用
+ * 指定されたフォルダ以下にある未読エントリの総数を返す
+ * 数え直さずにフォルダに保存してある未読数を返す
  * @methodOf DAO
- * @param {appengine.Context} c
+ * @param {appengine.Context} c コンテキスト
+ * @param {string} folderKey エンコード済みのフォルダキー
+ * @returns {int} 未読エントリの総数
  */
-func (this *DAO) clear(c appengine.Context) {
-	var keys []*datastore.Key
-	var query *datastore.Query
-	var err error
-	var kinds [3]string
-	var kind string
-	
-	keys = make([]*datastore.Key, 0)
-	kinds = [3]string{"folder", "feed", "entry"}
-	
-	for _, kind = range kinds {
-		query = datastore.NewQuery(kind).KeysOnly()
-		keys, err = query.GetAll(c, nil)
-		check(c, err)
-		datastore.DeleteMulti(c, keys)
-	}
+func (this *DAO) getEntriesCount(c appengine.Context, folderKey string) int {
+	return this.getFolder(c, folderKey).Unread
 }
 
 /**
- * フィードの更新
- * 指定されたフィードに新しく追加されたエントリをデータストアに追加する
+ * １人のユーザのフォルダの未読数を数え直す
+ * 差分を足し損ねたときなどのずれを直すために、cronからユーザごとのタスクに振り分けて定期的に実行する
+ * ルートフォルダから Children をたどってフィードの未読エントリの数を合計し、保存してある値と違えば書き換える
+ * ルートフォルダからたどれないフォルダは数え直さない　整合性の検査で戻したときに数え直す
+ * 数え直している間に増減した分は次に数え直すまでずれることがある
  * @methodOf DAO
  * @param {appengine.Context} c コンテキスト
- * @param {string} encodedFeedKey フィードのキー
- * @param {chan bool} 処理が完了したことを報告するチャネル　フォルダ更新から呼び出された場合に使用する
- * @returns {[]*Entry} 追加したエントリ一覧
+ * @param {string} owner ユーザID
+ * @returns {int} 未読数を書き換えたフォルダの数
  */
-func (this *DAO) updateFeed(c appengine.Context, encodedFeedKey string, parentChannel chan bool) []*Entry {
-	var feed *Feed
+func (this *DAO) recountUnread(c appengine.Context, owner string) int {
+	var rootKeys []string
+	var folders map[string]*Folder
+	var counts map[string]int
+	var count func(encodedKey string, path map[string]bool) int
+	var encodedKey string
+	var key *datastore.Key
+	var failed bool
+	var fixed int
 	var err error
-	var feedKey *datastore.Key
-	var currentEntries []*Entry
-	var newEntries []*Entry
-	var xml []byte
-	var i int
 	
-	// フィードの取得
-	feedKey, err = datastore.DecodeKey(encodedFeedKey)
+	rootKeys = make([]string, 0)
+	err = eachKey(c, datastore.NewQuery("folder").Filter("Type =", "root").Filter("Owner =", owner), func(key *datastore.Key) {
+		rootKeys = append(rootKeys, key.Encode())
+	})
 	check(c, err)
-	feed = new(Feed)
-	err = datastore.Get(c, feedKey, feed)
-	check(c, err)
-	
-	// URLからエントリをフェッチする
-	xml = getXML(c, feed.URL)
-	currentEntries = make([]*Entry, 0)
-	switch feed.Standard {
-		case "Atom":
-			var atom *Atom
-			atom = new(Atom)
-			_, currentEntries = atom.encode(c, xml)
-
-		case "RSS2.0":
-			var rss2 *RSS2
-			rss2 = new(RSS2)
-			_, currentEntries = rss2.encode(c, xml)
-			
-		case "RSS1.0":
-			var rss1 *RSS1
-			rss1 = new(RSS1)
-			_, currentEntries = rss1.encode(c, xml)
+	if err != nil {
+		return 0
 	}
 	
-	// エントリ一覧から最新エントリと同じURLを探す
-	newEntries = make([]*Entry, 0)
-	for i = 0; i < len(currentEntries); i++ {
-		if currentEntries[i].Link == feed.FinalEntry {
-			break
+	// フォルダ以下の未読数を合計する　循環している参照は数えない
+	// フィードはフォルダごとに maxBatchSize 件ずつまとめて読み込む
+	folders = make(map[string]*Folder)
+	counts = make(map[string]int)
+	count = func(encodedKey string, path map[string]bool) int {
+		var folder *Folder
+		var folderKey *datastore.Key
+		var childKey *datastore.Key
+		var feedKeys []*datastore.Key
+		var feeds []*Feed
+		var encodedChildKey string
+		var getErr error
+		var sum int
+		var done bool
+		var start int
+		var end int
+		var i int
+		var err error
+		
+		sum, done = counts[encodedKey]
+		if done {
+			return sum
+		}
+		folderKey, err = datastore.DecodeKey(encodedKey)
+		if err == nil {
+			folder = new(Folder)
+			err = datastore.Get(c, folderKey, folder)
+		}
+		if err != nil {
+			if err != datastore.ErrNoSuchEntity {
+				check(c, err)
+				failed = true
+			}
+			return 0
+		}
+		folders[encodedKey] = folder
+		path[encodedKey] = true
+		defer delete(path, encodedKey)
+		
+		feedKeys = make([]*datastore.Key, 0)
+		for _, encodedChildKey = range folder.Children {
+			childKey, err = datastore.DecodeKey(encodedChildKey)
+			if err != nil {
+				continue
+			}
+			if childKey.Kind() == "feed" {
+				feedKeys = append(feedKeys, childKey)
+			} else if childKey.Kind() == "folder" && !path[encodedChildKey] {
+				sum = sum + count(encodedChildKey, path)
+			}
+		}
+		for start = 0; start < len(feedKeys); start = end {
+			end = start + maxBatchSize
+			if end > len(feedKeys) {
+				end = len(feedKeys)
+			}
+			feeds = make([]*Feed, end - start)
+			getErr = datastore.GetMulti(c, feedKeys[start:end], feeds)
+			for i = range feeds {
+				err = ignoreMismatch(errorAt(getErr, i))
+				if err == nil {
+					sum = sum + len(feeds[i].Entries)
+				} else if err != datastore.ErrNoSuchEntity {
+					check(c, err)
+					failed = true
+				}
+			}
 		}
-		newEntries = append(newEntries, currentEntries[i])
+		counts[encodedKey] = sum
+		return sum
+	}
+	for _, encodedKey = range rootKeys {
+		count(encodedKey, make(map[string]bool))
+	}
+	
+	// 読み込めなかったものがあると少なく数えてしまうので書き換えない
+	if failed {
+		return 0
 	}
-	this.registerEntries(c, newEntries, encodedFeedKey)
 	
-	if parentChannel != nil {
-		parentChannel <- true
+	fixed = 0
+	for encodedKey = range counts {
+		if counts[encodedKey] == folders[encodedKey].Unread {
+			continue
+		}
+		key, err = datastore.DecodeKey(encodedKey)
+		check(c, err)
+		_, err = this.modifyFolder(c, key, func(folder *Folder) bool {
+			folder.Unread = counts[encodedKey]
+			return true
+		})
+		if err == nil {
+			fixed++
+		}
 	}
 	
-	return newEntries
+	c.Infof("recount unread of %s: %d of %d folders fixed", owner, fixed, len(counts))
+	return fixed
 }
 
 /**
- * フォルダの更新
+ * 指定されたフォルダ以下で最も新しいエントリの日時を返す
  * @methodOf DAO
  * @param {appengine.Context} c コンテキスト
- * @param {string} FolderKey フォルダのキー
- * @param {chan bool} parentChannel アップデートが完了したことを報告するチャネル　マルチスレッドで使う
- * @returns {map[string]int} 更新後の各フォルダ、フィードのエントリ件数
+ * @param {string} folderKey エンコード済みのフォルダキー
+ * @returns {time.Time} 最新のエントリの日時　フィードがなければゼロ値
  */
-func (this *DAO) updateFolder(c appengine.Context, folderKey string, parentChannel chan bool) map[string]int {
-	var folder *Folder
-	var childKey string
-	var childType string
-	var result map[string]int
-	var feed *Feed
-	var childrenChannel chan bool
-	var i int
+func (this *DAO) getFolderUpdated(c appengine.Context, folderKey string) time.Time {
+	var latest time.Time
+	var visited map[string]bool
+	var walk func(key string)
 	
-	folder = this.getFolder(c, folderKey)
+	// 壊れたデータで循環していても同じフォルダを二度たどらない
+	visited = make(map[string]bool)
+	walk = func(key string) {
+		var folder *Folder
+		var childKey string
+		var child *datastore.Key
+		var updated time.Time
+		var err error
 		
-	// 新規エントリをマルチスレッドで一斉に取得・追加する
-	// 各URLフェッチに時間がかかるため
-	childrenChannel = make(chan bool)
-	for _, childKey = range folder.Children {
-		childType, _ = this.getItem(c, childKey)
-		if childType == "folder" {
-			go this.updateFolder(c, childKey, childrenChannel)
-		} else if childType == "feed" {
-			go this.updateFeed(c, childKey, childrenChannel)
+		if visited[key] {
+			return
 		}
-	}
-	
-	// すべてのスレッドが完了するまで待機
-	for i = 0; i < len(folder.Children); i++ {
-		<- childrenChannel
-	}
-	
-	// 呼び出し元直下のエントリの数をカウントして返す
-	result = make(map[string]int)
-	if parentChannel != nil {
-		parentChannel <- true
-	} else {
+		visited[key] = true
+		folder = this.getFolder(c, key)
 		for _, childKey = range folder.Children {
-			childType, _ = this.getItem(c, childKey)
-			if childType == "folder" {
-				result[childKey] = this.getEntriesCount(c, childKey)
-			} else if childType == "feed" {
-				feed = this.getFeed(c, childKey)
-				result[childKey] = len(feed.Entries)
+			child, err = datastore.DecodeKey(childKey)
+			if err != nil {
+				continue
+			}
+			if child.Kind() == "folder" {
+				walk(childKey)
+				continue
+			}
+			updated = this.getFeed(c, childKey).Updated
+			if updated.After(latest) {
+				latest = updated
 			}
 		}
 	}
+	walk(folderKey)
 	
-	return result
+	return latest
 }
 
 /**
- * XMLファイルを解析してフォルダ・フィードツリーを返す
+ * フォルダの中身を並び順に従って返す
+ * 中のフォルダの最新の日時は更新順に並べるときだけ求める
  * @methodOf DAO
  * @param {appengine.Context} c コンテキスト
- * @param {[]byte} xmldata XMLデータ
- * @returns {[]interface{}} フォルダ・フィードツリー
+ * @param {*Folder} folder フォルダ
+ * @returns {[]*FolderChild} フォルダの中身
  */
-func (this *DAO) getTreeFromXML(c appengine.Context, xmldata []byte) []*Node {
-	type OUTLINE struct {
-		Outline []OUTLINE `xml:"outline"`
-		Title string `xml:"title,attr"`
-		XMLURL string `xml:"xmlUrl,attr"`
-		HTMLURL string `xml:"htmlUrl,attr"`
-	}
-	type OPML struct {
-		Outline []OUTLINE `xml:"body>outline"`
-	}
-	var opml *OPML
+func (this *DAO) getChildren(c appengine.Context, folder *Folder) []*FolderChild {
+	var children []*FolderChild
+	var child *FolderChild
+	var childKey string
+	var key *datastore.Key
+	var feed *Feed
+	var childFolder *Folder
 	var err error
-	var depth1 OUTLINE
-	var depth2 OUTLINE
-	var i int
-	var j int
-	var tree []*Node
-	var folder *Node
-	var feed *Node
-	
-	opml = new(OPML)
-	err = xml.Unmarshal(xmldata, opml)
-	check(c, err)
 	
-	tree = make([]*Node, len(opml.Outline))
-	for i, depth1 = range opml.Outline {
-		if depth1.XMLURL == "" {
-			folder = new(Node)
-			folder.kind = "folder"
-			folder.title = depth1.Title
-			folder.children = make([]*Node, len(depth1.Outline))
-			for j, depth2 = range depth1.Outline {
-				feed = new(Node)
-				feed.kind = "feed"
-				feed.title = depth2.Title
-				feed.xmlURL = depth2.XMLURL
-				feed.htmlURL = depth2.HTMLURL
-				folder.children[j] = feed
+	children = make([]*FolderChild, 0, len(folder.Children))
+	for _, childKey = range folder.Children {
+		key, err = datastore.DecodeKey(childKey)
+		if err != nil {
+			check(c, err)
+			continue
+		}
+		child = &FolderChild{Key: childKey, Type: key.Kind()}
+		if key.Kind() == "folder" {
+			childFolder = this.getFolder(c, childKey)
+			child.Title = childFolder.Title
+			child.Count = childFolder.Unread
+			if folder.Sort == "updated" {
+				child.Updated = this.getFolderUpdated(c, childKey)
 			}
-			tree[i] = folder
 		} else {
-			feed = new(Node)
-			feed.kind = "feed"
-			feed.title = depth1.Title
-			feed.xmlURL = depth1.XMLURL
-			feed.htmlURL = depth1.HTMLURL
-			tree[i] = feed
+			feed = this.getFeed(c, childKey)
+			child.Title = feed.Title
+			child.Count = len(feed.Entries)
+			child.Updated = feed.Updated
 		}
+		children = append(children, child)
 	}
-
-	return tree
+	
+	if folder.Sort != "" && folder.Sort != "manual" {
+		sort.Stable(&ChildrenBy{children, folder.Sort})
+	}
+	
+	return children
 }
 
 /**
- * XMLファイルをデータストアにインポートする
+ * フォルダの並び順を変更する
+ * Children の順は変えないので、"manual" に戻すとユーザが並べ替えた順に戻る
  * @methodOf DAO
  * @param {appengine.Context} c コンテキスト
- * @param {[]byte} xmldate XMLファイル
- * @param {string} folderKey 追加先のフォルダのキー
+ * @param {string} encodedKey フォルダのキー
+ * @param {string} mode 並び順(sortModes のいずれか)
+ * @returns {string} "success" 変更した, "invalid" 並び順の指定が正しくない, "not_found" フォルダが見つからない,
+ *     "forbidden" フォルダがログインユーザのものではない
  */
-func (this *DAO) importXML(c appengine.Context, tree []*Node, folderKey string) {
-	var feed *Feed
+func (this *DAO) setFolderSort(c appengine.Context, encodedKey string, mode string) string {
+	var key *datastore.Key
+	var folder *Folder
 	var u *user.User
-	var parentKey string
-	var entries []*Entry
-	var depth1 *Node
-	var depth2 *Node
+	var result string
+	var valid bool
+	var m string
+	var err error
 	
 	u = user.Current(c)
+	if u == nil {
+		return "forbidden"
+	}
 	
-	for _, depth1 = range tree {
-		if depth1.kind == "folder" {
-			parentKey = this.registerFolder(c, u, depth1.title, false, folderKey)
-			for _, depth2 = range depth1.children {
-				feed = new(Feed)
-				entries = make([]*Entry, 0)
-				feed, entries = this.getFeedFromXML(c, depth2.xmlURL)
-				feed.URL = depth2.xmlURL
-				feed.SiteURL = depth2.htmlURL
-				this.registerFeed(c, feed, entries, parentKey)
-			}
-		} else {
-			feed = new(Feed)
-			entries = make([]*Entry, 0)
-			feed, entries = this.getFeedFromXML(c, depth1.xmlURL)
-			feed.URL = depth1.xmlURL
-			feed.SiteURL = depth1.htmlURL
-			this.registerFeed(c, feed, entries, parentKey)
+	for _, m = range sortModes {
+		if m == mode {
+			valid = true
+		}
+	}
+	if !valid {
+		return "invalid"
+	}
+	
+	key, err = datastore.DecodeKey(encodedKey)
+	if err != nil {
+		return "not_found"
+	}
+	
+	err = this.transaction(c, func(tc appengine.Context) error {
+		var err error
+		
+		folder = new(Folder)
+		err = datastore.Get(tc, key, folder)
+		if err != nil {
+			return err
 		}
+		if folder.Owner != u.ID {
+			result = "forbidden"
+			return nil
+		}
+		folder.Sort = mode
+		_, err = datastore.Put(tc, key, folder)
+		result = "success"
+		return err
+	})
+	if err == datastore.ErrNoSuchEntity {
+		return "not_found"
 	}
+	check(c, err)
+	
+	return result
 }
 
 /**
- * XMLのURLからフィードを取得する
+ * フォルダの中身をユーザが指定した順に並べ替える
+ * 並び順は "manual" になる
+ * 並べ替えている間に追加・移動されたものがあれば、指定された順が今の中身と合わないので並べ替えない
  * @methodOf DAO
  * @param {appengine.Context} c コンテキスト
- * @param {string} url XMLファイルの場所
- * @returns {*Feed} フィード
- * @returns {[]*Entries} フィードのエントリリスト
+ * @param {string} encodedKey フォルダのキー
+ * @param {[]string} order 並べ替えた後の中身のキーリスト
+ * @returns {string} "success" 並べ替えた, "mismatch" 指定された中身が今の中身と違う, "not_found" フォルダが見つからない,
+ *     "forbidden" フォルダがログインユーザのものではない
  */
-func (this *DAO) getFeedFromXML(c appengine.Context, url string) (*Feed, []*Entry) {
-	var feedXML []byte
-	var feedType string
-	var feed *Feed
-	var entries []*Entry
+func (this *DAO) reorderFolder(c appengine.Context, encodedKey string, order []string) string {
+	var key *datastore.Key
+	var u *user.User
+	var result string
+	var err error
 	
-	feedXML = getXML(c, url)
+	u = user.Current(c)
+	if u == nil {
+		return "forbidden"
+	}
 	
-	feed = new(Feed)
-	entries = make([]*Entry, 0)
-	feedType = this.getType(c, feedXML)
-	switch feedType {
-		case "Atom":
-			var atom *Atom
-			atom = new(Atom)
-			feed, entries = atom.encode(c, feedXML)
-		case "RSS2.0":
-			var rss2 *RSS2
-			rss2 = new(RSS2)
-			feed, entries = rss2.encode(c, feedXML)
-		case "RSS1.0":
-			var rss1 *RSS1
-			rss1 = new(RSS1)
-			feed, entries = rss1.encode(c, feedXML)
-		case "etc":
+	key, err = datastore.DecodeKey(encodedKey)
+	if err != nil {
+		return "not_found"
 	}
-	feed.URL = url
 	
-	return feed, entries
+	err = this.transaction(c, func(tc appengine.Context) error {
+		var folder *Folder
+		var remaining map[string]bool
+		var childKey string
+		var err error
+		
+		folder = new(Folder)
+		err = datastore.Get(tc, key, folder)
+		if err != nil {
+			result = "not_found"
+			return err
+		}
+		if folder.Owner != u.ID {
+			result = "forbidden"
+			return nil
+		}
+		
+		// 同じキーを同じ数だけ含んでいるか確かめる
+		remaining = make(map[string]bool)
+		for _, childKey = range folder.Children {
+			remaining[childKey] = true
+		}
+		if len(order) != len(folder.Children) {
+			result = "mismatch"
+			return nil
+		}
+		for _, childKey = range order {
+			if !remaining[childKey] {
+				result = "mismatch"
+				return nil
+			}
+			delete(remaining, childKey)
+		}
+		
+		folder.Children = order
+		folder.Sort = "manual"
+		_, err = datastore.Put(tc, key, folder)
+		if err != nil {
+			result = "failed"
+			return err
+		}
+		result = "success"
+		return nil
+	})
+	check(c, err)
+	
+	return result
 }
 
 /**
- * XMLデータの規格を判断する
+ * ルートフォルダを取得
  * @methodOf DAO
  * @param {appengine.Context} c コンテキスト
- * @param {[]byte} bytes XMLデータ
- * @returns {string} フィードの規格(RSS1.0 / RSS2.0 / Atom / etc)
+ * @param {*user.User} u ユーザオブジェクト
+ * @returns {string} ルートフォルダのキー
+ * @returns {*Folder} ルートフォルダ
  */
-func (this *DAO) getType(c appengine.Context, bytes []byte) string {
-	type Checker struct {
-		XMLName xml.Name
-	}
-	var checker *Checker
+func (this *DAO) getRootFolder(c appengine.Context, u *user.User) (string, *Folder) {
+	var root *Folder
+	var query *datastore.Query
+	var iterator *datastore.Iterator
 	var err error
-	var result string
+	var key *datastore.Key
+	var encodedKey string
 	
-	checker = new(Checker)
-	err = xml.Unmarshal(bytes, checker)
+	root = new(Folder)
+	query = datastore.NewQuery("folder").Filter("Type =", "root").Filter("Owner =", u.ID)
+	iterator = query.Run(c)
+	key, err = iterator.Next(root)
 	check(c, err)
 	
-	switch checker.XMLName.Local {
-		case "feed":
-			result = "Atom"
-		case "rss":
-			result = "RSS2.0"
-		case "RDF":
-			result = "RSS1.0"
-		default:
-			result = "etc"
+	if key != nil {
+		encodedKey = key.Encode()
 	}
 	
-	return result
+	return encodedKey, root
 }
 
 /**
- * XMLデータをデータストアに保存する
+ * フィードをデータストアに追加
+ * 既に存在するフィードは無視する
  * @methodOf DAO
  * @param {appengine.Context} c コンテキスト
- * @param {[]byte} xml XMLデータ
+ * @param {*Feed} feed 登録するフィードオブジェクト
+ * @param {[]*Entry} entries フィードのエントリリスト
+ * @param {string} to 追加先のフォルダのキー
+ * @returns {string} 追加したフィードのキーをエンコードしたもの　重複していたか保存できなければ空文字列
+ * @returnss {bool} 重複していた場合はtrue
  */
-func (this *DAO) saveXML(c appengine.Context, xml []byte) {
-	var u *user.User
+func (this *DAO) registerFeed(c appengine.Context, feed *Feed, entries []*Entry, to string) (string, bool) {
 	var key *datastore.Key
+	var encodedKey string
 	var err error
-	type Entity struct {
-		XML []byte
-	}
-	var entity *Entity
+	var parentFolderKey *datastore.Key
+	var sourceKey *datastore.Key
+	var duplicated bool
+	var u *user.User
 	
-	u = user.Current(c)
-	key = datastore.NewKey(c, "xml", u.ID, 0, nil)
+	// 重複していたら登録しない
+	duplicated = this.exist(c, feed)
+	if duplicated {
+		encodedKey = ""
+	} else {
+		// ユーザID追加
+		u = user.Current(c)
+		feed.Owner = u.ID
+		
+		// フィードを保存して親フォルダの子に追加
+		feed.Parent = to
+		parentFolderKey, err = datastore.DecodeKey(to)
+		check(c, err)
+		if err != nil {
+			return "", false
+		}
+		err = this.transaction(c, func(tc appengine.Context) error {
+			var parentFolder *Folder
+			var err error
+			
+			parentFolder = new(Folder)
+			err = datastore.Get(tc, parentFolderKey, parentFolder)
+			if err != nil {
+				return err
+			}
+			key, err = datastore.Put(tc, datastore.NewIncompleteKey(tc, "feed", nil), feed)
+			if err != nil {
+				return err
+			}
+			encodedKey = key.Encode()
+			parentFolder.Children = append(parentFolder.Children, encodedKey)
+			_, err = datastore.Put(tc, parentFolderKey, parentFolder)
+			return err
+		})
+		check(c, err)
+		if err != nil {
+			return "", false
+		}
+		
+		// 受信元に登録して本文を保存してからエントリを追加
+		sourceKey, err = datastore.DecodeKey(this.subscribe(c, encodedKey, feed))
+		check(c, err)
+		this.storeItems(c, sourceKey, entries)
+		this.registerEntries(c, entries, encodedKey)
+		this.seedSeen(c, sourceKey, entries)
+	}
 	
-	entity = new(Entity)
-	entity.XML = xml
-	_, err = datastore.Put(c, key, entity)
-	check(c, err)
+	return encodedKey, duplicated
 }
 
 /**
- * XMLデータをデータストアから取得する
+ * 新しい受信元に登録時のエントリを配信済みとして記録する
+ * 既に記録のある受信元では、他の購読者にまだ配っていないエントリがあるかもしれないので何もしない
  * @methodOf DAO
  * @param {appengine.Context} c コンテキスト
- * @returns {[]byte} ロードしたXMLデータ
+ * @param {*datastore.Key} sourceKey 受信元のキー
+ * @param {[]*Entry} entries 登録時に受信したエントリ一覧
  */
-func (this *DAO) loadXML(c appengine.Context) []byte {
-	var u *user.User
+func (this *DAO) seedSeen(c appengine.Context, sourceKey *datastore.Key, entries []*Entry) {
+	this.modifySource(c, sourceKey, func(source *Source) bool {
+		if len(source.Seen) > 0 || source.FinalEntry != "" {
+			return false
+		}
+		this.newEntries(source, entries, time.Now())
+		return true
+	})
+}
+
+/**
+ * フィード名を変更する
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {string} encodedKey エンコード済みのフィードキー
+ * @param {string} name 新しい名前
+ */
+func (this *DAO) renameFeed(c appengine.Context, encodedKey string, name string) {
 	var key *datastore.Key
 	var err error
-	type Entity struct {
-		XML []byte
-	}
-	var entity *Entity
 	
-	u = user.Current(c)
-	key = datastore.NewKey(c, "xml", u.ID, 0, nil)
-	entity = new(Entity)
-	err = datastore.Get(c, key, entity)
+	key, err = datastore.DecodeKey(encodedKey)
 	check(c, err)
 	
-	return entity.XML
+	this.modifyFeed(c, key, func(feed *Feed) bool {
+		feed.Title = name
+		return true
+	})
 }
 
 /**
- * すべてのフォルダをアップデートする
+ * フィードの削除
+ * 親フォルダからの参照の削除とフィードの削除はトランザクションでまとめて行い、
+ * エントリはその後で削除する
  * @methodOf DAO
  * @param {appengine.Context} c コンテキスト
+ * @param {string} encodedKey エンコード済みのフィードキー
  */
-func (this *DAO) updateAll(c appengine.Context) {
-	var query *datastore.Query
-	var iterator *datastore.Iterator
+func (this *DAO) removeFeed(c appengine.Context, encodedKey string) {
 	var key *datastore.Key
 	var err error
-	var count int
-	var i int
+	var feed *Feed
+	var encodedEntryKey string
+	var entryKey *datastore.Key
+	var entry *Entry
 	
-	query = datastore.NewQuery("folder").Filter("Type =", "root").KeysOnly()
-	count, err = query.Count(c)
+	key, err = datastore.DecodeKey(encodedKey)
 	check(c, err)
-	iterator = query.Run(c)
-	for i = 0; i < count; i++ {
-		key, err = iterator.Next(nil)
+	
+	// 親フォルダからの参照を削除してフィードを削除
+	err = this.transaction(c, func(tc appengine.Context) error {
+		var err error
+		
+		feed = new(Feed)
+		err = datastore.Get(tc, key, feed)
+		if err != nil {
+			return err
+		}
+		err = this.detach(tc, feed.Parent, encodedKey)
+		if err != nil {
+			return err
+		}
+		return datastore.Delete(tc, key)
+	})
+	check(c, err)
+	if err != nil {
+		return
+	}
+	this.addUnread(c, feed.Parent, -len(feed.Entries))
+	
+	// フィードに含まれるエントリを既読のものも含めて削除
+	// スター付きのエントリは本文を残す理由がなくなったことを記録する
+	for _, encodedEntryKey = range append(feed.Entries, feed.ReadEntries...) {
+		entryKey, err = datastore.DecodeKey(encodedEntryKey)
+		check(c, err)
+		entry = new(Entry)
+		if datastore.Get(c, entryKey, entry) != datastore.ErrNoSuchEntity && entry.Starred {
+			err = this.transaction(c, func(tc appengine.Context) error {
+				return this.countStar(tc, entry.Item, -1)
+			})
+			check(c, err)
+		}
+		err = datastore.Delete(c, entryKey)
 		check(c, err)
-		this.updateFolder(c, key.Encode(), nil)
 	}
-	log.Printf("update all folder")
-}
\ No newline at end of file
+	
+	// 受信元の購読者から外す
+	this.unsubscribe(c, encodedKey, feed)
+}
+
+/**
+ * フォルダの既読化
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {string} encodedKey
+ */
+func (this *DAO) readFolder(c appengine.Context, encodedKey string) {
+	var feedKey string
+	
+	// フォルダ以下にあるすべてのフィードを既読化
+	for _, feedKey = range this.getDescendantFeeds(c, encodedKey) {
+		this.readFeed(c, feedKey)
+	}
+}
+
+/**
+ * フィードの既読化
+ * エントリは削除せずに既読の印を付けて既読エントリのリストへ移す
+ * 印を付けている間に届いたエントリは未読のまま残す
+ * 印を付けられなかったエントリは未読のまま残し、存在しないエントリはリストから外す
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {string} encodedKey フィードのキー
+ */
+func (this *DAO) readFeed(c appengine.Context, encodedKey string) {
+	var key *datastore.Key
+	var err error
+	var feed *Feed
+	var unread []string
+	var marked []string
+	var missing []string
+	var batch []string
+	var encodedEntryKey string
+	var entryKey *datastore.Key
+	var entryKeys []*datastore.Key
+	var entries []*Entry
+	var found []string
+	var foundKeys []*datastore.Key
+	var foundEntries []*Entry
+	var getErr error
+	var entryErr error
+	var now time.Time
+	var start int
+	var end int
+	var i int
+	
+	key, err = datastore.DecodeKey(encodedKey)
+	check(c, err)
+	
+	feed = new(Feed)
+	err = datastore.Get(c, key, feed)
+	check(c, err)
+	if len(feed.Entries) == 0 {
+		return
+	}
+	unread = feed.Entries
+	
+	// フィードに含まれる未読エントリに一括操作の上限ずつ既読の印を付ける
+	marked = make([]string, 0, len(unread))
+	missing = make([]string, 0)
+	now = time.Now()
+	for start = 0; start < len(unread); start = end {
+		end = start + maxBatchSize
+		if end > len(unread) {
+			end = len(unread)
+		}
+		batch = make([]string, 0, end - start)
+		entryKeys = make([]*datastore.Key, 0, end - start)
+		for _, encodedEntryKey = range unread[start:end] {
+			entryKey, err = datastore.DecodeKey(encodedEntryKey)
+			check(c, err)
+			if err != nil {
+				continue
+			}
+			batch = append(batch, encodedEntryKey)
+			entryKeys = append(entryKeys, entryKey)
+		}
+		entries = make([]*Entry, len(entryKeys))
+		for i = range entries {
+			entries[i] = new(Entry)
+		}
+		getErr = datastore.GetMulti(c, entryKeys, entries)
+		
+		// 読み込めたエントリだけに印を付ける
+		found = make([]string, 0, len(batch))
+		foundKeys = make([]*datastore.Key, 0, len(batch))
+		foundEntries = make([]*Entry, 0, len(batch))
+		for i = range entryKeys {
+			entryErr = ignoreMismatch(errorAt(getErr, i))
+			if entryErr == datastore.ErrNoSuchEntity {
+				missing = append(missing, batch[i])
+				continue
+			}
+			check(c, entryErr)
+			if entryErr != nil {
+				continue
+			}
+			entries[i].Read = true
+			entries[i].ReadAt = now
+			found = append(found, batch[i])
+			foundKeys = append(foundKeys, entryKeys[i])
+			foundEntries = append(foundEntries, entries[i])
+		}
+		if len(foundKeys) == 0 {
+			continue
+		}
+		_, err = datastore.PutMulti(c, foundKeys, foundEntries)
+		check(c, err)
+		if err == nil {
+			marked = append(marked, found...)
+		}
+	}
+	if len(marked) == 0 && len(missing) == 0 {
+		return
+	}
+	
+	// 印を付けたエントリだけを既読エントリのリストへ移す
+	this.modifyFeed(c, key, func(current *Feed) bool {
+		var moved []string
+		var encodedEntryKey string
+		var changed bool
+		
+		moved = make([]string, 0, len(marked))
+		for _, encodedEntryKey = range marked {
+			if contains(current.Entries, encodedEntryKey) {
+				current.Entries = removeItem(current.Entries, encodedEntryKey)
+				moved = append(moved, encodedEntryKey)
+			}
+		}
+		current.ReadEntries = prepend(current.ReadEntries, moved)
+		changed = len(moved) > 0
+		
+		for _, encodedEntryKey = range missing {
+			if contains(current.Entries, encodedEntryKey) {
+				current.Entries = removeItem(current.Entries, encodedEntryKey)
+				changed = true
+			}
+		}
+		return changed
+	})
+}
+
+/**
+ * 複数のエントリをフィードに一括で新規追加する
+ * 既にフィードに存在するIDのエントリは追加しない
+ * エントリはフィードの子として保存し、フィードのエントリリストへの追加とトランザクションでまとめて行う
+ * 一度に保存できる数には限りがあるので maxBatchSize 件ずつ別のトランザクションで追加する
+ * 途中で失敗しても、それまでに追加したエントリはフィードに残る
+ * エントリのキーはIDから決めるので、同じエントリを同時に登録しようとしても重複しない
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {[]*Entry} entries 追加するエントリ配列
+ * @param {string} to 追加先のフィードのキー
+ * @returns {[]*Entry} 実際に追加したエントリ配列
+ * @returns {error} 保存できなかったときのエラー
+ */
+func (this *DAO) registerEntries(c appengine.Context, entries []*Entry, to string) ([]*Entry, error) {
+	var entry *Entry
+	var candidates []*Entry
+	var batch []*Entry
+	var added []*Entry
+	var result []*Entry
+	var err error
+	var feed *Feed
+	var feedKey *datastore.Key
+	var known map[string]bool
+	var parent string
+	var start int
+	var end int
+	
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	
+	feedKey, err = datastore.DecodeKey(to)
+	check(c, err)
+	if err != nil {
+		return nil, err
+	}
+	feed = this.getFeed(c, to)
+	
+	// 登録済みかどうかはIDから決まるキーがフィードのリストにあるかで判断するので、
+	// ここでは同じIDが重ならないようにするだけでよい
+	known = make(map[string]bool)
+	candidates = make([]*Entry, 0, len(entries))
+	for _, entry = range entries {
+		if known[entry.ID] {
+			continue
+		}
+		known[entry.ID] = true
+		
+		// cronから呼ばれたときはログインユーザがいないのでフィードの所有者を使う
+		entry.Owner = feed.Owner
+		entry.Feed = to
+		candidates = append(candidates, entry)
+	}
+	if len(candidates) == 0 {
+		return candidates, nil
+	}
+	
+	// エントリリストの先頭に加えていくので、新しいエントリが先頭に来るよう後ろの組から追加する
+	result = make([]*Entry, 0, len(candidates))
+	for end = len(candidates); end > 0; end = start {
+		start = end - maxBatchSize
+		if start < 0 {
+			start = 0
+		}
+		batch = candidates[start:end]
+		
+		err = this.transaction(c, func(tc appengine.Context) error {
+			var current *Feed
+			var listed map[string]bool
+			var keys []*datastore.Key
+			var encodedKeys []string
+			var key *datastore.Key
+			var encodedKey string
+			var err error
+			
+			current = new(Feed)
+			err = datastore.Get(tc, feedKey, current)
+			if err != nil {
+				return err
+			}
+			parent = current.Parent
+			
+			// 読み込んでから今までの間に登録されたものは除く
+			listed = make(map[string]bool)
+			for _, encodedKey = range append(current.Entries, current.ReadEntries...) {
+				listed[encodedKey] = true
+			}
+			keys = make([]*datastore.Key, 0, len(batch))
+			encodedKeys = make([]string, 0, len(batch))
+			added = make([]*Entry, 0, len(batch))
+			for _, entry = range batch {
+				key = datastore.NewKey(tc, "entry", seenID(entry), 0, feedKey)
+				if listed[key.Encode()] {
+					continue
+				}
+				keys = append(keys, key)
+				encodedKeys = append(encodedKeys, key.Encode())
+				added = append(added, entry)
+			}
+			if len(keys) == 0 {
+				return nil
+			}
+			
+			_, err = datastore.PutMulti(tc, keys, added)
+			if err != nil {
+				return err
+			}
+			current.Entries = prepend(current.Entries, encodedKeys)
+			
+			// 最新のエントリの日時を保存
+			for _, entry = range added {
+				if entry.Published.After(current.Updated) {
+					current.Updated = entry.Published
+				}
+			}
+			_, err = datastore.Put(tc, feedKey, current)
+			return err
+		})
+		check(c, err)
+		if err != nil {
+			break
+		}
+		result = append(added, result...)
+	}
+	this.addUnread(c, parent, len(result))
+	
+	return result, err
+}
+
+/**
+ * 指定されたフィードの未読エントリをすべて返す
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {string} feedKey エンコード済みのフィードキー
+ * @returns {[]*Entry} エントリ配列
+ */
+func (this *DAO) getEntries(c appengine.Context, feedKey string) []*Entry {
+	return this.loadEntries(c, this.getFeed(c, feedKey).Entries)
+}
+
+/**
+ * 指定されたフィードの既読エントリを既読にした新しい順に返す
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {string} feedKey エンコード済みのフィードキー
+ * @returns {[]*Entry} エントリ配列
+ */
+func (this *DAO) getReadEntries(c appengine.Context, feedKey string) []*Entry {
+	return this.loadEntries(c, this.getFeed(c, feedKey).ReadEntries)
+}
+
+/**
+ * キーリストのエントリをまとめて読み出す
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {[]string} encodedKeys エンコード済みのエントリキーのリスト
+ * @returns {[]*Entry} エントリ配列　読み出せなかったものは含めない
+ */
+func (this *DAO) loadEntries(c appengine.Context, encodedKeys []string) []*Entry {
+	var encodedKey string
+	var entry *Entry
+	var key *datastore.Key
+	var err error
+	var entries []*Entry
+	
+	entries = make([]*Entry, 0, len(encodedKeys))
+	for _, encodedKey = range encodedKeys {
+		key, err = datastore.DecodeKey(encodedKey)
+		check(c, err)
+		
+		entry = new(Entry)
+		err = datastore.Get(c, key, entry)
+		check(c, err)
+		if err == datastore.ErrNoSuchEntity {
+			continue
+		}
+		
+		// IDを持たない古いエントリはハッシュで識別する
+		if entry.ID == "" {
+			entry.ID = entryHash(entry)
+		}
+		
+		entries = append(entries, entry)
+	}
+	
+	return entries
+}
+
+/**
+ * 指定された期間に公開されたユーザのエントリを新しい順に返す
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {*user.User} u ユーザ
+ * @param {time.Time} from 期間の始まり(この日時を含む)
+ * @param {time.Time} to 期間の終わり(この日時を含まない)
+ * @returns {[]*Entry} エントリ配列
+ */
+func (this *DAO) getEntriesByDate(c appengine.Context, u *user.User, from time.Time, to time.Time) []*Entry {
+	var query *datastore.Query
+	var entries []*Entry
+	var err error
+	
+	entries = make([]*Entry, 0)
+	query = datastore.NewQuery("entry").Filter("Owner =", u.ID).Filter("Published >=", from).Filter("Published <", to).Order("-Published")
+	_, err = query.GetAll(c, &entries)
+	check(c, err)
+	
+	return entries
+}
+
+/**
+ * フィードの中からIDでエントリを探す
+ * エントリのキーはフィードのキーとIDから決まるので、読み込まずにキーを求めるだけで見つかる
+ * キーをIDから決めるようになる前に保存したエントリ(親を持たないキー)だけは１件ずつ読み込んで探す
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {*datastore.Key} feedKey フィードのキー
+ * @param {[]string} candidates 探す範囲のエントリのキーリスト
+ * @param {string} id エントリのID
+ * @returns {*datastore.Key} エントリのキー　見つからなければnil
+ */
+func (this *DAO) findEntry(c appengine.Context, feedKey *datastore.Key, candidates []string, id string) *datastore.Key {
+	var key *datastore.Key
+	var encodedKey string
+	var entry *Entry
+	var err error
+	
+	key = datastore.NewKey(c, "entry", hashID(id), 0, feedKey)
+	if contains(candidates, key.Encode()) {
+		return key
+	}
+	
+	// 以前の形式のエントリ
+	for _, encodedKey = range candidates {
+		key, err = datastore.DecodeKey(encodedKey)
+		if err != nil || key.Parent() != nil {
+			continue
+		}
+		entry = new(Entry)
+		err = ignoreMismatch(datastore.Get(c, key, entry))
+		if err != nil {
+			continue
+		}
+		if entry.ID == "" {
+			entry.ID = entryHash(entry)
+		}
+		if entry.ID == id {
+			return key
+		}
+	}
+	return nil
+}
+
+/**
+ * 指定されたエントリを既読または未読にする
+ * 既読にしたエントリは削除せずに既読エントリのリストへ移し、未読に戻すと未読エントリのリストへ戻す
+ * エントリの印とフィードのリストの書き換えはトランザクションでまとめて行う
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {string} id エントリのID
+ * @param {string} feedKey エントリが登録されているフィードのキー
+ * @param {bool} read 既読にするならtrue、未読に戻すならfalse
+ */
+func (this *DAO) markEntry(c appengine.Context, id string, feedKey string, read bool) {
+	var key *datastore.Key
+	var entryKey *datastore.Key
+	var err error
+	var feed *Feed
+	var entry *Entry
+	var from []string
+	var target string
+	var parent string
+	var delta int
+	
+	key, err = datastore.DecodeKey(feedKey)
+	check(c, err)
+	
+	feed = new(Feed)
+	err = datastore.Get(c, key, feed)
+	check(c, err)
+	
+	if read {
+		from = feed.Entries
+	} else {
+		from = feed.ReadEntries
+	}
+	
+	// フィードの中から同じIDのエントリを探す
+	entryKey = this.findEntry(c, key, from, id)
+	if entryKey == nil {
+		return
+	}
+	target = entryKey.Encode()
+	
+	err = this.transaction(c, func(tc appengine.Context) error {
+		var current *Feed
+		var err error
+		
+		delta = 0
+		current = new(Feed)
+		err = datastore.Get(tc, key, current)
+		if err != nil {
+			return err
+		}
+		parent = current.Parent
+		entry = new(Entry)
+		err = ignoreMismatch(datastore.Get(tc, entryKey, entry))
+		if err != nil {
+			return err
+		}
+		
+		// 探している間に他で移されていたら何もしない
+		entry.Read = read
+		if read {
+			if !contains(current.Entries, target) {
+				return nil
+			}
+			entry.ReadAt = time.Now()
+			current.Entries = removeItem(current.Entries, target)
+			current.ReadEntries = prepend(current.ReadEntries, []string{target})
+			delta = -1
+		} else {
+			if !contains(current.ReadEntries, target) {
+				return nil
+			}
+			entry.ReadAt = time.Time{}
+			current.ReadEntries = removeItem(current.ReadEntries, target)
+			current.Entries = prepend(current.Entries, []string{target})
+			delta = 1
+		}
+		_, err = datastore.Put(tc, entryKey, entry)
+		if err != nil {
+			return err
+		}
+		_, err = datastore.Put(tc, key, current)
+		return err
+	})
+	check(c, err)
+	if err == nil {
+		this.addUnread(c, parent, delta)
+	}
+}
+
+/**
+ * 保存期間を過ぎた既読エントリをすべてのフィードから削除する
+ * スター付きのエントリは削除しない
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @returns {int} 削除したエントリの数
+ */
+func (this *DAO) purgeReadEntries(c appengine.Context) int {
+	var iterator *datastore.Iterator
+	var key *datastore.Key
+	var entryKey *datastore.Key
+	var feed *Feed
+	var entry *Entry
+	var encodedEntryKey string
+	var expired []string
+	var expiredKeys []*datastore.Key
+	var cutoff time.Time
+	var count int
+	var err error
+	
+	cutoff = time.Now().Add(-readRetention())
+	iterator = datastore.NewQuery("feed").Run(c)
+	for {
+		feed = new(Feed)
+		key, err = iterator.Next(feed)
+		if err == datastore.Done {
+			break
+		}
+		check(c, err)
+		if err != nil {
+			break
+		}
+		
+		// 既読にした新しい順に並んでいるが、念のためすべて確認する
+		expired = make([]string, 0)
+		expiredKeys = make([]*datastore.Key, 0)
+		for _, encodedEntryKey = range feed.ReadEntries {
+			entryKey, err = datastore.DecodeKey(encodedEntryKey)
+			check(c, err)
+			entry = new(Entry)
+			err = datastore.Get(c, entryKey, entry)
+			if err == datastore.ErrNoSuchEntity || (!entry.Starred && !entry.ReadAt.After(cutoff)) {
+				expired = append(expired, encodedEntryKey)
+				expiredKeys = append(expiredKeys, entryKey)
+			}
+		}
+		if len(expired) == 0 {
+			continue
+		}
+		
+		// 先にフィードのリストから外してから削除するので、削除済みのエントリへの参照は残らない
+		_, err = this.modifyFeed(c, key, func(current *Feed) bool {
+			var encodedEntryKey string
+			
+			for _, encodedEntryKey = range expired {
+				current.ReadEntries = removeItem(current.ReadEntries, encodedEntryKey)
+			}
+			return true
+		})
+		if err != nil {
+			continue
+		}
+		err = datastore.DeleteMulti(c, expiredKeys)
+		check(c, err)
+		count = count + len(expired)
+	}
+	
+	c.Infof("purge %d read entries", count)
+	return count
+}
+
+/**
+ * 受信元を持たない古いフィードをすべて受信元に登録する
+ * 定期更新は受信元だけを受信するので、登録しないと表示されるまで更新されない
+ * 古いフィードがなくなれば何もしないので、cronによって1日ごとに実行しておく
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @returns {int} 登録したフィードの数
+ */
+func (this *DAO) subscribeLegacyFeeds(c appengine.Context) int {
+	var iterator *datastore.Iterator
+	var key *datastore.Key
+	var feed *Feed
+	var count int
+	var err error
+	
+	iterator = datastore.NewQuery("feed").Run(c)
+	for {
+		feed = new(Feed)
+		key, err = iterator.Next(feed)
+		if err == datastore.Done {
+			break
+		}
+		err = ignoreMismatch(err)
+		check(c, err)
+		if err != nil {
+			break
+		}
+		if feed.Source != "" || feed.URL == "" {
+			continue
+		}
+		
+		this.subscribe(c, key.Encode(), feed)
+		count++
+	}
+	
+	c.Infof("subscribe %d legacy feeds", count)
+	return count
+}
+
+/**
+ * 指定されたエントリにスターを付ける、または外す
+ * 未読・既読どちらのエントリにも付けられる
+ * エントリと本文のスターの数の書き換えはトランザクションでまとめて行う
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {string} id エントリのID
+ * @param {string} feedKey エントリが登録されているフィードのキー
+ * @param {bool} starred スターを付けるならtrue、外すならfalse
+ */
+func (this *DAO) starEntry(c appengine.Context, id string, feedKey string, starred bool) {
+	var feed *Feed
+	var key *datastore.Key
+	var entryKey *datastore.Key
+	var entry *Entry
+	var err error
+	
+	key, err = datastore.DecodeKey(feedKey)
+	check(c, err)
+	if err != nil {
+		return
+	}
+	feed = this.getFeed(c, feedKey)
+	
+	// フィードの中から同じIDのエントリを探す
+	entryKey = this.findEntry(c, key, append(feed.Entries, feed.ReadEntries...), id)
+	if entryKey == nil {
+		return
+	}
+	
+	err = this.transaction(c, func(tc appengine.Context) error {
+		var err error
+		
+		entry = new(Entry)
+		err = ignoreMismatch(datastore.Get(tc, entryKey, entry))
+		if err != nil || entry.Starred == starred {
+			return err
+		}
+		
+		entry.Starred = starred
+		entry.Feed = feedKey
+		if starred {
+			entry.StarredAt = time.Now()
+			err = this.countStar(tc, entry.Item, 1)
+		} else {
+			entry.StarredAt = time.Time{}
+			err = this.countStar(tc, entry.Item, -1)
+		}
+		if err != nil {
+			return err
+		}
+		_, err = datastore.Put(tc, entryKey, entry)
+		return err
+	})
+	check(c, err)
+}
+
+/**
+ * 本文を参照しているスター付きエントリの数を増減する
+ * エントリの書き換えと同じトランザクションの中で呼ぶ
+ * @methodOf DAO
+ * @param {appengine.Context} tc トランザクションのコンテキスト
+ * @param {string} itemKey エンコード済みの本文のキー　空文字列なら何もしない
+ * @param {int} delta 増減する数
+ * @returns {error} エラー　本文が既に削除されていれば nil
+ */
+func (this *DAO) countStar(tc appengine.Context, itemKey string, delta int) error {
+	var key *datastore.Key
+	var item *Item
+	var err error
+	
+	if itemKey == "" {
+		return nil
+	}
+	key, err = datastore.DecodeKey(itemKey)
+	if err != nil {
+		return err
+	}
+	item = new(Item)
+	err = datastore.Get(tc, key, item)
+	if err == datastore.ErrNoSuchEntity {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	
+	item.Stars = item.Stars + delta
+	if item.Stars < 0 {
+		item.Stars = 0
+	}
+	_, err = datastore.Put(tc, key, item)
+	return err
+}
+
+/**
+ * ユーザのスター付きエントリをすべてのフィードからスターを付けた新しい順に返す
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {*user.User} u ユーザ
+ * @returns {[]*Entry} エントリ配列
+ */
+func (this *DAO) getStarredEntries(c appengine.Context, u *user.User) []*Entry {
+	var query *datastore.Query
+	var entries []*Entry
+	var err error
+	
+	entries = make([]*Entry, 0)
+	query = datastore.NewQuery("entry").Filter("Owner =", u.ID).Filter("Starred =", true).Order("-StarredAt")
+	_, err = query.GetAll(c, &entries)
+	check(c, err)
+	
+	return entries
+}
+
+/**
+ * ユーザのスター付きエントリの数を返す
+ * エントリを読み込まずにキーだけで数える
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {*user.User} u ユーザ
+ * @returns {int} スター付きエントリの数
+ */
+func (this *DAO) getStarredCount(c appengine.Context, u *user.User) int {
+	var query *datastore.Query
+	var count int
+	var err error
+	
+	query = datastore.NewQuery("entry").Filter("Owner =", u.ID).Filter("Starred =", true).KeysOnly()
+	count, err = query.Count(c)
+	check(c, err)
+	
+	return count
+}
+
+/**
+ * フィードをデータストアから読み出す
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {string} feedKey エンコード済みのフィードキー
+ * @retruns {*Feed} フィード
+ */
+func (this *DAO) getFeed(c appengine.Context, feedKey string) *Feed {
+	var feed *Feed
+	var err error
+	var key *datastore.Key
+
+	feed = new(Feed)
+	key, err = datastore.DecodeKey(feedKey)
+	check(c, err)
+	
+	err = datastore.Get(c, key, feed)
+	check(c, err)
+	
+	return feed
+}
+
+/**
+ * 受信元をデータストアから読み出す
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {string} sourceKey エンコード済みの受信元キー
+ * @returns {*Source} 受信元
+ */
+func (this *DAO) getSource(c appengine.Context, sourceKey string) *Source {
+	var source *Source
+	var err error
+	var key *datastore.Key
+	
+	source = new(Source)
+	key, err = datastore.DecodeKey(sourceKey)
+	check(c, err)
+	
+	err = datastore.Get(c, key, source)
+	check(c, err)
+	
+	return source
+}
+
+/**
+ * フィードの受信元を返す
+ * 受信元を持たない古いフィードはここで受信元に登録する
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {string} feedKey エンコード済みのフィードキー
+ * @returns {string} エンコード済みの受信元キー
+ * @returns {*Source} 受信元
+ */
+func (this *DAO) sourceOf(c appengine.Context, feedKey string) (string, *Source) {
+	var feed *Feed
+	var sourceKey string
+	
+	feed = this.getFeed(c, feedKey)
+	sourceKey = feed.Source
+	if sourceKey == "" {
+		sourceKey = this.subscribe(c, feedKey, feed)
+	}
+	return sourceKey, this.getSource(c, sourceKey)
+}
+
+/**
+ * URLの受信元のキーを返す
+ * 正規化したURLをキーとする受信元がなければ、移転してそのURLになった受信元を探す
+ * どちらもなければ新しく作るときのキーを返す
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {string} url フィードのURL
+ * @returns {*datastore.Key} 受信元のキー
+ */
+func (this *DAO) findSource(c appengine.Context, url string) *datastore.Key {
+	var key *datastore.Key
+	var keys []*datastore.Key
+	var err error
+	
+	key = datastore.NewKey(c, "source", normalizeURL(url), 0, nil)
+	err = datastore.Get(c, key, new(Source))
+	if err != datastore.ErrNoSuchEntity {
+		return key
+	}
+	
+	keys, err = datastore.NewQuery("source").Filter("NormalizedURL =", normalizeURL(url)).KeysOnly().Limit(1).GetAll(c, nil)
+	check(c, err)
+	if len(keys) > 0 {
+		return keys[0]
+	}
+	return key
+}
+
+/**
+ * フィードを同じURLの受信元の購読者に加える
+ * 受信元がなければ作る
+ * 受信元への追加とフィードへの受信元の設定はトランザクションでまとめて行う
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {string} feedKey エンコード済みのフィードキー
+ * @param {*Feed} feed フィード　Source を設定する
+ * @returns {string} エンコード済みの受信元キー
+ */
+func (this *DAO) subscribe(c appengine.Context, feedKey string, feed *Feed) string {
+	var sourceKey *datastore.Key
+	var key *datastore.Key
+	var created bool
+	var hub string
+	var err error
+	
+	sourceKey = this.findSource(c, feed.URL)
+	key, err = datastore.DecodeKey(feedKey)
+	check(c, err)
+	
+	err = this.transaction(c, func(tc appengine.Context) error {
+		var source *Source
+		var current *Feed
+		var err error
+		
+		source = new(Source)
+		created = false
+		err = datastore.Get(tc, sourceKey, source)
+		if err == datastore.ErrNoSuchEntity {
+			source.URL = feed.URL
+			source.NormalizedURL = normalizeURL(feed.URL)
+			source.Standard = feed.Standard
+			source.FinalEntry = feed.FinalEntry
+			source.Hub = feed.Hub
+			source.Topic = feed.URL
+			created = true
+		} else if err != nil {
+			return err
+		}
+		
+		if !contains(source.Subscribers, feedKey) {
+			source.Subscribers = append(source.Subscribers, feedKey)
+		}
+		_, err = datastore.Put(tc, sourceKey, source)
+		if err != nil {
+			return err
+		}
+		hub = source.Hub
+		
+		current = new(Feed)
+		err = datastore.Get(tc, key, current)
+		if err != nil {
+			return err
+		}
+		current.Source = sourceKey.Encode()
+		_, err = datastore.Put(tc, key, current)
+		return err
+	})
+	check(c, err)
+	feed.Source = sourceKey.Encode()
+	
+	// 新しい受信元がハブを宣言していればプッシュで受け取る
+	if err == nil && created && hub != "" {
+		this.subscribeHub(c, feed.Source)
+	}
+	
+	return feed.Source
+}
+
+/**
+ * フィードを受信元の購読者から外す
+ * 購読者がいなくなった受信元は本文とともに削除する
+ * 受信元の削除と同じトランザクションで本文のキーを集めておき、本文は後から少しずつ削除する
+ * その間に同じURLの受信元が作り直されても、新しい本文は削除しない
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {string} feedKey エンコード済みのフィードキー
+ * @param {*Feed} feed フィード
+ */
+func (this *DAO) unsubscribe(c appengine.Context, feedKey string, feed *Feed) {
+	var source *Source
+	var sourceKey *datastore.Key
+	var itemKeys []*datastore.Key
+	var removed bool
+	var websub *WebSub
+	var err error
+	
+	if feed.Source == "" {
+		return
+	}
+	sourceKey, err = datastore.DecodeKey(feed.Source)
+	check(c, err)
+	
+	err = this.transaction(c, func(tc appengine.Context) error {
+		var err error
+		
+		source = new(Source)
+		removed = false
+		err = datastore.Get(tc, sourceKey, source)
+		if err != nil {
+			return err
+		}
+		
+		source.Subscribers = removeItem(source.Subscribers, feedKey)
+		if len(source.Subscribers) > 0 {
+			_, err = datastore.Put(tc, sourceKey, source)
+			return err
+		}
+		
+		itemKeys, err = datastore.NewQuery("item").Ancestor(sourceKey).KeysOnly().GetAll(tc, nil)
+		if err != nil {
+			return err
+		}
+		removed = true
+		return datastore.Delete(tc, sourceKey)
+	})
+	check(c, err)
+	if err != nil || !removed {
+		return
+	}
+	err = deleteBatches(c, itemKeys)
+	check(c, err)
+	
+	// プッシュの購読を解除する
+	// ハブからの確認には受信元が削除済みであることで答える
+	if source.Push {
+		websub = new(WebSub)
+		err = websub.request(c, "unsubscribe", feed.Source, source)
+		check(c, err)
+	}
+}
+
+/**
+ * 指定されたキーのデータが既に存在するか調べる
+ * フィードやエントリなど重複させたくないデータはこの関数を使ってチェックする
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {*Feed} feed フィード
+ * @returns {bool} 重複していたらtrue
+ */
+func (this *DAO) exist(c appengine.Context, feed *Feed) bool {
+	var result bool
+	var err error
+	var query *datastore.Query
+	var u *user.User
+	var count int
+	var sources []*Source
+	var source *Source
+	var subscriber string
+	
+	u = user.Current(c)
+	query = datastore.NewQuery("feed").Filter("URL =", feed.URL).Filter("Owner =", u.ID)
+	count, err = query.Count(c)
+	check(c, err)
+	
+	// 移転が確定していない受信元の移転先として購読していないか
+	if count == 0 {
+		query = datastore.NewQuery("source").Filter("RedirectURL =", feed.URL)
+		_, err = query.GetAll(c, &sources)
+		check(c, err)
+		for _, source = range sources {
+			for _, subscriber = range source.Subscribers {
+				if this.getFeed(c, subscriber).Owner == u.ID {
+					count++
+				}
+			}
+		}
+	}
+	
+	if count == 0 {
+		result = false
+	} else {
+		result = true
+	}
+	
+	return result
+}
+
+/**
+ * すべてのデータを削除する
+ * デバッグ用
+ * @methodOf DAO
+ * @param {appengine.Context} c
+ */
+func (this *DAO) clear(c appengine.Context) {
+	var keys []*datastore.Key
+	var query *datastore.Query
+	var err error
+	var kinds [7]string
+	var kind string
+	
+	keys = make([]*datastore.Key, 0)
+	kinds = [7]string{"folder", "feed", "entry", "source", "item", "updatelog", "integritylog"}
+	
+	for _, kind = range kinds {
+		query = datastore.NewQuery(kind).KeysOnly()
+		keys, err = query.GetAll(c, nil)
+		check(c, err)
+		datastore.DeleteMulti(c, keys)
+	}
+}
+
+/**
+ * フィードの更新
+ * フィードの受信元を更新し、このフィードに配られたエントリを返す
+ * 同じ受信元を購読している他のユーザのフィードにも同時に配られる
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {string} encodedFeedKey フィードのキー
+ * @param {time.Time} deadline 更新の制限時刻
+ * @returns {*UpdateResult} 更新結果
+ */
+func (this *DAO) updateFeed(c appengine.Context, encodedFeedKey string, deadline time.Time) *UpdateResult {
+	var sourceKey string
+	var sourceResult *UpdateResult
+	var delivered map[string][]*Entry
+	var result *UpdateResult
+	var feed *Feed
+	
+	sourceKey, _ = this.sourceOf(c, encodedFeedKey)
+	sourceResult, delivered = this.updateSource(c, sourceKey, deadline)
+	
+	// 受信元の結果をこのフィードの結果にする
+	result = new(UpdateResult)
+	*result = *sourceResult
+	result.Key = encodedFeedKey
+	result.Entries = delivered[encodedFeedKey]
+	if result.Entries == nil {
+		result.Entries = make([]*Entry, 0)
+	}
+	result.New = len(result.Entries)
+	if result.Status == "updated" && result.New == 0 {
+		result.Status = "no_new_entries"
+	}
+	
+	feed = this.getFeed(c, encodedFeedKey)
+	result.Title = feed.Title
+	result.Count = len(feed.Entries)
+	
+	return result
+}
+
+/**
+ * 受信元の更新
+ * フィードを１回だけ受信して、新しいエントリを購読しているフィードすべてに配る
+ * 前回の ETag, Last-Modified を送信して変更がなければ解析しない
+ * 制限時刻を過ぎたら呼び出し元はもう結果を待っていないので、受信元にもフィードにも書き込まずに戻る
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {string} encodedSourceKey 受信元のキー
+ * @param {time.Time} deadline 更新の制限時刻
+ * @returns {*UpdateResult} 受信元の更新結果　New は受信元に届いた新着エントリの件数
+ * @returns {map[string][]*Entry} フィードのキーと配ったエントリの対応
+ */
+func (this *DAO) updateSource(c appengine.Context, encodedSourceKey string, deadline time.Time) (*UpdateResult, map[string][]*Entry) {
+	var source *Source
+	var sourceKey *datastore.Key
+	var parsed *Feed
+	var currentEntries []*Entry
+	var fetched *FetchResult
+	var scheduler *Scheduler
+	var statusErr *HTTPStatusError
+	var statusCode int
+	var status string
+	var message string
+	var subscribe bool
+	var topic string
+	var moved bool
+	var feedKey string
+	var key *datastore.Key
+	var target *datastore.Key
+	var saved *Source
+	var delivered map[string][]*Entry
+	var entries []*Entry
+	var result *UpdateResult
+	var started time.Time
+	var now time.Time
+	var timeout time.Duration
+	var err error
+	
+	started = time.Now()
+	scheduler = new(Scheduler)
+	result = new(UpdateResult)
+	result.Key = encodedSourceKey
+	
+	// 受信元の取得
+	sourceKey, err = datastore.DecodeKey(encodedSourceKey)
+	if err == nil {
+		source = new(Source)
+		err = datastore.Get(c, sourceKey, source)
+	}
+	check(c, err)
+	if err != nil {
+		result.Status = "failed"
+		result.Error = err.Error()
+		result.Duration = milliseconds(time.Since(started))
+		return result, nil
+	}
+	result.URL = source.URL
+	
+	// URLからエントリをフェッチする　制限時刻を過ぎてまでは待たない
+	timeout = deadline.Sub(time.Now())
+	if timeout > fetchTimeout {
+		timeout = fetchTimeout
+	}
+	if timeout <= 0 {
+		result.Status = "timeout"
+		result.Duration = milliseconds(time.Since(started))
+		return result, nil
+	}
+	fetched, err = fetchRequest(c, source.URL, source.ETag, source.LastModified, timeout)
+	check(c, err)
+	if fetched != nil {
+		statusCode = fetched.StatusCode
+	}
+	statusErr, _ = err.(*HTTPStatusError)
+	if statusErr != nil {
+		statusCode = statusErr.StatusCode
+	}
+	switch {
+		case isGone(err):
+			status = "gone"
+			message = err.Error()
+			source.Dead = true
+		case err != nil:
+			status = "failed"
+			message = err.Error()
+		case fetched.StatusCode == http.StatusNotModified:
+			status = "not_modified"
+			source.Dead = false
+			moved = this.trackRedirect(c, source, fetched.PermanentURL)
+		default:
+			parsed, currentEntries, err = parseFeed(c, fetched.Body, source.Standard)
+			if err != nil {
+				// 壊れたフィードを「新着なし」と区別して失敗として数える
+				status = "parse_error"
+				message = join("parse_error: ", err.Error())
+				c.Errorf("%s: %s", source.URL, message)
+				break
+			}
+			if currentEntries == nil {
+				status = "failed"
+				message = "unsupported feed format"
+				c.Errorf("%s: %s", source.URL, message)
+				break
+			}
+			
+			// 次回の条件付きリクエストのために保存する
+			source.ETag = fetched.Header.Get("ETag")
+			source.LastModified = fetched.Header.Get("Last-Modified")
+			source.Dead = false
+			moved = this.trackRedirect(c, source, fetched.PermanentURL)
+			
+			// 受信間隔の決定に使う情報
+			source.TTL = parsed.TTL
+			source.SkipHours = parsed.SkipHours
+			source.UpdateInterval = parsed.UpdateInterval
+			source.PostInterval = scheduler.postInterval(currentEntries)
+			
+			// WebSub の購読にはフィード自身が示すURLを使う
+			topic = parsed.URL
+			if topic == "" {
+				topic = source.URL
+			}
+			if parsed.Hub != "" && (parsed.Hub != source.Hub || topic != source.Topic) {
+				source.Hub = parsed.Hub
+				source.Topic = topic
+				subscribe = true
+			}
+	}
+	now = time.Now()
+	
+	// 制限時刻を過ぎていたら何も書き込まない　NextFetch が変わらないので次回の実行で受信し直す
+	if now.After(deadline) {
+		c.Warningf("%s: deadline exceeded, discard the fetch", source.URL)
+		result.Status = "timeout"
+		result.Duration = milliseconds(time.Since(started))
+		return result, nil
+	}
+	source.NormalizedURL = normalizeURL(source.URL)
+	this.recordFetch(source, statusCode, message, now)
+	if message == "" {
+		source.NextFetch = scheduler.next(source, now)
+	}
+	
+	// 受信している間に変わっているかもしれない購読者、配信済みエントリ、プッシュの状態は
+	// 保存されている値を使い、受信に関する項目だけを書き換える
+	saved, err = this.modifySource(c, sourceKey, func(current *Source) bool {
+		var stored Source
+		
+		stored = *current
+		*current = *source
+		current.Subscribers = stored.Subscribers
+		current.FinalEntry = stored.FinalEntry
+		current.Seen = stored.Seen
+		current.HubSecret = stored.HubSecret
+		current.Push = stored.Push
+		current.LeaseExpires = stored.LeaseExpires
+		return true
+	})
+	if err == nil {
+		source = saved
+	}
+	
+	// 移転が確定したら購読しているフィードのURLも書き換える
+	// 移転先のURLに既に受信元があれば、購読者をそちらへ移して１つにまとめる
+	if moved {
+		for _, feedKey = range source.Subscribers {
+			key, err = datastore.DecodeKey(feedKey)
+			check(c, err)
+			this.modifyFeed(c, key, func(feed *Feed) bool {
+				feed.URL = source.URL
+				return true
+			})
+		}
+		target = this.findSource(c, source.URL)
+		if !target.Equal(sourceKey) && this.mergeSource(c, sourceKey, target) {
+			sourceKey = target
+			encodedSourceKey = target.Encode()
+			subscribe = false
+		}
+	}
+	
+	// 新しくハブを宣言したフィードはプッシュで受け取る
+	if subscribe {
+		this.subscribeHub(c, encodedSourceKey)
+	}
+	
+	delivered = this.deliver(c, sourceKey, currentEntries)
+	
+	// 更新結果の記録
+	for _, entries = range delivered {
+		if len(entries) > result.New {
+			result.New = len(entries)
+		}
+	}
+	if status == "" {
+		status = "no_new_entries"
+		if result.New > 0 {
+			status = "updated"
+		}
+	}
+	result.Status = status
+	result.StatusCode = statusCode
+	result.Error = message
+	if fetched != nil {
+		result.RedirectURL = fetched.PermanentURL
+	}
+	result.Duration = milliseconds(time.Since(started))
+	
+	return result, delivered
+}
+
+/**
+ * 受信元の購読者をすべて別の受信元へ移し、移し終えたら元の受信元を削除する
+ * 移転によって同じフィードの受信元が２つになったときに１つにまとめる
+ * 配ったエントリが本文を参照しているので、本文は元の受信元の子のまま残し、受信元のエンティティだけを削除する
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {*datastore.Key} from 元の受信元のキー
+ * @param {*datastore.Key} to 移す先の受信元のキー
+ * @returns {bool} 移す先の受信元が存在して購読者を移したらtrue
+ */
+func (this *DAO) mergeSource(c appengine.Context, from *datastore.Key, to *datastore.Key) bool {
+	var source *Source
+	var feedKey string
+	var err error
+	
+	err = datastore.Get(c, to, new(Source))
+	if err != nil {
+		if err != datastore.ErrNoSuchEntity {
+			check(c, err)
+		}
+		return false
+	}
+	source = new(Source)
+	err = datastore.Get(c, from, source)
+	check(c, err)
+	if err != nil {
+		return false
+	}
+	
+	// 購読者ごとに、両方の受信元とフィードをまとめて書き換える
+	for _, feedKey = range source.Subscribers {
+		err = this.transaction(c, func(tc appengine.Context) error {
+			var current *Source
+			var target *Source
+			var feed *Feed
+			var key *datastore.Key
+			var err error
+			
+			current = new(Source)
+			target = new(Source)
+			err = datastore.Get(tc, from, current)
+			if err == nil {
+				err = datastore.Get(tc, to, target)
+			}
+			if err != nil {
+				return err
+			}
+			current.Subscribers = removeItem(current.Subscribers, feedKey)
+			
+			// 削除済みのフィードは購読者から外すだけにする
+			key, err = datastore.DecodeKey(feedKey)
+			if err == nil {
+				feed = new(Feed)
+				err = datastore.Get(tc, key, feed)
+			}
+			if err == nil {
+				if !contains(target.Subscribers, feedKey) {
+					target.Subscribers = append(target.Subscribers, feedKey)
+				}
+				feed.Source = to.Encode()
+				feed.URL = target.URL
+				_, err = datastore.Put(tc, key, feed)
+			} else if err == datastore.ErrNoSuchEntity {
+				err = nil
+			}
+			if err != nil {
+				return err
+			}
+			_, err = datastore.PutMulti(tc, []*datastore.Key{from, to}, []*Source{current, target})
+			return err
+		})
+		check(c, err)
+	}
+	
+	// 購読者を移し終えていれば元の受信元を削除する
+	err = this.transaction(c, func(tc appengine.Context) error {
+		var current *Source
+		var err error
+		
+		current = new(Source)
+		err = datastore.Get(tc, from, current)
+		if err != nil || len(current.Subscribers) > 0 {
+			return err
+		}
+		return datastore.Delete(tc, from)
+	})
+	check(c, err)
+	
+	c.Infof("source merged: %s -> %s", from.StringID(), to.StringID())
+	return true
+}
+
+/**
+ * 受信元の新しいエントリを購読しているフィードすべてに配る
+ * 本文は受信元に１つだけ保存し、各フィードには本文を除いたエントリを登録する
+ * 配信済みの記録は全員に配り終えてから更新するので、途中で失敗しても次の受信でもう一度配る
+ * エントリのキーはIDから決まるので、既に配ったフィードに重複して登録されることはない
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {*datastore.Key} sourceKey 受信元のキー
+ * @param {[]*Entry} entries 受信したエントリ一覧(新しい順)
+ * @returns {map[string][]*Entry} フィードのキーと配ったエントリの対応
+ */
+func (this *DAO) deliver(c appengine.Context, sourceKey *datastore.Key, entries []*Entry) map[string][]*Entry {
+	var source *Source
+	var pending Source
+	var newEntries []*Entry
+	var copies []*Entry
+	var registered []*Entry
+	var entry *Entry
+	var copied Entry
+	var feedKey string
+	var result map[string][]*Entry
+	var failed bool
+	var now time.Time
+	var err error
+	
+	result = make(map[string][]*Entry)
+	if len(entries) == 0 {
+		return result
+	}
+	
+	// 新着かどうかは記録の複製で判断し、記録そのものはまだ書き換えない
+	now = time.Now()
+	source = new(Source)
+	err = datastore.Get(c, sourceKey, source)
+	check(c, err)
+	if err != nil {
+		return result
+	}
+	pending = *source
+	newEntries = this.newEntries(&pending, entries, now)
+	
+	if len(newEntries) > 0 {
+		err = this.storeItems(c, sourceKey, newEntries)
+		if err != nil {
+			return result
+		}
+		
+		// registerEntries はエントリを書き換えるのでフィードごとに複製して渡す
+		// 削除されたフィードには配らなくてよいので失敗として扱わない
+		for _, feedKey = range source.Subscribers {
+			copies = make([]*Entry, 0, len(newEntries))
+			for _, entry = range newEntries {
+				copied = *entry
+				copies = append(copies, &copied)
+			}
+			registered, err = this.registerEntries(c, copies, feedKey)
+			if err != nil && err != datastore.ErrNoSuchEntity {
+				failed = true
+			}
+			result[feedKey] = registered
+		}
+	}
+	
+	// 配り損ねたフィードがあれば記録を更新せず、次の受信でもう一度配る
+	if failed {
+		c.Warningf("%s: delivery incomplete, keep %d entries unseen", source.URL, len(newEntries))
+		return result
+	}
+	
+	// 見かけたエントリの記録は新着がなくても更新する
+	this.modifySource(c, sourceKey, func(current *Source) bool {
+		this.newEntries(current, entries, now)
+		return true
+	})
+	
+	return result
+}
+
+/**
+ * エントリの本文を受信元の子として保存する
+ * 保存期間を過ぎた古い本文はここで削除する　ただしスター付きエントリの本文は残す
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {*datastore.Key} sourceKey 受信元のキー
+ * @param {[]*Entry} entries 本文を保存するエントリ　Item に本文への参照キーを設定する
+ * @returns {error} 本文を保存できなかったときのエラー
+ */
+func (this *DAO) storeItems(c appengine.Context, sourceKey *datastore.Key, entries []*Entry) error {
+	var keys []*datastore.Key
+	var newKeys []*datastore.Key
+	var expired []*datastore.Key
+	var items []*Item
+	var newItems []*Item
+	var entry *Entry
+	var query *datastore.Query
+	var now time.Time
+	var getErr error
+	var start int
+	var end int
+	var i int
+	var err error
+	
+	// キー名をエントリのIDから決めるので、配り直しても本文は重複しない
+	now = time.Now()
+	keys = make([]*datastore.Key, len(entries))
+	for i, entry = range entries {
+		keys[i] = datastore.NewKey(c, "item", hashID(entry.ID), 0, sourceKey)
+		entry.Item = keys[i].Encode()
+	}
+	
+	// 前回の配信で保存済みの本文はスターの数を残すため上書きしない
+	// 一度に読み書きできる数には限りがあるので maxBatchSize 件ずつ保存する
+	for start = 0; start < len(entries); start = end {
+		end = start + maxBatchSize
+		if end > len(entries) {
+			end = len(entries)
+		}
+		items = make([]*Item, end - start)
+		getErr = datastore.GetMulti(c, keys[start:end], items)
+		newKeys = make([]*datastore.Key, 0, end - start)
+		newItems = make([]*Item, 0, end - start)
+		for i = start; i < end; i++ {
+			err = errorAt(getErr, i - start)
+			if err == nil {
+				continue
+			}
+			if err != datastore.ErrNoSuchEntity {
+				check(c, err)
+				return err
+			}
+			newKeys = append(newKeys, keys[i])
+			newItems = append(newItems, &Item{entries[i].Content, entries[i].ContentType, now, 0})
+		}
+		if len(newKeys) > 0 {
+			_, err = datastore.PutMulti(c, newKeys, newItems)
+			check(c, err)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	
+	// スター付きエントリの本文は保存期間を過ぎても残す
+	query = datastore.NewQuery("item").Ancestor(sourceKey).Filter("Created <", now.Add(-itemRetention))
+	items = make([]*Item, 0)
+	keys, err = query.GetAll(c, &items)
+	check(c, err)
+	expired = make([]*datastore.Key, 0, len(keys))
+	for i = range keys {
+		if items[i].Stars <= 0 {
+			expired = append(expired, keys[i])
+		}
+	}
+	if len(expired) > 0 {
+		err = deleteBatches(c, expired)
+		check(c, err)
+	}
+	return nil
+}
+
+/**
+ * エントリ一覧から未配信のエントリを取り出す
+ * フィードの中の位置に関係なく、配ったことのないIDのエントリだけを新しいエントリとする
+ * 受信元の配信済みエントリの記録もここで更新し、
+ * フィードから消えて seenRetention を過ぎたものと maxSeenEntries を超えた古いものは忘れる
+ * @methodOf DAO
+ * @param {*Source} source 受信元　Seen を書き換える
+ * @param {[]*Entry} entries 受信したエントリ一覧
+ * @param {time.Time} now 受信した日時
+ * @returns {[]*Entry} 新しいエントリ
+ */
+func (this *DAO) newEntries(source *Source, entries []*Entry, now time.Time) []*Entry {
+	var result []*Entry
+	var known map[string]bool
+	var present map[string]bool
+	var seen []SeenEntry
+	var old SeenEntry
+	var entry *Entry
+	var id string
+	var limit int
+	var i int
+	
+	known = make(map[string]bool)
+	for _, old = range source.Seen {
+		known[old.ID] = true
+	}
+	
+	// 旧形式の受信元は最後に配ったエントリとそれより後ろを配信済みとみなす
+	// 以前は FinalEntry にURLを保存していたのでURLとも比較する
+	if len(source.Seen) == 0 && source.FinalEntry != "" {
+		for i = 0; i < len(entries); i++ {
+			if entries[i].ID == source.FinalEntry || entries[i].Link == source.FinalEntry {
+				break
+			}
+		}
+		for _, entry = range entries[i:] {
+			known[seenID(entry)] = true
+		}
+	}
+	
+	// 今回フィードにあったエントリを記録の先頭に置く
+	result = make([]*Entry, 0)
+	present = make(map[string]bool)
+	seen = make([]SeenEntry, 0, len(entries) + len(source.Seen))
+	for _, entry = range entries {
+		id = seenID(entry)
+		if present[id] {
+			continue
+		}
+		present[id] = true
+		seen = append(seen, SeenEntry{id, now})
+		if !known[id] {
+			result = append(result, entry)
+		}
+	}
+	
+	// フィードから消えたエントリは保存期間が過ぎるまで覚えておく
+	for _, old = range source.Seen {
+		if present[old.ID] || now.Sub(old.Time) > seenRetention {
+			continue
+		}
+		seen = append(seen, old)
+	}
+	
+	// 上限を超えたら古いものから忘れる　今回フィードにあったものは残す
+	limit = maxSeenEntries
+	if len(present) > limit {
+		limit = len(present)
+	}
+	if len(seen) > limit {
+		seen = seen[:limit]
+	}
+	
+	source.Seen = seen
+	source.FinalEntry = ""
+	return result
+}
+
+/**
+ * 配信済みの記録に使うエントリの識別子を作る
+ * IDは長いURLのこともあるので、記録の大きさを揃えるためにハッシュ値にする
+ * @function
+ * @param {*Entry} entry エントリ
+ * @returns {string} エントリのIDのハッシュ値
+ */
+func seenID(entry *Entry) string {
+	return hashID(entry.ID)
+}
+
+/**
+ * エントリのIDのハッシュ値を返す
+ * エントリと本文のキー名、配信済みの記録に使う
+ * @function
+ * @param {string} id エントリのID
+ * @returns {string} ハッシュ値
+ */
+func hashID(id string) string {
+	var sum [sha1.Size]byte
+	
+	sum = sha1.Sum([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+/**
+ * WebSub のハブに購読を申し込む
+ * 申し込む前に秘密鍵を保存しておき、ハブからの確認に答えられるようにする
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {string} encodedSourceKey エンコード済みの受信元キー
+ */
+func (this *DAO) subscribeHub(c appengine.Context, encodedSourceKey string) {
+	var source *Source
+	var sourceKey *datastore.Key
+	var websub *WebSub
+	var err error
+	
+	sourceKey, err = datastore.DecodeKey(encodedSourceKey)
+	check(c, err)
+	
+	websub = new(WebSub)
+	source, err = this.modifySource(c, sourceKey, func(current *Source) bool {
+		if current.Hub == "" {
+			return false
+		}
+		if current.HubSecret == "" {
+			current.HubSecret = websub.newSecret()
+		}
+		if current.Topic == "" {
+			current.Topic = current.URL
+		}
+		current.Push = true
+		return true
+	})
+	if err != nil || source.Hub == "" {
+		return
+	}
+	
+	err = websub.request(c, "subscribe", encodedSourceKey, source)
+	check(c, err)
+}
+
+/**
+ * ハブからの購読確認に答えてよいか調べる
+ * 購読が確認できたら購読期限を保存する
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {string} encodedSourceKey エンコード済みの受信元キー
+ * @param {string} mode "subscribe" または "unsubscribe"
+ * @param {string} topic ハブが確認してきたトピック
+ * @param {int} lease 購読期間(秒)
+ * @returns {bool} 申し込んだ内容と一致すればtrue
+ */
+func (this *DAO) verifySubscription(c appengine.Context, encodedSourceKey string, mode string, topic string, lease int) bool {
+	var source *Source
+	var sourceKey *datastore.Key
+	var err error
+	
+	source = new(Source)
+	sourceKey, err = datastore.DecodeKey(encodedSourceKey)
+	if err == nil {
+		err = datastore.Get(c, sourceKey, source)
+	}
+	
+	switch mode {
+		case "subscribe":
+			if err != nil || !source.Push || source.Topic != topic {
+				return false
+			}
+			if lease <= 0 {
+				lease = leaseSeconds
+			}
+			this.modifySource(c, sourceKey, func(current *Source) bool {
+				current.LeaseExpires = time.Now().Add(time.Duration(lease) * time.Second)
+				return true
+			})
+			return true
+		case "unsubscribe":
+			// 削除済みの受信元か購読をやめた受信元なら解除してよい
+			return err != nil || !source.Push
+	}
+	return false
+}
+
+/**
+ * ハブからプッシュされたフィードのエントリを購読しているフィードに配る
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {string} encodedSourceKey エンコード済みの受信元キー
+ * @param {[]byte} data プッシュされたフィード(UTF-8に変換済み)
+ * @returns {map[string][]*Entry} フィードのキーと配ったエントリの対応
+ */
+func (this *DAO) receivePush(c appengine.Context, encodedSourceKey string, data []byte) map[string][]*Entry {
+	var source *Source
+	var sourceKey *datastore.Key
+	var entries []*Entry
+	var err error
+	
+	sourceKey, err = datastore.DecodeKey(encodedSourceKey)
+	check(c, err)
+	source = this.getSource(c, encodedSourceKey)
+	_, entries, err = parseFeed(c, data, source.Standard)
+	if err != nil {
+		c.Errorf("%s: broken push payload: %s", source.URL, err.Error())
+		return nil
+	}
+	if entries == nil {
+		c.Errorf("%s: unsupported push payload", source.URL)
+		return nil
+	}
+	return this.deliver(c, sourceKey, entries)
+}
+
+/**
+ * 購読期限が近づいたハブへ購読を申し込み直す
+ * 申し込みが確認されていない受信元もここで申し込み直す
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @returns {int} 申し込んだ受信元の数
+ */
+func (this *DAO) renewSubscriptions(c appengine.Context) int {
+	var query *datastore.Query
+	var keys []*datastore.Key
+	var key *datastore.Key
+	var err error
+	
+	query = datastore.NewQuery("source").Filter("Push =", true).Filter("LeaseExpires <=", time.Now().Add(leaseRenewal)).KeysOnly()
+	keys, err = query.GetAll(c, nil)
+	check(c, err)
+	
+	for _, key = range keys {
+		this.subscribeHub(c, key.Encode())
+	}
+	return len(keys)
+}
+
+/**
+ * 受信元の受信結果を記録する
+ * 失敗が続いたら次に受信するまでの間隔を倍々に延ばす
+ * @methodOf DAO
+ * @param {*Source} source 受信元　呼び出し元で保存する
+ * @param {int} statusCode HTTPのステータスコード　応答がなかった場合は0
+ * @param {string} message エラーメッセージ　成功した場合は空文字列
+ * @param {time.Time} now 受信を試みた日時
+ */
+func (this *DAO) recordFetch(source *Source, statusCode int, message string, now time.Time) {
+	var backoff time.Duration
+	var i int
+	
+	source.LastFetch = now
+	if message == "" {
+		source.LastSuccess = now
+		source.FailureCount = 0
+		source.NextFetch = time.Time{}
+	} else {
+		source.LastError = message
+		source.FailureCount++
+		backoff = fetchBackoff
+		for i = 1; i < source.FailureCount && backoff < maxFetchBackoff; i++ {
+			backoff *= 2
+		}
+		if backoff > maxFetchBackoff {
+			backoff = maxFetchBackoff
+		}
+		source.NextFetch = now.Add(backoff)
+	}
+	
+	source.History = append(source.History, FetchLog{now, statusCode, message})
+	if len(source.History) > fetchHistorySize {
+		source.History = source.History[len(source.History) - fetchHistorySize:]
+	}
+}
+
+/**
+ * 受信元が受信に失敗し続けているか調べる
+ * @methodOf DAO
+ * @param {*Source} source 受信元
+ * @returns {bool} 連続して失敗していればtrue
+ */
+func (this *DAO) isBroken(source *Source) bool {
+	return source.FailureCount > 0
+}
+
+/**
+ * 受信元の移転先を記録する
+ * 同じ移転先へ redirectThreshold 回続けて恒久的にリダイレクトされたら
+ * 受信元のURLを移転先に書き換える
+ * 一時的な移転で登録URLを失わないように、１回のリダイレクトでは書き換えない
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {*Source} source 受信元　呼び出し元で保存する
+ * @param {string} permanentURL 今回の受信での恒久的な移転先　リダイレクトされなければ空文字列
+ * @returns {bool} URLを書き換えたらtrue
+ */
+func (this *DAO) trackRedirect(c appengine.Context, source *Source, permanentURL string) bool {
+	if permanentURL == "" || permanentURL == source.URL {
+		source.RedirectURL = ""
+		source.RedirectCount = 0
+		return false
+	}
+	
+	if permanentURL != source.RedirectURL {
+		source.RedirectURL = permanentURL
+		source.RedirectCount = 0
+	}
+	source.RedirectCount++
+	
+	if source.RedirectCount < redirectThreshold {
+		return false
+	}
+	c.Infof("feed moved: %s -> %s", source.URL, source.RedirectURL)
+	source.URL = source.RedirectURL
+	source.RedirectURL = ""
+	source.RedirectCount = 0
+	return true
+}
+
+/**
+ * フォルダの更新
+ * サブフォルダの中も含めたすべてのフィードをワーカープールで更新し、
+ * フォルダ直下の子ごとに結果をまとめる
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {string} FolderKey フォルダのキー
+ * @returns {*UpdateReport} 更新したすべてのフィードの結果と、フォルダ直下の各フォルダ、フィードの更新結果
+ */
+func (this *DAO) updateFolder(c appengine.Context, folderKey string) *UpdateReport {
+	var folder *Folder
+	var feed *Feed
+	var pool *UpdatePool
+	var childKey string
+	var childType string
+	var childTypes map[string]string
+	var descendants map[string][]string
+	var feedKeys []string
+	var feedKey string
+	var results map[string]*UpdateResult
+	var feedResults []*UpdateResult
+	var feedResult *UpdateResult
+	var report *UpdateReport
+	var childResult *UpdateResult
+	var statuses []string
+	var started time.Time
+	var i int
+	
+	started = time.Now()
+	folder = this.getFolder(c, folderKey)
+	
+	// サブフォルダの中のフィードも１つのプールでまとめて更新する
+	childTypes = make(map[string]string)
+	descendants = make(map[string][]string)
+	feedKeys = make([]string, 0)
+	for _, childKey = range folder.Children {
+		childType, _ = this.getItem(c, childKey)
+		childTypes[childKey] = childType
+		if childType == "folder" {
+			descendants[childKey] = this.getDescendantFeeds(c, childKey)
+		} else if childType == "feed" {
+			descendants[childKey] = []string{childKey}
+		}
+		feedKeys = append(feedKeys, descendants[childKey]...)
+	}
+	
+	pool = &UpdatePool{updateWorkers, feedDeadline, poolDeadline}
+	// 未読数は更新し終えてからフォルダごとにまとめて書き込む
+	this.batchUnread()
+	results = pool.run(c, feedKeys, func(feedKey string, deadline time.Time) *UpdateResult {
+		var source *Source
+		
+		// 失敗が続いている受信元は間隔を空けるまで受信しない
+		_, source = this.sourceOf(c, feedKey)
+		if this.isBroken(source) && time.Now().Before(source.NextFetch) {
+			return &UpdateResult{URL: source.URL, Status: "backoff", Error: source.LastError}
+		}
+		return this.updateFeed(c, feedKey, deadline)
+	})
+	this.flushUnread(c)
+	
+	// フィードごとの結果には打ち切られたものも含めて現在の件数を入れる
+	feedResults = make([]*UpdateResult, len(feedKeys))
+	for i, feedKey = range feedKeys {
+		feedResult = results[feedKey]
+		feed = this.getFeed(c, feedKey)
+		feedResult.Key = feedKey
+		feedResult.Title = feed.Title
+		if feedResult.URL == "" {
+			feedResult.URL = feed.URL
+		}
+		feedResult.Count = len(feed.Entries)
+		feedResults[i] = feedResult
+	}
+	report = makeReport(started, feedResults)
+	
+	// フォルダ直下の子ごとに結果をまとめる
+	report.Children = make(map[string]*UpdateResult)
+	for _, childKey = range folder.Children {
+		childResult = new(UpdateResult)
+		switch childTypes[childKey] {
+			case "feed":
+				childResult.Status = results[childKey].Status
+				childResult.Count = results[childKey].Count
+				childResult.New = results[childKey].New
+			case "folder":
+				statuses = make([]string, 0)
+				for _, feedKey = range descendants[childKey] {
+					statuses = append(statuses, results[feedKey].Status)
+					childResult.New = childResult.New + results[feedKey].New
+				}
+				childResult.Status = mergeStatus(statuses)
+				childResult.Count = this.getEntriesCount(c, childKey)
+			default:
+				childResult.Status = "failed"
+		}
+		report.Children[childKey] = childResult
+	}
+	
+	return report
+}
+
+/**
+ * フォルダ以下にあるすべてのフィードを取得する
+ * 壊れたデータで循環していたり、同じものが２か所にあったりしても１回ずつしか返さない
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {string} folderKey フォルダのキー
+ * @returns {[]string} サブフォルダの中も含めたフィードのキー
+ */
+func (this *DAO) getDescendantFeeds(c appengine.Context, folderKey string) []string {
+	var feedKeys []string
+	var visited map[string]bool
+	var walk func(key string)
+	
+	feedKeys = make([]string, 0)
+	visited = make(map[string]bool)
+	walk = func(key string) {
+		var folder *Folder
+		var childKey string
+		var childType string
+		
+		visited[key] = true
+		folder = this.getFolder(c, key)
+		for _, childKey = range folder.Children {
+			if visited[childKey] {
+				continue
+			}
+			childType, _ = this.getItem(c, childKey)
+			if childType == "folder" {
+				walk(childKey)
+			} else if childType == "feed" {
+				visited[childKey] = true
+				feedKeys = append(feedKeys, childKey)
+			}
+		}
+	}
+	walk(folderKey)
+	
+	return feedKeys
+}
+
+/**
+ * XMLファイルを解析してフォルダ・フィードツリーを返す
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {[]byte} xmldata XMLデータ
+ * @returns {[]interface{}} フォルダ・フィードツリー
+ */
+func (this *DAO) getTreeFromXML(c appengine.Context, xmldata []byte) []*Node {
+	type OUTLINE struct {
+		Outline []OUTLINE `xml:"outline"`
+		Title string `xml:"title,attr"`
+		XMLURL string `xml:"xmlUrl,attr"`
+		HTMLURL string `xml:"htmlUrl,attr"`
+	}
+	type OPML struct {
+		Outline []OUTLINE `xml:"body>outline"`
+	}
+	var opml *OPML
+	var err error
+	var depth1 OUTLINE
+	var depth2 OUTLINE
+	var i int
+	var j int
+	var tree []*Node
+	var folder *Node
+	var feed *Node
+	
+	opml = new(OPML)
+	err = xml.Unmarshal(toUTF8(xmldata, ""), opml)
+	check(c, err)
+	
+	tree = make([]*Node, len(opml.Outline))
+	for i, depth1 = range opml.Outline {
+		if depth1.XMLURL == "" {
+			folder = new(Node)
+			folder.kind = "folder"
+			folder.title = depth1.Title
+			folder.children = make([]*Node, len(depth1.Outline))
+			for j, depth2 = range depth1.Outline {
+				feed = new(Node)
+				feed.kind = "feed"
+				feed.title = depth2.Title
+				feed.xmlURL = depth2.XMLURL
+				feed.htmlURL = depth2.HTMLURL
+				folder.children[j] = feed
+			}
+			tree[i] = folder
+		} else {
+			feed = new(Node)
+			feed.kind = "feed"
+			feed.title = depth1.Title
+			feed.xmlURL = depth1.XMLURL
+			feed.htmlURL = depth1.HTMLURL
+			tree[i] = feed
+		}
+	}
+
+	return tree
+}
+
+/**
+ * XMLファイルをデータストアにインポートする
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {[]byte} xmldate XMLファイル
+ * @param {string} folderKey 追加先のフォルダのキー
+ */
+func (this *DAO) importXML(c appengine.Context, tree []*Node, folderKey string) {
+	var feed *Feed
+	var u *user.User
+	var parentKey string
+	var entries []*Entry
+	var depth1 *Node
+	var depth2 *Node
+	
+	u = user.Current(c)
+	
+	for _, depth1 = range tree {
+		if depth1.kind == "folder" {
+			parentKey = this.registerFolder(c, u, depth1.title, false, folderKey)
+			for _, depth2 = range depth1.children {
+				feed = new(Feed)
+				entries = make([]*Entry, 0)
+				feed, entries = this.getFeedFromXML(c, depth2.xmlURL)
+				feed.URL = depth2.xmlURL
+				feed.SiteURL = depth2.htmlURL
+				this.registerFeed(c, feed, entries, parentKey)
+			}
+		} else {
+			feed = new(Feed)
+			entries = make([]*Entry, 0)
+			feed, entries = this.getFeedFromXML(c, depth1.xmlURL)
+			feed.URL = depth1.xmlURL
+			feed.SiteURL = depth1.htmlURL
+			this.registerFeed(c, feed, entries, parentKey)
+		}
+	}
+}
+
+/**
+ * XMLのURLからフィードを取得する
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {string} url XMLファイルの場所
+ * @returns {*Feed} フィード
+ * @returns {[]*Entries} フィードのエントリリスト
+ */
+func (this *DAO) getFeedFromXML(c appengine.Context, url string) (*Feed, []*Entry) {
+	var feedXML []byte
+	var feed *Feed
+	var entries []*Entry
+	var err error
+	
+	feedXML = getXML(c, url)
+	
+	feed, entries, err = parseFeed(c, feedXML, "")
+	check(c, err)
+	if feed == nil {
+		feed = new(Feed)
+		entries = make([]*Entry, 0)
+	}
+	feed.URL = url
+	
+	return feed, entries
+}
+
+/**
+ * XMLデータをデータストアに保存する
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {[]byte} xml XMLデータ
+ */
+func (this *DAO) saveXML(c appengine.Context, xml []byte) {
+	var u *user.User
+	var key *datastore.Key
+	var err error
+	type Entity struct {
+		XML []byte
+	}
+	var entity *Entity
+	
+	u = user.Current(c)
+	key = datastore.NewKey(c, "xml", u.ID, 0, nil)
+	
+	entity = new(Entity)
+	entity.XML = xml
+	_, err = datastore.Put(c, key, entity)
+	check(c, err)
+}
+
+/**
+ * XMLデータをデータストアから取得する
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @returns {[]byte} ロードしたXMLデータ
+ */
+func (this *DAO) loadXML(c appengine.Context) []byte {
+	var u *user.User
+	var key *datastore.Key
+	var err error
+	type Entity struct {
+		XML []byte
+	}
+	var entity *Entity
+	
+	u = user.Current(c)
+	key = datastore.NewKey(c, "xml", u.ID, 0, nil)
+	entity = new(Entity)
+	err = datastore.Get(c, key, entity)
+	check(c, err)
+	
+	return entity.XML
+}
+
+/**
+ * 受信予定時刻を過ぎた受信元をすべて更新する
+ * １回の実行で更新するのは予定時刻の古い順に maxFeedsPerRun 件まで
+ * 同じURLを購読しているユーザが何人いても受信は１回で済む
+ * 更新報告は実行記録として保存する
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @returns {*UpdateReport} 受信元ごとの更新報告
+ */
+func (this *DAO) updateAll(c appengine.Context) *UpdateReport {
+	var query *datastore.Query
+	var keys []*datastore.Key
+	var key *datastore.Key
+	var sourceKeys []string
+	var pool *UpdatePool
+	var results map[string]*UpdateResult
+	var sourceResults []*UpdateResult
+	var result *UpdateResult
+	var report *UpdateReport
+	var started time.Time
+	var err error
+	var i int
+	
+	started = time.Now()
+	query = datastore.NewQuery("source").Filter("NextFetch <=", started).Order("NextFetch").Limit(maxFeedsPerRun).KeysOnly()
+	keys, err = query.GetAll(c, nil)
+	check(c, err)
+	
+	sourceKeys = make([]string, len(keys))
+	for i, key = range keys {
+		sourceKeys[i] = key.Encode()
+	}
+	
+	// 各URLフェッチに時間がかかるためワーカープールで並行して更新する
+	// 打ち切られた受信元は NextFetch が変わらないので次回の実行で更新される
+	pool = &UpdatePool{updateWorkers, feedDeadline, poolDeadline}
+	// 未読数は更新し終えてからフォルダごとにまとめて書き込む
+	this.batchUnread()
+	results = pool.run(c, sourceKeys, func(sourceKey string, deadline time.Time) *UpdateResult {
+		var result *UpdateResult
+		result, _ = this.updateSource(c, sourceKey, deadline)
+		return result
+	})
+	this.flushUnread(c)
+	
+	sourceResults = make([]*UpdateResult, len(sourceKeys))
+	for i = range sourceKeys {
+		result = results[sourceKeys[i]]
+		result.Key = sourceKeys[i]
+		if result.URL == "" {
+			result.URL = this.getSource(c, sourceKeys[i]).URL
+		}
+		sourceResults[i] = result
+	}
+	report = makeReport(started, sourceResults)
+	this.saveUpdateLog(c, report)
+	
+	c.Infof("update %d sources: %v", report.Total, report.Statuses)
+	return report
+}
+
+/**
+ * 定期更新の実行記録を保存する
+ * 保存期間を過ぎた記録は削除する
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {*UpdateReport} report 更新報告
+ */
+func (this *DAO) saveUpdateLog(c appengine.Context, report *UpdateReport) {
+	var updateLog *UpdateLog
+	var key *datastore.Key
+	var keys []*datastore.Key
+	var err error
+	
+	updateLog = new(UpdateLog)
+	updateLog.Started = report.Started
+	updateLog.Duration = report.Duration
+	updateLog.Total = report.Total
+	updateLog.New = report.New
+	updateLog.Failed = report.Statuses["failed"] + report.Statuses["parse_error"] + report.Statuses["timeout"] + report.Statuses["cancelled"]
+	updateLog.Report, err = json.Marshal(report)
+	check(c, err)
+	
+	key = datastore.NewIncompleteKey(c, "updatelog", nil)
+	_, err = datastore.Put(c, key, updateLog)
+	check(c, err)
+	
+	keys, err = datastore.NewQuery("updatelog").Filter("Started <", time.Now().Add(-updateLogRetention)).KeysOnly().GetAll(c, nil)
+	check(c, err)
+	err = datastore.DeleteMulti(c, keys)
+	check(c, err)
+}
+
+/**
+ * 定期更新の実行記録を新しい順に取得する
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @returns {[]string} 実行記録のキー
+ * @returns {[]*UpdateLog} 実行記録
+ */
+func (this *DAO) getUpdateLogs(c appengine.Context) ([]string, []*UpdateLog) {
+	var query *datastore.Query
+	var keys []*datastore.Key
+	var logs []*UpdateLog
+	var encodedKeys []string
+	var i int
+	var err error
+	
+	query = datastore.NewQuery("updatelog").Order("-Started").Limit(updateLogSize)
+	logs = make([]*UpdateLog, 0)
+	keys, err = query.GetAll(c, &logs)
+	check(c, err)
+	
+	encodedKeys = make([]string, len(keys))
+	for i = range keys {
+		encodedKeys[i] = keys[i].Encode()
+	}
+	return encodedKeys, logs
+}
+
+/**
+ * 定期更新の実行記録から更新報告を取り出す
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {string} encodedKey 実行記録のキー
+ * @returns {*UpdateReport} 更新報告　取得できなければnil
+ */
+func (this *DAO) getUpdateReport(c appengine.Context, encodedKey string) *UpdateReport {
+	var key *datastore.Key
+	var updateLog *UpdateLog
+	var report *UpdateReport
+	var err error
+	
+	key, err = datastore.DecodeKey(encodedKey)
+	check(c, err)
+	if err != nil {
+		return nil
+	}
+	updateLog = new(UpdateLog)
+	err = datastore.Get(c, key, updateLog)
+	check(c, err)
+	if err != nil {
+		return nil
+	}
+	
+	report = new(UpdateReport)
+	err = json.Unmarshal(updateLog.Report, report)
+	check(c, err)
+	return report
+}
+
+/**
+ * 管理画面に表示する整合性の問題の件数
+ */
+const integrityIssueLimit = 1000
+
+/**
+ * 整合性の検査の実行記録を保存しておく期間
+ */
+const integrityLogRetention = 30 * 24 * time.Hour
+
+/**
+ * 整合性の検査の実行記録
+ * 検査はユーザごと、受信元の組ごとに別のタスクで行うので、タスクごとに１件保存する
+ * キー名は検査を始めた日時とタスクの名前にして、タスクがやり直されても重複しないようにする
+ * @class
+ * @member {time.Time} Run 検査全体を始めた日時　同じ回の記録をまとめるのに使う
+ * @member {bool} Repair 修復したかどうか
+ * @member {int} Issues 見つかった問題の数
+ * @member {[]byte} Report 検査結果(IntegrityReport)のJSON
+ */
+type IntegrityLog struct {
+	Run time.Time
+	Repair bool
+	Issues int
+	Report []byte `datastore:",noindex"`
+}
+
+/**
+ * 整合性の検査で見つかった問題
+ * @class
+ * @member {string} Kind 問題の種類
+ *     "duplicate_root" ユーザにルートフォルダが複数ある
+ *     "dangling_child" フォルダの Children が存在しないフォルダ・フィードを指している
+ *     "duplicate_child" 同じ子が Children に２回以上ある、または複数のフォルダに入っている
+ *     "cycle" フォルダが自分自身かその祖先を子に持っている
+ *     "wrong_parent" 子の Parent が入っているフォルダと違う
+ *     "orphan_folder" ルートフォルダからたどれないフォルダ
+ *     "orphan_feed" ルートフォルダからたどれないフィード
+ *     "dangling_entry" フィードのエントリリストが存在しないエントリを指している
+ *     "duplicate_entry" 同じエントリがフィードのエントリリストに２回以上ある
+ *     "orphan_entry" どのフィードのエントリリストにも入っていないエントリ
+ *     "missing_source" フィードの受信元が存在しない
+ *     "unsubscribed_feed" フィードが受信元の購読者に入っていない
+ *     "dangling_subscriber" 受信元の購読者が存在しないフィードを指している
+ *     "unused_source" 存在するフィードの購読者が１つもない受信元
+ * @member {string} Owner 所有者のユーザID
+ * @member {string} Key 問題のあるフォルダ・フィード・エントリ・受信元のキー
+ * @member {string} Target 問題の原因になっている参照先のキー
+ * @member {string} Action 修復で行ったこと　修復しなかったときは空文字列
+ */
+type IntegrityIssue struct {
+	Kind string
+	Owner string
+	Key string
+	Target string
+	Action string
+}
+
+/**
+ * 整合性の検査結果
+ * 検査はユーザごと、受信元の組ごとに行い、管理画面ではそれらを合計して表示する
+ * @class
+ * @member {bool} Repair 修復したかどうか
+ * @member {string} Error 読み込みに失敗して検査できなかったときのエラーメッセージ
+ * @member {int} Users 検査したユーザの数
+ * @member {int} Folders 検査したフォルダの数
+ * @member {int} Feeds 検査したフィードの数
+ * @member {int} Entries 検査したエントリの数
+ * @member {int} Sources 検査した受信元の数
+ * @member {map[string]int} Counts 問題の種類ごとの件数
+ * @member {[]*IntegrityIssue} Issues 見つかった問題(見つけた順)
+ */
+type IntegrityReport struct {
+	Repair bool
+	Error string
+	Users int
+	Folders int
+	Feeds int
+	Entries int
+	Sources int
+	Counts map[string]int
+	Issues []*IntegrityIssue
+}
+
+/**
+ * 整合性の検査の途中経過
+ * １人のユーザ、または受信元の１組を検査する間だけ使う
+ * 検査は読み込んだ時点の内容で行い、修復は読み込み直した最新の内容に対して行う
+ * @class
+ * @member {appengine.Context} c コンテキスト
+ * @member {*IntegrityReport} report 検査結果
+ * @member {bool} repair 修復するかどうか
+ * @member {error} err 読み込みに失敗したときのエラー　失敗したら修復しない
+ * @member {map[string]*Folder} folders キーとフォルダの対応(読み込んだものだけ)
+ * @member {map[string]*Feed} feeds キーとフィードの対応(読み込んだものだけ)
+ * @member {map[string]*Entry} entries キーとエントリの対応(どこからも参照されていないものだけ)
+ * @member {map[string]*Source} sources キーと受信元の対応(読み込んだものだけ)
+ * @member {map[string]bool} listed 検査を始めたときにユーザが持っていたフォルダとフィード
+ * @member {map[string]bool} late listed に含まれず、検査中に作られたとみられるフォルダとフィード
+ * @member {map[string]string} roots ユーザIDとルートフォルダのキーの対応
+ * @member {map[string]bool} visited ルートフォルダからたどれたフォルダとフィード
+ * @member {map[string]bool} referenced フィードのエントリリストに入っているエントリ
+ * @member {map[string][]func(*Folder)} folderFixes フォルダごとの修復処理
+ * @member {map[string][]func(*Feed)} feedFixes フィードごとの修復処理
+ * @member {map[string][]func(*Source)} sourceFixes 受信元ごとの修復処理
+ * @member {[][2]string} adoptions 親フォルダに戻すフォルダ・フィードのキーと戻す先のフォルダのキーの組
+ * @member {[]string} removedRoots 中身を移して削除するルートフォルダ
+ * @member {[]string} removedEntries 削除するエントリ
+ * @member {[]string} unusedSources 購読者がいなければ削除する受信元
+ */
+type integrityScan struct {
+	c appengine.Context
+	report *IntegrityReport
+	repair bool
+	err error
+	folders map[string]*Folder
+	feeds map[string]*Feed
+	entries map[string]*Entry
+	sources map[string]*Source
+	listed map[string]bool
+	late map[string]bool
+	roots map[string]string
+	visited map[string]bool
+	referenced map[string]bool
+	folderFixes map[string][]func(*Folder)
+	feedFixes map[string][]func(*Feed)
+	sourceFixes map[string][]func(*Source)
+	adoptions [][2]string
+	removedRoots []string
+	removedEntries []string
+	unusedSources []string
+}
+
+/**
+ * エンティティを読み込む
+ * 存在しないときと、キーが壊れているか種類が違うときは false を返す
+ * それ以外の読み込みの失敗は、存在しないものと区別できないので err に記録して修復をやめる
+ * @methodOf integrityScan
+ * @param {string} encodedKey キー
+ * @param {string} kind エンティティの種類
+ * @param {interface{}} dst 読み込み先
+ * @returns {bool} 読み込めたらtrue
+ */
+func (this *integrityScan) load(encodedKey string, kind string, dst interface{}) bool {
+	var key *datastore.Key
+	var err error
+	
+	key, err = datastore.DecodeKey(encodedKey)
+	if err != nil || key.Kind() != kind {
+		return false
+	}
+	err = ignoreMismatch(datastore.Get(this.c, key, dst))
+	if err != nil && err != datastore.ErrNoSuchEntity {
+		this.fail(err)
+	}
+	return err == nil
+}
+
+/**
+ * 読み込みの失敗を記録する　最初のエラーだけを残す
+ * @methodOf integrityScan
+ * @param {error} err エラー
+ */
+func (this *integrityScan) fail(err error) {
+	check(this.c, err)
+	if this.err == nil {
+		this.err = err
+	}
+}
+
+/**
+ * キーからフォルダを取り出す　まだ読み込んでいなければキーで読み込む
+ * @methodOf integrityScan
+ * @param {string} key フォルダのキー
+ * @returns {*Folder} フォルダ　存在しなければnil
+ */
+func (this *integrityScan) folder(key string) *Folder {
+	var folder *Folder
+	
+	folder = this.folders[key]
+	if folder != nil {
+		return folder
+	}
+	folder = new(Folder)
+	if !this.load(key, "folder", folder) {
+		return nil
+	}
+	this.folders[key] = folder
+	this.late[key] = !this.listed[key]
+	return folder
+}
+
+/**
+ * キーからフィードを取り出す　まだ読み込んでいなければキーで読み込む
+ * @methodOf integrityScan
+ * @param {string} key フィードのキー
+ * @returns {*Feed} フィード　存在しなければnil
+ */
+func (this *integrityScan) feed(key string) *Feed {
+	var feed *Feed
+	
+	feed = this.feeds[key]
+	if feed != nil {
+		return feed
+	}
+	feed = new(Feed)
+	if !this.load(key, "feed", feed) {
+		return nil
+	}
+	this.feeds[key] = feed
+	this.late[key] = !this.listed[key]
+	return feed
+}
+
+/**
+ * キーからエントリを取り出す　まだ読み込んでいなければキーで読み込む
+ * @methodOf integrityScan
+ * @param {string} key エントリのキー
+ * @returns {*Entry} エントリ　存在しなければnil
+ */
+func (this *integrityScan) entry(key string) *Entry {
+	var entry *Entry
+	
+	entry = this.entries[key]
+	if entry != nil {
+		return entry
+	}
+	entry = new(Entry)
+	if !this.load(key, "entry", entry) {
+		return nil
+	}
+	this.entries[key] = entry
+	return entry
+}
+
+/**
+ * キーから受信元を取り出す　まだ読み込んでいなければキーで読み込む
+ * @methodOf integrityScan
+ * @param {string} key 受信元のキー
+ * @returns {*Source} 受信元　存在しなければnil
+ */
+func (this *integrityScan) source(key string) *Source {
+	var source *Source
+	
+	source = this.sources[key]
+	if source != nil {
+		return source
+	}
+	source = new(Source)
+	if !this.load(key, "source", source) {
+		return nil
+	}
+	this.sources[key] = source
+	return source
+}
+
+/**
+ * エントリリストにあるエントリのうち存在するものを調べる
+ * maxBatchSize 件ずつまとめて読み込み、読み込んだエントリは残さない
+ * @methodOf integrityScan
+ * @param {[]string} keys エントリのキー
+ * @returns {map[string]bool} 存在するエントリのキー
+ */
+func (this *integrityScan) existingEntries(keys []string) map[string]bool {
+	var existing map[string]bool
+	var batch []*datastore.Key
+	var encodedKeys []string
+	var entries []*Entry
+	var key *datastore.Key
+	var encodedKey string
+	var getErr error
+	var start int
+	var end int
+	var i int
+	var err error
+	
+	existing = make(map[string]bool)
+	for start = 0; start < len(keys); start = end {
+		end = start + maxBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		batch = make([]*datastore.Key, 0, end - start)
+		encodedKeys = make([]string, 0, end - start)
+		for _, encodedKey = range keys[start:end] {
+			key, err = datastore.DecodeKey(encodedKey)
+			if err != nil || key.Kind() != "entry" {
+				continue
+			}
+			batch = append(batch, key)
+			encodedKeys = append(encodedKeys, encodedKey)
+		}
+		if len(batch) == 0 {
+			continue
+		}
+		entries = make([]*Entry, len(batch))
+		getErr = datastore.GetMulti(this.c, batch, entries)
+		for i = range batch {
+			err = ignoreMismatch(errorAt(getErr, i))
+			if err == nil {
+				existing[encodedKeys[i]] = true
+			} else if err != datastore.ErrNoSuchEntity {
+				this.fail(err)
+			}
+		}
+	}
+	return existing
+}
+
+/**
+ * 見つかった問題を記録する
+ * @methodOf integrityScan
+ * @param {string} kind 問題の種類
+ * @param {string} owner 所有者のユーザID
+ * @param {string} key 問題のあるもののキー
+ * @param {string} target 参照先のキー
+ * @param {string} action 修復で行うこと　修復しないときは記録しない
+ */
+func (this *integrityScan) issue(kind string, owner string, key string, target string, action string) {
+	if !this.repair {
+		action = ""
+	}
+	this.report.Issues = append(this.report.Issues, &IntegrityIssue{kind, owner, key, target, action})
+	this.report.Counts[kind]++
+}
+
+/**
+ * フォルダの Children から子を１つ外す修復処理を加える
+ * @methodOf integrityScan
+ * @param {string} folderKey フォルダのキー
+ * @param {string} childKey 外す子のキー
+ */
+func (this *integrityScan) removeChild(folderKey string, childKey string) {
+	this.folderFixes[folderKey] = append(this.folderFixes[folderKey], func(folder *Folder) {
+		folder.Children = removeItem(folder.Children, childKey)
+	})
+}
+
+/**
+ * フォルダまたはフィードの Parent を書き換える修復処理を加える
+ * @methodOf integrityScan
+ * @param {string} childKey フォルダまたはフィードのキー
+ * @param {string} parentKey 親フォルダのキー
+ */
+func (this *integrityScan) setParent(childKey string, parentKey string) {
+	var key *datastore.Key
+	
+	key, _ = datastore.DecodeKey(childKey)
+	if key != nil && key.Kind() == "feed" {
+		this.feedFixes[childKey] = append(this.feedFixes[childKey], func(feed *Feed) {
+			feed.Parent = parentKey
+		})
+	} else {
+		this.folderFixes[childKey] = append(this.folderFixes[childKey], func(folder *Folder) {
+			folder.Parent = parentKey
+		})
+	}
+}
+
+/**
+ * フォルダから中身をたどって検査する
+ * @methodOf integrityScan
+ * @param {string} folderKey フォルダのキー
+ * @param {map[string]bool} path ルートフォルダからこのフォルダの親までのフォルダ
+ */
+func (this *integrityScan) walk(folderKey string, path map[string]bool) {
+	var folder *Folder
+	var childKey string
+	var listed map[string]bool
+	var key *datastore.Key
+	var err error
+	
+	folder = this.folders[folderKey]
+	this.visited[folderKey] = true
+	path[folderKey] = true
+	defer delete(path, folderKey)
+	
+	listed = make(map[string]bool)
+	for _, childKey = range folder.Children {
+		key, err = datastore.DecodeKey(childKey)
+		switch {
+			case listed[childKey]:
+				this.issue("duplicate_child", folder.Owner, folderKey, childKey, "重複した参照を削除")
+				this.removeChild(folderKey, childKey)
+			case path[childKey]:
+				this.issue("cycle", folder.Owner, folderKey, childKey, "循環する参照を削除")
+				this.removeChild(folderKey, childKey)
+			case this.visited[childKey]:
+				this.issue("duplicate_child", folder.Owner, folderKey, childKey, "他のフォルダと重複する参照を削除")
+				this.removeChild(folderKey, childKey)
+			case err == nil && key.Kind() == "folder" && this.folder(childKey) != nil:
+				if this.folders[childKey].Parent != folderKey {
+					this.issue("wrong_parent", folder.Owner, childKey, this.folders[childKey].Parent, "親をこのフォルダに修正")
+					this.setParent(childKey, folderKey)
+				}
+				this.walk(childKey, path)
+			case err == nil && key.Kind() == "feed" && this.feed(childKey) != nil:
+				if this.feeds[childKey].Parent != folderKey {
+					this.issue("wrong_parent", folder.Owner, childKey, this.feeds[childKey].Parent, "親をこのフォルダに修正")
+					this.setParent(childKey, folderKey)
+				}
+				this.visited[childKey] = true
+				this.checkFeed(childKey)
+			default:
+				this.issue("dangling_child", folder.Owner, folderKey, childKey, "存在しない子への参照を削除")
+				this.removeChild(folderKey, childKey)
+		}
+		listed[childKey] = true
+	}
+}
+
+/**
+ * フィードのエントリリストと受信元を検査する
+ * @methodOf integrityScan
+ * @param {string} feedKey フィードのキー
+ */
+func (this *integrityScan) checkFeed(feedKey string) {
+	var feed *Feed
+	var source *Source
+	var listed map[string]bool
+	var existing map[string]bool
+	var entryKey string
+	var unread bool
+	var checkEntry func(entryKey string, unread bool)
+	
+	feed = this.feeds[feedKey]
+	listed = make(map[string]bool)
+	existing = this.existingEntries(append(append([]string{}, feed.Entries...), feed.ReadEntries...))
+	checkEntry = func(entryKey string, unread bool) {
+		var kind string
+		
+		if !listed[entryKey] && existing[entryKey] {
+			listed[entryKey] = true
+			this.referenced[entryKey] = true
+			return
+		}
+		kind = "dangling_entry"
+		if listed[entryKey] {
+			kind = "duplicate_entry"
+		}
+		this.issue(kind, feed.Owner, feedKey, entryKey, "エントリへの参照を削除")
+		this.feedFixes[feedKey] = append(this.feedFixes[feedKey], func(feed *Feed) {
+			if unread {
+				feed.Entries = removeItem(feed.Entries, entryKey)
+			} else {
+				feed.ReadEntries = removeItem(feed.ReadEntries, entryKey)
+			}
+		})
+	}
+	unread = true
+	for _, entryKey = range feed.Entries {
+		checkEntry(entryKey, unread)
+	}
+	unread = false
+	for _, entryKey = range feed.ReadEntries {
+		checkEntry(entryKey, unread)
+	}
+	
+	// 受信元を持たないフィードは表示するときに登録し直されるので問題にしない
+	if feed.Source == "" {
+		return
+	}
+	source = this.source(feed.Source)
+	if source == nil {
+		this.issue("missing_source", feed.Owner, feedKey, feed.Source, "受信元への参照を削除(次に表示したときに登録し直す)")
+		this.feedFixes[feedKey] = append(this.feedFixes[feedKey], func(current *Feed) {
+			if current.Source == feed.Source {
+				current.Source = ""
+			}
+		})
+	} else if !contains(source.Subscribers, feedKey) {
+		this.issue("unsubscribed_feed", feed.Owner, feedKey, feed.Source, "受信元の購読者に追加")
+		this.sourceFixes[feed.Source] = append(this.sourceFixes[feed.Source], func(source *Source) {
+			if !contains(source.Subscribers, feedKey) {
+				source.Subscribers = append(source.Subscribers, feedKey)
+			}
+		})
+	}
+}
+
+/**
+ * ルートフォルダからたどれないフォルダまたはフィードを元の親フォルダに戻す
+ * 元の親がたどれないか存在しないか、削除する重複ルートフォルダなら、所有者のルートフォルダに戻す
+ * ただし検査中に作られた親(最初の読み込みになかった親)はたどれなくてもそのまま使う
+ * @methodOf integrityScan
+ * @param {string} kind "orphan_folder" または "orphan_feed"
+ * @param {string} key フォルダまたはフィードのキー
+ * @param {string} owner 所有者のユーザID
+ * @param {string} parentKey 元の親フォルダのキー
+ */
+func (this *integrityScan) adopt(kind string, key string, owner string, parentKey string) {
+	var to string
+	var parent *Folder
+	
+	to = parentKey
+	parent = this.folder(parentKey)
+	if parent == nil || parent.Owner != owner || !(this.visited[parentKey] || this.late[parentKey]) || contains(this.removedRoots, parentKey) {
+		to = this.roots[owner]
+	}
+	if to == "" {
+		this.issue(kind, owner, key, parentKey, "")
+		return
+	}
+	if to == parentKey {
+		this.issue(kind, owner, key, parentKey, "元の親フォルダに戻す")
+	} else {
+		this.issue(kind, owner, key, parentKey, "ルートフォルダに移す")
+	}
+	this.adoptions = append(this.adoptions, [2]string{key, to})
+}
+
+/**
+ * 整合性の検査の途中経過を作る
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {bool} repair 修復するならtrue
+ * @returns {*integrityScan} 検査の途中経過
+ */
+func (this *DAO) newIntegrityScan(c appengine.Context, repair bool) *integrityScan {
+	return &integrityScan{
+		c: c,
+		report: &IntegrityReport{Repair: repair, Counts: make(map[string]int), Issues: make([]*IntegrityIssue, 0)},
+		repair: repair,
+		folders: make(map[string]*Folder),
+		feeds: make(map[string]*Feed),
+		entries: make(map[string]*Entry),
+		sources: make(map[string]*Source),
+		listed: make(map[string]bool),
+		late: make(map[string]bool),
+		roots: make(map[string]string),
+		visited: make(map[string]bool),
+		referenced: make(map[string]bool),
+		folderFixes: make(map[string][]func(*Folder)),
+		feedFixes: make(map[string][]func(*Feed)),
+		sourceFixes: make(map[string][]func(*Source)),
+	}
+}
+
+/**
+ * １人のユーザのフォルダ・フィード・エントリの参照関係を検査し、必要なら修復する
+ * ユーザのツリーをルートフォルダからたどり、
+ * 存在しないものへの参照、どこからも参照されていないもの、重複したルートフォルダ、循環を見つける
+ * 修復では参照を直し、どこにも戻せないエントリを削除する
+ * 受信元の購読者は checkSources で別に検査する
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {string} owner ユーザID
+ * @param {bool} repair 修復するならtrue、検査だけならfalse
+ * @returns {*IntegrityReport} 検査結果
+ */
+func (this *DAO) checkIntegrity(c appengine.Context, owner string, repair bool) *IntegrityReport {
+	var scan *integrityScan
+	var report *IntegrityReport
+	var rootKeys []string
+	var folderKeys []string
+	var feedKeys []string
+	var primary string
+	var key string
+	var top string
+	var chain map[string]bool
+	var entry *Entry
+	var i int
+	var err error
+	
+	scan = this.newIntegrityScan(c, repair)
+	report = scan.report
+	
+	// 検査中に追加されたものはどこからも参照されていないように見えることがあるが、
+	// 修復は最新の内容で既に参照されていれば何もしない
+	rootKeys = make([]string, 0)
+	folderKeys = make([]string, 0)
+	feedKeys = make([]string, 0)
+	err = eachKey(c, datastore.NewQuery("folder").Filter("Owner =", owner), func(key *datastore.Key) {
+		folderKeys = append(folderKeys, key.Encode())
+	})
+	if err == nil {
+		err = eachKey(c, datastore.NewQuery("feed").Filter("Owner =", owner), func(key *datastore.Key) {
+			feedKeys = append(feedKeys, key.Encode())
+		})
+	}
+	if err == nil {
+		err = eachKey(c, datastore.NewQuery("folder").Filter("Type =", "root").Filter("Owner =", owner), func(key *datastore.Key) {
+			rootKeys = append(rootKeys, key.Encode())
+		})
+	}
+	check(c, err)
+	if err != nil {
+		// 一部しか読み込めないまま修復すると正しい参照まで消してしまう
+		report.Error = err.Error()
+		return report
+	}
+	sort.Strings(rootKeys)
+	sort.Strings(folderKeys)
+	sort.Strings(feedKeys)
+	for _, key = range folderKeys {
+		scan.listed[key] = true
+	}
+	for _, key = range feedKeys {
+		scan.listed[key] = true
+	}
+	report.Folders = len(folderKeys)
+	report.Feeds = len(feedKeys)
+	if len(rootKeys) == 0 {
+		return report
+	}
+	report.Users = 1
+	
+	// ルートフォルダを決める
+	primary, _ = this.getRootFolder(c, &user.User{ID: owner})
+	if scan.folder(primary) == nil {
+		primary = rootKeys[0]
+	}
+	if scan.folder(primary) == nil {
+		report.Error = join(owner, ": root folder not found")
+		return report
+	}
+	scan.roots[owner] = primary
+	
+	// ルートフォルダからたどる
+	// 重複したルートフォルダは、修復するなら中身を使っているルートフォルダに移してからたどる
+	for _, key = range rootKeys {
+		if key == primary || scan.folder(key) == nil {
+			continue
+		}
+		scan.issue("duplicate_root", owner, key, primary, "中身をルートフォルダに移して削除")
+		if repair {
+			for i = range scan.folders[key].Children {
+				if contains(scan.folders[primary].Children, scan.folders[key].Children[i]) {
+					continue
+				}
+				scan.folderFixes[primary] = append(scan.folderFixes[primary], scan.appendChild(scan.folders[key].Children[i]))
+				scan.folders[primary].Children = append(scan.folders[primary].Children, scan.folders[key].Children[i])
+			}
+			scan.visited[key] = true
+			scan.removedRoots = append(scan.removedRoots, key)
+		}
+	}
+	scan.walk(primary, make(map[string]bool))
+	for _, key = range rootKeys {
+		if !scan.visited[key] && scan.folder(key) != nil {
+			scan.walk(key, make(map[string]bool))
+		}
+	}
+	
+	// たどれなかったフォルダは、親をさかのぼって一番上のものから戻す
+	for _, key = range folderKeys {
+		if scan.visited[key] || scan.folder(key) == nil {
+			continue
+		}
+		top = key
+		chain = map[string]bool{key: true}
+		for scan.folder(scan.folders[top].Parent) != nil && !scan.visited[scan.folders[top].Parent] && !scan.late[scan.folders[top].Parent] && !chain[scan.folders[top].Parent] {
+			top = scan.folders[top].Parent
+			chain[top] = true
+		}
+		scan.adopt("orphan_folder", top, scan.folders[top].Owner, scan.folders[top].Parent)
+		scan.walk(top, make(map[string]bool))
+	}
+	
+	// たどれなかったフィード
+	for _, key = range feedKeys {
+		if scan.visited[key] || scan.feed(key) == nil {
+			continue
+		}
+		scan.adopt("orphan_feed", key, scan.feeds[key].Owner, scan.feeds[key].Parent)
+		scan.visited[key] = true
+		scan.checkFeed(key)
+	}
+	
+	// どのフィードのリストにも入っていないエントリは、登録されているフィードに戻すか削除する
+	err = eachKey(c, datastore.NewQuery("entry").Filter("Owner =", owner), func(entryKey *datastore.Key) {
+		var key string
+		
+		report.Entries++
+		key = entryKey.Encode()
+		if scan.referenced[key] {
+			return
+		}
+		entry = scan.entry(key)
+		if entry == nil {
+			return
+		}
+		if entry.Feed == "" || scan.feed(entry.Feed) == nil {
+			scan.issue("orphan_entry", entry.Owner, key, entry.Feed, "削除")
+			scan.removedEntries = append(scan.removedEntries, key)
+			return
+		}
+		scan.issue("orphan_entry", entry.Owner, key, entry.Feed, "フィードのエントリリストに戻す")
+		scan.feedFixes[entry.Feed] = append(scan.feedFixes[entry.Feed], scan.appendEntry(key, entry.Read))
+	})
+	if err != nil {
+		scan.fail(err)
+	}
+	
+	if scan.err != nil {
+		report.Error = scan.err.Error()
+	} else if repair {
+		this.applyRepairs(c, scan)
+		
+		// 移動や重複したルートフォルダの統合でずれたフォルダの未読数を数え直す
+		this.recountUnread(c, owner)
+	}
+	
+	c.Infof("integrity check of %s: %d issues (repair: %v)", owner, len(report.Issues), repair)
+	return report
+}
+
+/**
+ * 受信元の購読者を queryPageSize 件ずつ検査し、必要なら修復する
+ * 存在しないフィードを購読者から外し、購読者のいない受信元を本文とともに削除する
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {string} cursor 続きから検査するときのカーソル　最初からなら空文字列
+ * @param {bool} repair 修復するならtrue、検査だけならfalse
+ * @returns {*IntegrityReport} 検査結果
+ * @returns {string} 続きのカーソル　最後まで検査したら空文字列
+ */
+func (this *DAO) checkSources(c appengine.Context, cursor string, repair bool) (*IntegrityReport, string) {
+	var scan *integrityScan
+	var report *IntegrityReport
+	var query *datastore.Query
+	var iterator *datastore.Iterator
+	var start datastore.Cursor
+	var next datastore.Cursor
+	var key *datastore.Key
+	var source *Source
+	var encodedKey string
+	var subscriber string
+	var valid int
+	var err error
+	
+	scan = this.newIntegrityScan(c, repair)
+	report = scan.report
+	
+	query = datastore.NewQuery("source").Limit(queryPageSize)
+	if cursor != "" {
+		start, err = datastore.DecodeCursor(cursor)
+		if err != nil {
+			report.Error = err.Error()
+			return report, ""
+		}
+		query = query.Start(start)
+	}
+	iterator = query.Run(c)
+	for {
+		source = new(Source)
+		key, err = iterator.Next(source)
+		if err == datastore.Done {
+			err = nil
+			break
+		}
+		if err != nil {
+			break
+		}
+		encodedKey = key.Encode()
+		scan.sources[encodedKey] = source
+		report.Sources++
+		
+		valid = 0
+		for _, subscriber = range source.Subscribers {
+			if scan.feed(subscriber) != nil {
+				valid++
+				continue
+			}
+			scan.issue("dangling_subscriber", "", encodedKey, subscriber, "存在しないフィードを購読者から削除")
+			scan.sourceFixes[encodedKey] = append(scan.sourceFixes[encodedKey], scan.removeSubscriber(subscriber))
+		}
+		if valid == 0 {
+			scan.issue("unused_source", "", encodedKey, "", "本文とともに削除")
+			scan.unusedSources = append(scan.unusedSources, encodedKey)
+		}
+	}
+	if err == nil && report.Sources == queryPageSize {
+		next, err = iterator.Cursor()
+		cursor = next.String()
+	} else {
+		cursor = ""
+	}
+	if err != nil {
+		scan.fail(err)
+		cursor = ""
+	}
+	
+	if scan.err != nil {
+		report.Error = scan.err.Error()
+	} else if repair {
+		this.applyRepairs(c, scan)
+	}
+	
+	c.Infof("integrity check of %d sources: %d issues (repair: %v)", report.Sources, len(report.Issues), repair)
+	return report, cursor
+}
+
+/**
+ * 整合性の検査を始める
+ * ユーザを振り分けるタスクと受信元を検査するタスクを追加し、保存期間を過ぎた実行記録を削除する
+ * 結果は終わったタスクから順に実行記録として保存される
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {bool} repair 修復するならtrue、検査だけならfalse
+ * @returns {error} タスクを追加できなかったときのエラー
+ */
+func (this *DAO) startIntegrityCheck(c appengine.Context, repair bool) error {
+	var run int64
+	var keys []*datastore.Key
+	var err error
+	
+	run = time.Now().Unix()
+	err = this.addIntegrityTask(c, "/task/integrity", run, repair, url.Values{"page": {"0"}}, "users-0")
+	if err == nil {
+		err = this.addIntegrityTask(c, "/task/integrity/sources", run, repair, url.Values{"page": {"0"}}, "sources-0")
+	}
+	check(c, err)
+	if err != nil {
+		return err
+	}
+	
+	keys = make([]*datastore.Key, 0)
+	err = eachKey(c, datastore.NewQuery("integritylog").Filter("Run <", time.Now().Add(-integrityLogRetention)), func(key *datastore.Key) {
+		keys = append(keys, key)
+	})
+	check(c, err)
+	err = deleteBatches(c, keys)
+	check(c, err)
+	return nil
+}
+
+/**
+ * 整合性の検査のタスクを追加する
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {string} path タスクのURL
+ * @param {int64} run 検査全体を始めた日時(UNIX時間)
+ * @param {bool} repair 修復するならtrue
+ * @param {url.Values} params その他のパラメータ
+ * @param {string} name 同じ回のタスクの中で一意な名前(英数字とハイフンのみ)
+ * @returns {error} タスクを追加できなかったときのエラー
+ */
+func (this *DAO) addIntegrityTask(c appengine.Context, path string, run int64, repair bool, params url.Values, name string) error {
+	params.Set("run", strconv.FormatInt(run, 10))
+	if repair {
+		params.Set("repair", "1")
+	}
+	return this.addTask(c, path, params, join("integrity-", strconv.FormatInt(run, 10), "-", name))
+}
+
+/**
+ * 名前を付けてタスクを追加する
+ * 同じ名前のタスクは一度しか追加されないので、タスクを追加する処理がやり直されても重複しない
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {string} path タスクのURL
+ * @param {url.Values} params パラメータ
+ * @param {string} name タスクの名前(英数字とハイフンのみ)
+ * @returns {error} タスクを追加できなかったときのエラー
+ */
+func (this *DAO) addTask(c appengine.Context, path string, params url.Values, name string) error {
+	var task *taskqueue.Task
+	var err error
+	
+	task = taskqueue.NewPOSTTask(path, params)
+	task.Name = name
+	_, err = taskqueue.Add(c, task, "")
+	if err == taskqueue.ErrTaskAlreadyAdded {
+		return nil
+	}
+	return err
+}
+
+/**
+ * ルートフォルダを queryPageSize 件ずつ読み、その所有者ごとにタスクを追加する
+ * 続きがあれば、次の組を振り分けるタスクを追加する
+ * ルートフォルダが重複しているユーザもタスクの名前が同じなので１回だけ処理する
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {string} path ユーザごとのタスクのURL　owner パラメータにユーザIDを付ける
+ * @param {string} fanout 続きを振り分けるタスクのURL　cursor, page パラメータを付ける
+ * @param {url.Values} params どちらのタスクにも付けるパラメータ
+ * @param {string} prefix タスクの名前の先頭に付ける、振り分けの回ごとに一意な文字列
+ * @param {string} cursor 続きから振り分けるときのカーソル　最初からなら空文字列
+ * @param {int} page 何組目か(0から)
+ * @returns {error} 読み込みかタスクの追加に失敗したときのエラー
+ */
+func (this *DAO) enqueueOwners(c appengine.Context, path string, fanout string, params url.Values, prefix string, cursor string, page int) error {
+	var query *datastore.Query
+	var iterator *datastore.Iterator
+	var start datastore.Cursor
+	var next datastore.Cursor
+	var folder *Folder
+	var taskParams url.Values
+	var copyParams func() url.Values
+	var count int
+	var err error
+	
+	// パラメータはタスクごとに複製してから書き足す
+	copyParams = func() url.Values {
+		var result url.Values
+		var name string
+		var values []string
+		
+		result = make(url.Values)
+		for name, values = range params {
+			result[name] = values
+		}
+		return result
+	}
+	
+	query = datastore.NewQuery("folder").Filter("Type =", "root").Limit(queryPageSize)
+	if cursor != "" {
+		start, err = datastore.DecodeCursor(cursor)
+		check(c, err)
+		if err != nil {
+			return err
+		}
+		query = query.Start(start)
+	}
+	
+	iterator = query.Run(c)
+	for {
+		folder = new(Folder)
+		_, err = iterator.Next(folder)
+		if err == datastore.Done {
+			err = nil
+			break
+		}
+		if err != nil {
+			break
+		}
+		count++
+		taskParams = copyParams()
+		taskParams.Set("owner", folder.Owner)
+		err = this.addTask(c, path, taskParams, join(prefix, "user-", hashID(folder.Owner)))
+		if err != nil {
+			break
+		}
+	}
+	if err == nil && count == queryPageSize {
+		next, err = iterator.Cursor()
+		if err == nil {
+			page++
+			taskParams = copyParams()
+			taskParams.Set("cursor", next.String())
+			taskParams.Set("page", strconv.Itoa(page))
+			err = this.addTask(c, fanout, taskParams, join(prefix, "users-", strconv.Itoa(page)))
+		}
+	}
+	check(c, err)
+	return err
+}
+
+/**
+ * 整合性の検査をユーザごとのタスクに振り分ける
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {int64} run 検査全体を始めた日時(UNIX時間)
+ * @param {bool} repair 修復するならtrue
+ * @param {string} cursor 続きから振り分けるときのカーソル　最初からなら空文字列
+ * @param {int} page 何組目か(0から)
+ * @returns {error} 読み込みかタスクの追加に失敗したときのエラー
+ */
+func (this *DAO) enqueueIntegrityChecks(c appengine.Context, run int64, repair bool, cursor string, page int) error {
+	var params url.Values
+	
+	params = url.Values{"run": {strconv.FormatInt(run, 10)}}
+	if repair {
+		params.Set("repair", "1")
+	}
+	return this.enqueueOwners(c, "/task/integrity/user", "/task/integrity", params, join("integrity-", strconv.FormatInt(run, 10), "-"), cursor, page)
+}
+
+/**
+ * 整合性の検査の実行記録を保存する
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {int64} run 検査全体を始めた日時(UNIX時間)
+ * @param {string} name タスクの名前
+ * @param {*IntegrityReport} report 検査結果
+ */
+func (this *DAO) saveIntegrityLog(c appengine.Context, run int64, name string, report *IntegrityReport) {
+	var integrityLog *IntegrityLog
+	var key *datastore.Key
+	var err error
+	
+	integrityLog = new(IntegrityLog)
+	integrityLog.Run = time.Unix(run, 0)
+	integrityLog.Repair = report.Repair
+	integrityLog.Issues = len(report.Issues)
+	integrityLog.Report, err = json.Marshal(report)
+	check(c, err)
+	
+	key = datastore.NewKey(c, "integritylog", join(strconv.FormatInt(run, 10), "-", name), 0, nil)
+	_, err = datastore.Put(c, key, integrityLog)
+	check(c, err)
+}
+
+/**
+ * 最後に行った整合性の検査の結果を、タスクごとの実行記録を合計してまとめる
+ * 問題は integrityIssueLimit 件まで取り出し、件数だけはすべて数える
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @returns {*IntegrityReport} 検査結果　検査したことがなければnil
+ * @returns {time.Time} 検査を始めた日時
+ * @returns {int} 終わったタスクの数
+ */
+func (this *DAO) getIntegrityReport(c appengine.Context) (*IntegrityReport, time.Time, int) {
+	var logs []*IntegrityLog
+	var integrityLog *IntegrityLog
+	var iterator *datastore.Iterator
+	var total *IntegrityReport
+	var report *IntegrityReport
+	var kind string
+	var run time.Time
+	var tasks int
+	var err error
+	
+	logs = make([]*IntegrityLog, 0)
+	_, err = datastore.NewQuery("integritylog").Order("-Run").Limit(1).GetAll(c, &logs)
+	check(c, err)
+	if len(logs) == 0 {
+		return nil, run, 0
+	}
+	run = logs[0].Run
+	
+	total = &IntegrityReport{Repair: logs[0].Repair, Counts: make(map[string]int), Issues: make([]*IntegrityIssue, 0)}
+	iterator = datastore.NewQuery("integritylog").Filter("Run =", run).Run(c)
+	for {
+		integrityLog = new(IntegrityLog)
+		_, err = iterator.Next(integrityLog)
+		if err == datastore.Done {
+			break
+		}
+		check(c, err)
+		if err != nil {
+			break
+		}
+		report = new(IntegrityReport)
+		err = json.Unmarshal(integrityLog.Report, report)
+		check(c, err)
+		if err != nil {
+			continue
+		}
+		
+		tasks++
+		if total.Error == "" {
+			total.Error = report.Error
+		}
+		total.Users = total.Users + report.Users
+		total.Folders = total.Folders + report.Folders
+		total.Feeds = total.Feeds + report.Feeds
+		total.Entries = total.Entries + report.Entries
+		total.Sources = total.Sources + report.Sources
+		for kind = range report.Counts {
+			total.Counts[kind] = total.Counts[kind] + report.Counts[kind]
+		}
+		if len(total.Issues) + len(report.Issues) > integrityIssueLimit {
+			report.Issues = report.Issues[:integrityIssueLimit - len(total.Issues)]
+		}
+		total.Issues = append(total.Issues, report.Issues...)
+	}
+	
+	return total, run, tasks
+}
+
+/**
+ * フォルダの Children の最後に子を加える修復処理を返す
+ * @methodOf integrityScan
+ * @param {string} childKey 加える子のキー
+ * @returns {func(*Folder)} 修復処理
+ */
+func (this *integrityScan) appendChild(childKey string) func(*Folder) {
+	return func(folder *Folder) {
+		if !contains(folder.Children, childKey) {
+			folder.Children = append(folder.Children, childKey)
+		}
+	}
+}
+
+/**
+ * フィードのエントリリストにエントリを戻す修復処理を返す
+ * @methodOf integrityScan
+ * @param {string} entryKey エントリのキー
+ * @param {bool} read 既読のエントリならtrue
+ * @returns {func(*Feed)} 修復処理
+ */
+func (this *integrityScan) appendEntry(entryKey string, read bool) func(*Feed) {
+	return func(feed *Feed) {
+		if contains(feed.Entries, entryKey) || contains(feed.ReadEntries, entryKey) {
+			return
+		}
+		if read {
+			feed.ReadEntries = append(feed.ReadEntries, entryKey)
+		} else {
+			feed.Entries = append(feed.Entries, entryKey)
+		}
+	}
+}
+
+/**
+ * 受信元の購読者からフィードを外す修復処理を返す
+ * @methodOf integrityScan
+ * @param {string} feedKey フィードのキー
+ * @returns {func(*Source)} 修復処理
+ */
+func (this *integrityScan) removeSubscriber(feedKey string) func(*Source) {
+	return func(source *Source) {
+		source.Subscribers = removeItem(source.Subscribers, feedKey)
+	}
+}
+
+/**
+ * 整合性の検査で見つかった問題を修復する
+ * 修復処理はそれぞれ最新の内容を読み込み直したトランザクションの中で行う
+ * 参照を直してから、参照されなくなったものを削除する
+ * @methodOf DAO
+ * @param {appengine.Context} c コンテキスト
+ * @param {*integrityScan} scan 検査の途中経過
+ */
+func (this *DAO) applyRepairs(c appengine.Context, scan *integrityScan) {
+	var encodedKey string
+	var key *datastore.Key
+	var itemKeys []*datastore.Key
+	var folderFixes []func(*Folder)
+	var feedFixes []func(*Feed)
+	var sourceFixes []func(*Source)
+	var adoption [2]string
+	var entry *Entry
+	var err error
+	
+	for encodedKey, folderFixes = range scan.folderFixes {
+		key, err = datastore.DecodeKey(encodedKey)
+		check(c, err)
+		this.modifyFolder(c, key, func(folder *Folder) bool {
+			var fix func(*Folder)
+			
+			for _, fix = range folderFixes {
+				fix(folder)
+			}
+			return true
+		})
+	}
+	for encodedKey, feedFixes = range scan.feedFixes {
+		key, err = datastore.DecodeKey(encodedKey)
+		check(c, err)
+		this.modifyFeed(c, key, func(feed *Feed) bool {
+			var fix func(*Feed)
+			
+			for _, fix = range feedFixes {
+				fix(feed)
+			}
+			return true
+		})
+	}
+	for encodedKey, sourceFixes = range scan.sourceFixes {
+		key, err = datastore.DecodeKey(encodedKey)
+		check(c, err)
+		this.modifySource(c, key, func(source *Source) bool {
+			var fix func(*Source)
+			
+			for _, fix = range sourceFixes {
+				fix(source)
+			}
+			return true
+		})
+	}
+	
+	// たどれなかったフォルダ・フィードを親フォルダに戻す
+	// 修復している間に他のフォルダへ入っていたら何もしない
+	for _, adoption = range scan.adoptions {
+		err = this.transaction(c, func(tc appengine.Context) error {
+			var key *datastore.Key
+			var parentKey *datastore.Key
+			var parent *Folder
+			var current *Folder
+			var feed *Feed
+			var encodedParentKey string
+			var err error
+			
+			key, err = datastore.DecodeKey(adoption[0])
+			if err != nil {
+				return err
+			}
+			if key.Kind() == "feed" {
+				feed = new(Feed)
+				err = datastore.Get(tc, key, feed)
+				encodedParentKey = feed.Parent
+			} else {
+				current = new(Folder)
+				err = datastore.Get(tc, key, current)
+				encodedParentKey = current.Parent
+			}
+			if err != nil {
+				return err
+			}
+			if encodedParentKey != adoption[1] {
+				parent = new(Folder)
+				parentKey, err = datastore.DecodeKey(encodedParentKey)
+				if err == nil && datastore.Get(tc, parentKey, parent) == nil && contains(parent.Children, adoption[0]) {
+					return nil
+				}
+			}
+			
+			parentKey, err = datastore.DecodeKey(adoption[1])
+			if err != nil {
+				return err
+			}
+			parent = new(Folder)
+			err = datastore.Get(tc, parentKey, parent)
+			if err != nil {
+				return err
+			}
+			if !contains(parent.Children, adoption[0]) {
+				parent.Children = append(parent.Children, adoption[0])
+			}
+			_, err = datastore.Put(tc, parentKey, parent)
+			if err != nil {
+				return err
+			}
+			if feed != nil {
+				feed.Parent = adoption[1]
+				_, err = datastore.Put(tc, key, feed)
+			} else {
+				current.Parent = adoption[1]
+				_, err = datastore.Put(tc, key, current)
+			}
+			return err
+		})
+		check(c, err)
+	}
+	
+	// 中身を移した重複ルートフォルダ
+	for _, encodedKey = range scan.removedRoots {
+		key, err = datastore.DecodeKey(encodedKey)
+		check(c, err)
+		err = datastore.Delete(c, key)
+		check(c, err)
+	}
+	
+	// どこにも戻せなかったエントリ　スター付きなら本文を残す理由がなくなったことを記録する
+	for _, encodedKey = range scan.removedEntries {
+		key, err = datastore.DecodeKey(encodedKey)
+		check(c, err)
+		entry = scan.entries[encodedKey]
+		if entry.Starred {
+			err = this.transaction(c, func(tc appengine.Context) error {
+				return this.countStar(tc, entry.Item, -1)
+			})
+			check(c, err)
+		}
+		err = datastore.Delete(c, key)
+		check(c, err)
+	}
+	
+	// 購読者がいなくなった受信元　修復している間に購読されていたら残す
+	// 本文は unsubscribe と同じく、受信元を削除してから少しずつ削除する
+	for _, encodedKey = range scan.unusedSources {
+		key, err = datastore.DecodeKey(encodedKey)
+		check(c, err)
+		err = this.transaction(c, func(tc appengine.Context) error {
+			var source *Source
+			var err error
+			
+			itemKeys = nil
+			source = new(Source)
+			err = datastore.Get(tc, key, source)
+			if err != nil || len(source.Subscribers) > 0 {
+				return err
+			}
+			itemKeys, err = datastore.NewQuery("item").Ancestor(key).KeysOnly().GetAll(tc, nil)
+			if err != nil {
+				return err
+			}
+			return datastore.Delete(tc, key)
+		})
+		check(c, err)
+		if err == nil {
+			err = deleteBatches(c, itemKeys)
+			check(c, err)
+		}
+	}
+}
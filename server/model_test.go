@@ -0,0 +1,210 @@
+/**
+ * データストアを使わないDAOの処理のテスト
+ */
+package okareader
+import (
+	"testing"
+	"time"
+)
+
+/**
+ * 受信に失敗するたびに次の受信までの間隔が倍になり、上限で止まるか確かめる
+ * @function
+ * @param {*testing.T} t テスト
+ */
+func TestRecordFetchBackoff(t *testing.T) {
+	var dao *DAO
+	var source *Source
+	var now time.Time
+	var expected time.Duration
+	var i int
+	
+	dao = new(DAO)
+	source = new(Source)
+	now = time.Date(2013, 3, 5, 12, 0, 0, 0, time.UTC)
+	expected = fetchBackoff
+	for i = 1; i <= 10; i++ {
+		now = now.Add(time.Hour)
+		dao.recordFetch(source, 500, "http_error", now)
+		if source.FailureCount != i {
+			t.Fatalf("failure %d: FailureCount = %d", i, source.FailureCount)
+		}
+		if source.NextFetch.Sub(now) != expected {
+			t.Errorf("failure %d: waits %v, want %v", i, source.NextFetch.Sub(now), expected)
+		}
+		expected = expected * 2
+		if expected > maxFetchBackoff {
+			expected = maxFetchBackoff
+		}
+	}
+	
+	// 解析の失敗も受信の失敗として数える
+	dao.recordFetch(source, 200, "parse_error: EOF", now)
+	if source.FailureCount != 11 || source.NextFetch.Sub(now) != maxFetchBackoff {
+		t.Errorf("parse error: FailureCount = %d, waits %v", source.FailureCount, source.NextFetch.Sub(now))
+	}
+	
+	if len(source.History) != fetchHistorySize {
+		t.Errorf("History keeps %d records, want %d", len(source.History), fetchHistorySize)
+	}
+}
+
+/**
+ * 受信に成功すると失敗の回数と待ち時間が元に戻るか確かめる
+ * @function
+ * @param {*testing.T} t テスト
+ */
+func TestRecordFetchSuccess(t *testing.T) {
+	var dao *DAO
+	var source *Source
+	var now time.Time
+	
+	dao = new(DAO)
+	source = new(Source)
+	now = time.Date(2013, 3, 5, 12, 0, 0, 0, time.UTC)
+	dao.recordFetch(source, 0, "timeout", now)
+	dao.recordFetch(source, 0, "timeout", now)
+	
+	now = now.Add(time.Hour)
+	dao.recordFetch(source, 200, "", now)
+	if source.FailureCount != 0 || !source.NextFetch.IsZero() || !source.LastSuccess.Equal(now) {
+		t.Errorf("success did not reset the schedule: %d failures, next fetch %v", source.FailureCount, source.NextFetch)
+	}
+	
+	// 成功のあとの最初の失敗は最短の間隔から始める
+	dao.recordFetch(source, 0, "timeout", now)
+	if source.NextFetch.Sub(now) != fetchBackoff {
+		t.Errorf("first failure after success waits %v, want %v", source.NextFetch.Sub(now), fetchBackoff)
+	}
+	if !source.History[len(source.History) - 1].Time.Equal(now) {
+		t.Error("the latest fetch is not at the end of History")
+	}
+}
+
+/**
+ * IDだけを持つエントリリストを作る
+ * @function
+ * @param {...string} ids エントリのID
+ * @returns {[]*Entry} エントリリスト
+ */
+func entriesWithID(ids ...string) []*Entry {
+	var entries []*Entry
+	var id string
+	
+	entries = make([]*Entry, 0, len(ids))
+	for _, id = range ids {
+		entries = append(entries, &Entry{ID: id, Link: join("https://example.com/", id)})
+	}
+	return entries
+}
+
+/**
+ * エントリのIDを連結する
+ * @function
+ * @param {[]*Entry} entries エントリリスト
+ * @returns {string} スペース区切りのID
+ */
+func joinIDs(entries []*Entry) string {
+	var ids string
+	var i int
+	
+	for i = range entries {
+		if i > 0 {
+			ids = join(ids, " ")
+		}
+		ids = join(ids, entries[i].ID)
+	}
+	return ids
+}
+
+/**
+ * 配ったことのないエントリだけを、フィード内の位置に関係なく取り出せるか確かめる
+ * @function
+ * @param {*testing.T} t テスト
+ */
+func TestNewEntries(t *testing.T) {
+	var dao *DAO
+	var now time.Time
+	var source *Source
+	var result []*Entry
+	
+	dao = new(DAO)
+	now = time.Date(2013, 3, 5, 12, 0, 0, 0, time.UTC)
+	
+	source = new(Source)
+	result = dao.newEntries(source, entriesWithID("c", "b", "a"), now)
+	if joinIDs(result) != "c b a" {
+		t.Errorf("first fetch: new entries are %q", joinIDs(result))
+	}
+	
+	// 一度配ったものは次の受信で新しいエントリにならない
+	result = dao.newEntries(source, entriesWithID("d", "c", "b", "a"), now)
+	if joinIDs(result) != "d" {
+		t.Errorf("entry at the top: new entries are %q", joinIDs(result))
+	}
+	
+	// 日付順に並んでいないフィードでは途中に新しいエントリが入る
+	result = dao.newEntries(source, entriesWithID("d", "c", "e", "b", "a"), now)
+	if joinIDs(result) != "e" {
+		t.Errorf("entry in the middle: new entries are %q", joinIDs(result))
+	}
+	
+	source = new(Source)
+	result = dao.newEntries(source, entriesWithID("a", "a", "b"), now)
+	if joinIDs(result) != "a b" || len(source.Seen) != 2 {
+		t.Errorf("duplicated ids: new entries are %q, %d remembered", joinIDs(result), len(source.Seen))
+	}
+}
+
+/**
+ * フィードから消えたエントリを保存期間の間だけ覚えておくか確かめる
+ * @function
+ * @param {*testing.T} t テスト
+ */
+func TestNewEntriesRetention(t *testing.T) {
+	var dao *DAO
+	var now time.Time
+	var source *Source
+	
+	dao = new(DAO)
+	now = time.Date(2013, 3, 5, 12, 0, 0, 0, time.UTC)
+	
+	source = &Source{Seen: []SeenEntry{{hashID("b"), now.Add(-time.Hour)}}}
+	dao.newEntries(source, entriesWithID("a"), now)
+	if len(source.Seen) != 2 || source.Seen[0].ID != hashID("a") || source.Seen[1].ID != hashID("b") {
+		t.Errorf("recently dropped entry was forgotten: %v", source.Seen)
+	}
+	
+	source = &Source{Seen: []SeenEntry{{hashID("b"), now.Add(-seenRetention - time.Hour)}}}
+	dao.newEntries(source, entriesWithID("a"), now)
+	if len(source.Seen) != 1 || source.Seen[0].ID != hashID("a") {
+		t.Errorf("old dropped entry is still remembered: %v", source.Seen)
+	}
+}
+
+/**
+ * Seen を持たない古い受信元は FinalEntry より上のエントリだけを新しいとみなすか確かめる
+ * FinalEntry にはIDかURLのどちらかが入っている
+ * @function
+ * @param {*testing.T} t テスト
+ */
+func TestNewEntriesLegacy(t *testing.T) {
+	var dao *DAO
+	var now time.Time
+	var finalEntry string
+	var source *Source
+	var result []*Entry
+	
+	dao = new(DAO)
+	now = time.Date(2013, 3, 5, 12, 0, 0, 0, time.UTC)
+	for _, finalEntry = range []string{"b", "https://example.com/b"} {
+		source = &Source{FinalEntry: finalEntry}
+		result = dao.newEntries(source, entriesWithID("c", "b", "a"), now)
+		if joinIDs(result) != "c" {
+			t.Errorf("FinalEntry %q: new entries are %q", finalEntry, joinIDs(result))
+		}
+		if len(source.Seen) != 3 || source.FinalEntry != "" {
+			t.Errorf("FinalEntry %q: not migrated to Seen", finalEntry)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+/**
+ * フィードの規格ごとの読み込み処理を管理する
+ * 各規格のファイルは init で自分自身を登録する
+ * 新しい規格に対応するときは Parser を実装して registerParser を呼ぶだけでよい
+ */
+package okareader
+import (
+	"appengine"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"hash"
+	"io"
+)
+
+/**
+ * フィードの読み込み処理
+ * @interface
+ */
+type Parser interface {
+	encode(c appengine.Context, data []byte) (*Feed, []*Entry, error)
+}
+
+/**
+ * データがその規格のフィードかどうかを判断する関数
+ * @function
+ * @param {[]byte} data フィードデータ
+ * @returns {bool} その規格であればtrue
+ */
+type Detector func(data []byte) bool
+
+/**
+ * 登録された規格
+ * @class
+ * @member {string} standard 規格名(Feed.Standard に保存される)
+ * @member {Detector} detect 規格の判断
+ * @member {Parser} parser 読み込み処理
+ */
+type parserEntry struct {
+	standard string
+	detect Detector
+	parser Parser
+}
+
+/**
+ * 登録された規格のリスト
+ * 判断は登録順に行う
+ */
+var parsers []*parserEntry
+
+/**
+ * 規格を登録する
+ * @function
+ * @param {string} standard 規格名
+ * @param {Detector} detect 規格の判断
+ * @param {Parser} parser 読み込み処理
+ */
+func registerParser(standard string, detect Detector, parser Parser) {
+	var entry *parserEntry
+	
+	entry = new(parserEntry)
+	entry.standard = standard
+	entry.detect = detect
+	entry.parser = parser
+	parsers = append(parsers, entry)
+}
+
+/**
+ * データの中身から規格を判断する
+ * @function
+ * @param {[]byte} data フィードデータ
+ * @returns {string} 規格名　どの規格でもなければ"etc"
+ * @returns {Parser} 読み込み処理　どの規格でもなければnil
+ */
+func detectParser(data []byte) (string, Parser) {
+	var entry *parserEntry
+	
+	for _, entry = range parsers {
+		if entry.detect(data) {
+			return entry.standard, entry.parser
+		}
+	}
+	return "etc", nil
+}
+
+/**
+ * 規格名から読み込み処理を取得する
+ * @function
+ * @param {string} standard 規格名
+ * @returns {Parser} 読み込み処理　登録されていなければnil
+ */
+func findParser(standard string) Parser {
+	var entry *parserEntry
+	
+	for _, entry = range parsers {
+		if entry.standard == standard {
+			return entry.parser
+		}
+	}
+	return nil
+}
+
+/**
+ * フィードデータを解析する
+ * 規格が指定されていなければ、または登録されていない規格ならデータの中身から判断する
+ * UTF-8 以外のデータは変換してから解析する
+ * IDを持たないエントリにはハッシュで代わりのIDを付ける
+ * @function
+ * @param {appengine.Context} c コンテキスト
+ * @param {[]byte} data フィードデータ
+ * @param {string} standard 規格名　わからなければ空文字列
+ * @returns {*Feed} フィード　対応していない規格ならnil
+ * @returns {[]*Entry} エントリリスト　対応していない規格ならnil
+ * @returns {error} 対応している規格だが壊れていて読み込めなかったときのエラー
+ */
+func parseFeed(c appengine.Context, data []byte, standard string) (*Feed, []*Entry, error) {
+	var parser Parser
+	var feed *Feed
+	var entries []*Entry
+	var entry *Entry
+	var err error
+	
+	// 規格の判断も読み込みも UTF-8 で行う
+	data = toUTF8(data, "")
+	
+	parser = findParser(standard)
+	if parser == nil {
+		_, parser = detectParser(data)
+	}
+	if parser == nil {
+		return nil, nil, nil
+	}
+	
+	feed, entries, err = parser.encode(c, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	
+	// IDを持たないエントリはハッシュで識別する
+	for _, entry = range entries {
+		if entry.ID == "" {
+			entry.ID = entryHash(entry)
+		}
+	}
+	
+	return feed, entries, nil
+}
+
+/**
+ * IDを持たないエントリのための代わりのIDを作る
+ * URLとタイトル、URLがなければタイトルと要約のハッシュを使う
+ * @function
+ * @param {*Entry} entry エントリ
+ * @returns {string} ハッシュ値
+ */
+func entryHash(entry *Entry) string {
+	var hasher hash.Hash
+	
+	hasher = sha1.New()
+	if entry.Link != "" {
+		io.WriteString(hasher, entry.Link)
+	} else {
+		io.WriteString(hasher, entry.Summary)
+	}
+	io.WriteString(hasher, "\n")
+	io.WriteString(hasher, entry.Title)
+	
+	return join("sha1:", hex.EncodeToString(hasher.Sum(nil)))
+}
+
+/**
+ * XMLのルート要素の名前で規格を判断する関数を作る
+ * @function
+ * @param {string} name ルート要素の名前(名前空間は含まない)
+ * @returns {Detector} 規格の判断
+ */
+func detectXMLRoot(name string) Detector {
+	return func(data []byte) bool {
+		return xmlRoot(data) == name
+	}
+}
+
+/**
+ * XMLのルート要素の名前を返す
+ * @function
+ * @param {[]byte} data XMLデータ
+ * @returns {string} ルート要素の名前　XMLでなければ空文字列
+ */
+func xmlRoot(data []byte) string {
+	var decoder *xml.Decoder
+	var token xml.Token
+	var err error
+	var element xml.StartElement
+	var ok bool
+	
+	decoder = xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err = decoder.Token()
+		if err != nil {
+			return ""
+		}
+		element, ok = token.(xml.StartElement)
+		if ok {
+			return element.Name.Local
+		}
+	}
+}
@@ -0,0 +1,190 @@
+/**
+ * フィード更新のワーカープール
+ * 同時に受信する数を制限し、応答の遅いフィードや異常終了したフィードが
+ * 他のフィードの更新を妨げないようにする
+ */
+package okareader
+import (
+	"appengine"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+/**
+ * 同時に更新するフィードの数
+ */
+const updateWorkers = 10
+
+/**
+ * フィード１件の更新を待つ時間
+ * 受信のタイムアウトに解析と保存にかかる時間を加えたもの
+ */
+const feedDeadline = fetchTimeout + 10 * time.Second
+
+/**
+ * まとめて更新するときに全体で待つ時間
+ * App Engine のリクエストの制限時間(60秒)より前に結果を返す
+ */
+const poolDeadline = 50 * time.Second
+
+/**
+ * フィード更新のワーカープール
+ * @class
+ * @member {int} Workers 同時に実行する数
+ * @member {time.Duration} Timeout １件ごとの制限時間
+ * @member {time.Duration} Deadline 全体の制限時間
+ */
+type UpdatePool struct {
+	Workers int
+	Timeout time.Duration
+	Deadline time.Duration
+}
+
+/**
+ * 更新するキーとその更新結果の組
+ * @class
+ * @member {string} key フィードや受信元のキー
+ * @member {*UpdateResult} result 更新結果
+ */
+type poolResult struct {
+	key string
+	result *UpdateResult
+}
+
+/**
+ * キーごとの更新処理をワーカープールで実行する
+ * 全体の制限時間を過ぎたら実行中の処理の結果は待たずに打ち切り、まだ始まっていない処理は実行しない
+ * 更新処理には制限時刻を渡し、過ぎたら書き込まずに戻ってもらう
+ * リクエストが終わった後に書き込まれないように、実行中の処理が戻るのを待ってから返す
+ * @methodOf UpdatePool
+ * @param {appengine.Context} c コンテキスト
+ * @param {[]string} keys 更新するフィードや受信元のキー
+ * @param {func(string, time.Time) *UpdateResult} update １件分の更新処理　キーと制限時刻を受け取る
+ * @returns {map[string]*UpdateResult} キーと更新結果の対応
+ *     制限時間を過ぎたものは "timeout"、実行しなかったものは "cancelled" になる
+ */
+func (this *UpdatePool) run(c appengine.Context, keys []string, update func(string, time.Time) *UpdateResult) map[string]*UpdateResult {
+	var jobs chan string
+	var results chan *poolResult
+	var cancel chan bool
+	var finish time.Time
+	var deadline <-chan time.Time
+	var running *sync.WaitGroup
+	var received *poolResult
+	var result map[string]*UpdateResult
+	var i int
+	
+	jobs = make(chan string)
+	results = make(chan *poolResult, len(keys))
+	cancel = make(chan bool)
+	running = new(sync.WaitGroup)
+	finish = time.Now().Add(this.Deadline)
+	
+	for i = 0; i < this.Workers && i < len(keys); i++ {
+		go func() {
+			var key string
+			for key = range jobs {
+				results <- &poolResult{key, this.runOne(c, key, update, finish, cancel, running)}
+			}
+		}()
+	}
+	
+	// 打ち切られたらまだワーカーに渡していない処理は実行しない
+	go func() {
+		var key string
+		defer close(jobs)
+		for _, key = range keys {
+			select {
+				case jobs <- key:
+				case <- cancel:
+					results <- &poolResult{key, &UpdateResult{Status: "cancelled"}}
+			}
+		}
+	}()
+	
+	result = make(map[string]*UpdateResult)
+	deadline = time.After(this.Deadline)
+	for i = 0; i < len(keys); {
+		select {
+			case received = <- results:
+				result[received.key] = received.result
+				i++
+			case <- deadline:
+				c.Warningf("update pool: deadline exceeded, %d of %d finished", i, len(keys))
+				close(cancel)
+				deadline = nil
+		}
+	}
+	
+	// 打ち切った処理も制限時刻を過ぎれば書き込まずに戻るので、長くは待たない
+	running.Wait()
+	
+	return result
+}
+
+/**
+ * １件分の更新処理を制限時間付きで実行する
+ * 制限時間を過ぎても処理そのものは止められないので、結果を待たずに戻る
+ * 処理には１件ごとの制限時刻と全体の制限時刻の早い方を渡す
+ * @methodOf UpdatePool
+ * @param {appengine.Context} c コンテキスト
+ * @param {string} key フィードや受信元のキー
+ * @param {func(string, time.Time) *UpdateResult} update １件分の更新処理
+ * @param {time.Time} finish 全体の制限時刻
+ * @param {chan bool} cancel 全体が打ち切られたときに閉じられるチャネル
+ * @param {*sync.WaitGroup} running 実行中の処理　戻ったら Done を呼ぶ
+ * @returns {*UpdateResult} 更新結果
+ */
+func (this *UpdatePool) runOne(c appengine.Context, key string, update func(string, time.Time) *UpdateResult, finish time.Time, cancel chan bool, running *sync.WaitGroup) *UpdateResult {
+	var done chan *UpdateResult
+	var result *UpdateResult
+	var started time.Time
+	var deadline time.Time
+	
+	select {
+		case <- cancel:
+			return &UpdateResult{Status: "cancelled"}
+		default:
+	}
+	
+	started = time.Now()
+	deadline = started.Add(this.Timeout)
+	if deadline.After(finish) {
+		deadline = finish
+	}
+	done = make(chan *UpdateResult, 1)
+	running.Add(1)
+	go func() {
+		defer running.Done()
+		
+		// 異常終了しても他のフィードの更新は続ける
+		defer func() {
+			var r interface{}
+			r = recover()
+			if r != nil {
+				c.Errorf("update %s: panic: %v\n%s", key, r, debug.Stack())
+				done <- &UpdateResult{Status: "failed"}
+			}
+		}()
+		done <- update(key, deadline)
+	}()
+	
+	select {
+		case result = <- done:
+			if result == nil {
+				result = &UpdateResult{Status: "failed"}
+			}
+		case <- time.After(this.Timeout):
+			c.Warningf("update %s: timeout", key)
+			result = &UpdateResult{Status: "timeout"}
+		case <- cancel:
+			c.Warningf("update %s: cancelled while running", key)
+			result = &UpdateResult{Status: "timeout"}
+	}
+	if result.Duration == 0 {
+		result.Duration = milliseconds(time.Since(started))
+	}
+	
+	return result
+}
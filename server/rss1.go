@@ -5,6 +5,7 @@ package okareader
 import (
 	"appengine"
 	"encoding/xml"
+	"errors"
 )
 
 /**
@@ -15,6 +16,13 @@ type RSS1 struct {
 
 }
 
+/**
+ * ルート要素が RDF ならRSS1.0として登録する
+ */
+func init() {
+	registerParser("RSS1.0", detectXMLRoot("RDF"), new(RSS1))
+}
+
 /**
  * RSS1.0のXMLをFeedに変換する
  * @methodOf RSS1
@@ -22,11 +30,15 @@ type RSS1 struct {
  * @param {[]byte} xmldata XMLのバイト配列
  * @returns {*Feed} 変換したフェード
  * @returns {[]*Entries} 変換したエントリ
+ * @returns {error} 読み込めなかったときのエラー
  */
-func (this *RSS1) encode(c appengine.Context, xmldata []byte) (*Feed, []*Entry) {
+func (this *RSS1) encode(c appengine.Context, xmldata []byte) (*Feed, []*Entry, error) {
 	type Item struct {
+		About string `xml:"about,attr"`
 		Title string `xml:"title"`
 		Link string `xml:"link"`
+		Description string `xml:"description"`
+		Encoded string `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
 		Date string `xml:"date"`
 	}
 	type Channel struct {
@@ -34,6 +46,8 @@ func (this *RSS1) encode(c appengine.Context, xmldata []byte) (*Feed, []*Entry)
 		Link string `xml:"link"`
 		Date string `xml:"date"`
 		About string `xml:"about,attr"`
+		UpdatePeriod string `xml:"updatePeriod"`
+		UpdateFrequency string `xml:"updateFrequency"`
 	}
 	type RDF struct {
 		Channel *Channel `xml:"channel"`
@@ -45,23 +59,49 @@ func (this *RSS1) encode(c appengine.Context, xmldata []byte) (*Feed, []*Entry)
 	var err error
 	var i int
 	var item *Item
+	var scheduler *Scheduler
 	
 	rdf = new(RDF)
 	err = xml.Unmarshal(xmldata, rdf)
-	check(c, err)
+	if err != nil {
+		return nil, nil, err
+	}
+	if rdf.Channel == nil {
+		return nil, nil, errors.New("rss1: channel not found")
+	}
 	
 	feed = new(Feed)
 	feed.URL = rdf.Channel.About
 	feed.SiteURL = rdf.Channel.Link
 	feed.Title = rdf.Channel.Title
 	feed.Standard = "RSS1.0"
+	feed.Updated = parseDate(rdf.Channel.Date)
+	
+	// sy:updatePeriod による受信間隔の指定
+	scheduler = new(Scheduler)
+	feed.UpdateInterval = scheduler.updateInterval(rdf.Channel.UpdatePeriod, rdf.Channel.UpdateFrequency)
 	
 	entries = make([]*Entry, len(rdf.Item))
 	for i, item = range rdf.Item {
 		entries[i] = new(Entry)
+		entries[i].ID = item.About
 		entries[i].Title = item.Title
 		entries[i].Link = item.Link
+		entries[i].Published = parseDate(item.Date)
+		entries[i].Updated = entries[i].Published
+		
+		// content:encoded があれば本文、なければ description を本文とする
+		if item.Encoded != "" {
+			entries[i].Content = item.Encoded
+			entries[i].Summary = summarize(item.Description)
+		} else {
+			entries[i].Content = item.Description
+		}
+		entries[i].ContentType = "html"
+		if entries[i].Summary == "" {
+			entries[i].Summary = summarize(entries[i].Content)
+		}
 	}
 	
-	return feed, entries
+	return feed, entries, nil
 }
\ No newline at end of file
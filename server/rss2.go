@@ -7,6 +7,8 @@ package okareader
 import(
 	"appengine"
 	"encoding/xml"
+	"strconv"
+	"strings"
 )
 
 /**
@@ -17,6 +19,13 @@ type RSS2 struct {
 
 }
 
+/**
+ * ルート要素が rss ならRSS2.0として登録する
+ */
+func init() {
+	registerParser("RSS2.0", detectXMLRoot("rss"), new(RSS2))
+}
+
 /**
  * xmlをFeedオブジェクトに変換する
  * @methodOf RSS2
@@ -24,22 +33,44 @@ type RSS2 struct {
  * @param {[]byte} xmldata 変換するXMLデータ
  * @returns {*Feed} 変換結果のフィード
  * @returns {[]*Entry} 変換結果のエントリ
+ * @returns {error} 読み込めなかったときのエラー
  */
-func (this *RSS2) encode(c appengine.Context, xmldata []byte) (*Feed, []*Entry) {
+func (this *RSS2) encode(c appengine.Context, xmldata []byte) (*Feed, []*Entry, error) {
 	type Item struct {
+		GUID string `xml:"guid"`
 		Title string `xml:"title"`
 		Link string `xml:"link"`
 		Description string `xml:"description"`
+		Encoded string `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+		PubDate string `xml:"pubDate"`
 		Date string `xml:"date"`
+		Enclosure struct {
+			URL string `xml:"url,attr"`
+			Type string `xml:"type,attr"`
+			Length string `xml:"length,attr"`
+		} `xml:"enclosure"`
+		Duration string `xml:"duration"`
+	}
+	type Image struct {
+		URL string `xml:"url"`
+		Href string `xml:"href,attr"`
 	}
 	type Link struct {
 		Body string `xml:",innerxml"`
 		Href string `xml:"href,attr"`
+		Rel string `xml:"rel,attr"`
 	}
 	type Channel struct {
 		Title string `xml:"channel>title"`
 		Link []Link `xml:"channel>link"`
 		Date string `xml:"channel>date"`
+		PubDate string `xml:"channel>pubDate"`
+		LastBuildDate string `xml:"channel>lastBuildDate"`
+		Image []Image `xml:"channel>image"`
+		TTL string `xml:"channel>ttl"`
+		SkipHours []string `xml:"channel>skipHours>hour"`
+		UpdatePeriod string `xml:"channel>updatePeriod"`
+		UpdateFrequency string `xml:"channel>updateFrequency"`
 		Item []*Item `xml:"channel>item"`
 	}
 	var feed *Feed
@@ -49,28 +80,85 @@ func (this *RSS2) encode(c appengine.Context, xmldata []byte) (*Feed, []*Entry)
 	var item *Item
 	var i int
 	var link Link
+	var image Image
+	var scheduler *Scheduler
 	
 	channel = new(Channel)
 	err = xml.Unmarshal(xmldata, channel)
-	check(c, err)
+	if err != nil {
+		return nil, nil, err
+	}
 	
 	feed = new(Feed)
+	// link は本来のリンクと atom:link(self, hub)の両方がある
 	for _, link = range channel.Link {
-		if link.Href != "" {
-			feed.URL = link.Href
-		} else {
+		if link.Href == "" {
 			feed.SiteURL = link.Body
+		} else if link.Rel == "hub" {
+			if feed.Hub == "" {
+				feed.Hub = link.Href
+			}
+		} else if link.Rel == "self" || link.Rel == "" {
+			feed.URL = link.Href
 		}
 	}
 	feed.Title = channel.Title
 	feed.Standard = "RSS2.0"
+	
+	// image と itunes:image のどちらかをアートワークとする
+	for _, image = range channel.Image {
+		if image.Href != "" {
+			feed.Image = image.Href
+		} else if feed.Image == "" {
+			feed.Image = image.URL
+		}
+	}
+	feed.Updated = parseDate(channel.LastBuildDate)
+	if feed.Updated.IsZero() {
+		feed.Updated = parseDate(channel.PubDate)
+	}
+	if feed.Updated.IsZero() {
+		feed.Updated = parseDate(channel.Date)
+	}
+	
+	// 受信間隔の指定
+	scheduler = new(Scheduler)
+	feed.TTL, _ = strconv.Atoi(strings.TrimSpace(channel.TTL))
+	feed.SkipHours = scheduler.skipHours(channel.SkipHours)
+	feed.UpdateInterval = scheduler.updateInterval(channel.UpdatePeriod, channel.UpdateFrequency)
 
 	entries = make([]*Entry, len(channel.Item))
 	for i, item = range channel.Item {
 		entries[i] = new(Entry)
+		entries[i].ID = strings.TrimSpace(item.GUID)
 		entries[i].Title = item.Title
 		entries[i].Link = item.Link
+		
+		// pubDate がなければ dc:date を使う
+		entries[i].Published = parseDate(item.PubDate)
+		if entries[i].Published.IsZero() {
+			entries[i].Published = parseDate(item.Date)
+		}
+		entries[i].Updated = entries[i].Published
+		
+		// ポッドキャスト
+		entries[i].EnclosureURL = item.Enclosure.URL
+		entries[i].EnclosureType = item.Enclosure.Type
+		entries[i].EnclosureLength, _ = strconv.ParseInt(item.Enclosure.Length, 10, 64)
+		entries[i].Duration = parseDuration(item.Duration)
+		
+		// content:encoded があれば本文、なければ description を本文とする
+		if item.Encoded != "" {
+			entries[i].Content = item.Encoded
+			entries[i].Summary = summarize(item.Description)
+		} else {
+			entries[i].Content = item.Description
+		}
+		entries[i].ContentType = "html"
+		if entries[i].Summary == "" {
+			entries[i].Summary = summarize(entries[i].Content)
+		}
 	}
 	
-	return feed, entries
+	return feed, entries, nil
 }
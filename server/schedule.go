@@ -0,0 +1,174 @@
+/**
+ * フィードごとの受信間隔の決定
+ * 更新頻度の高いフィードは短い間隔で、更新の少ないフィードは長い間隔で受信する
+ */
+package okareader
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/**
+ * 受信間隔の決定
+ * @class
+ */
+type Scheduler struct {
+}
+
+/**
+ * 受信間隔の下限
+ */
+const minPollInterval = 15 * time.Minute
+
+/**
+ * 受信間隔の上限
+ */
+const maxPollInterval = 24 * time.Hour
+
+/**
+ * 更新頻度がわからないフィードの受信間隔
+ */
+const defaultPollInterval = 6 * time.Hour
+
+/**
+ * sy:updatePeriod の値と期間(分)の対応
+ */
+var updatePeriods = map[string]int{
+	"hourly": 60,
+	"daily": 24 * 60,
+	"weekly": 7 * 24 * 60,
+	"monthly": 30 * 24 * 60,
+	"yearly": 365 * 24 * 60,
+}
+
+/**
+ * 次に受信する日時を決める
+ * 投稿間隔の半分を基本とし、配信側が ttl や sy:updatePeriod で
+ * 指定した間隔より短くはしない
+ * skipHours に含まれる時間帯(GMT)は避ける
+ * WebSub でプッシュを受け取れるフィードはめったに受信しない
+ * @methodOf Scheduler
+ * @param {*Source} source 受信元
+ * @param {time.Time} now 受信した日時
+ * @returns {time.Time} 次に受信する日時
+ */
+func (this *Scheduler) next(source *Source, now time.Time) time.Time {
+	var interval time.Duration
+	var skip map[int]bool
+	var hour int
+	var result time.Time
+	var i int
+	
+	interval = defaultPollInterval
+	if source.PostInterval > 0 {
+		interval = time.Duration(source.PostInterval) * time.Minute / 2
+	}
+	if time.Duration(source.TTL) * time.Minute > interval {
+		interval = time.Duration(source.TTL) * time.Minute
+	}
+	if time.Duration(source.UpdateInterval) * time.Minute > interval {
+		interval = time.Duration(source.UpdateInterval) * time.Minute
+	}
+	
+	// プッシュで受け取れている間は取りこぼしの確認だけにする
+	if source.Push && source.LeaseExpires.After(now) {
+		interval = maxPollInterval
+	}
+	if interval < minPollInterval {
+		interval = minPollInterval
+	}
+	if interval > maxPollInterval {
+		interval = maxPollInterval
+	}
+	result = now.Add(interval)
+	
+	// 配信側が受信しないでほしいと指定した時間帯を避ける
+	skip = make(map[int]bool)
+	for _, hour = range source.SkipHours {
+		skip[hour] = true
+	}
+	for i = 0; i < 24 && skip[result.UTC().Hour()]; i++ {
+		result = result.Truncate(time.Hour).Add(time.Hour)
+	}
+	
+	return result
+}
+
+/**
+ * エントリの投稿日時から平均の投稿間隔を求める
+ * @methodOf Scheduler
+ * @param {[]*Entry} entries フィードに含まれるエントリ
+ * @returns {int} 平均の投稿間隔(分)　投稿日時のあるエントリが２件未満なら0
+ */
+func (this *Scheduler) postInterval(entries []*Entry) int {
+	var times []float64
+	var entry *Entry
+	var span time.Duration
+	var interval int
+	
+	times = make([]float64, 0, len(entries))
+	for _, entry = range entries {
+		if !entry.Published.IsZero() {
+			times = append(times, float64(entry.Published.Unix()))
+		}
+	}
+	if len(times) < 2 {
+		return 0
+	}
+	sort.Float64s(times)
+	
+	span = time.Duration(times[len(times) - 1] - times[0]) * time.Second
+	interval = int(span / time.Minute) / (len(times) - 1)
+	if interval < 1 {
+		interval = 1
+	}
+	return interval
+}
+
+/**
+ * sy:updatePeriod と sy:updateFrequency から配信側の更新間隔を求める
+ * @methodOf Scheduler
+ * @param {string} period sy:updatePeriod の値(hourly/daily/weekly/monthly/yearly)
+ * @param {string} frequency sy:updateFrequency の値　省略されていたら1とする
+ * @returns {int} 更新間隔(分)　period がなければ0
+ */
+func (this *Scheduler) updateInterval(period string, frequency string) int {
+	var minutes int
+	var times int
+	var err error
+	
+	minutes = updatePeriods[strings.ToLower(strings.TrimSpace(period))]
+	if minutes == 0 {
+		return 0
+	}
+	
+	times, err = strconv.Atoi(strings.TrimSpace(frequency))
+	if err != nil || times < 1 {
+		times = 1
+	}
+	return minutes / times
+}
+
+/**
+ * RSS2.0 の skipHours に書かれた時刻を数値にする
+ * @methodOf Scheduler
+ * @param {[]string} hours hour 要素の値
+ * @returns {[]int} 0〜23 の時刻(GMT)
+ */
+func (this *Scheduler) skipHours(hours []string) []int {
+	var result []int
+	var hour string
+	var value int
+	var err error
+	
+	result = make([]int, 0, len(hours))
+	for _, hour = range hours {
+		value, err = strconv.Atoi(strings.TrimSpace(hour))
+		if err == nil && value >= 0 && value <= 24 {
+			result = append(result, value % 24)
+		}
+	}
+	return result
+}
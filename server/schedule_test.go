@@ -0,0 +1,122 @@
+/**
+ * 受信間隔の決定のテスト
+ */
+package okareader
+import (
+	"testing"
+	"time"
+)
+
+/**
+ * 2013/3/5 12:00 UTC から次の受信までの時間を求める
+ * @function
+ * @param {*Source} source 受信元
+ * @returns {time.Duration} 次の受信までの時間
+ */
+func nextPoll(source *Source) time.Duration {
+	var scheduler *Scheduler
+	var now time.Time
+	
+	scheduler = new(Scheduler)
+	now = time.Date(2013, 3, 5, 12, 0, 0, 0, time.UTC)
+	return scheduler.next(source, now).Sub(now)
+}
+
+/**
+ * 投稿間隔の半分を受信間隔とし、上限と下限に収めるか確かめる
+ * @function
+ * @param {*testing.T} t テスト
+ */
+func TestSchedulerPostInterval(t *testing.T) {
+	var actual time.Duration
+	
+	actual = nextPoll(&Source{})
+	if actual != defaultPollInterval {
+		t.Errorf("unknown post interval: next in %v, want %v", actual, defaultPollInterval)
+	}
+	actual = nextPoll(&Source{PostInterval: 4 * 60})
+	if actual != 2 * time.Hour {
+		t.Errorf("posts every 4 hours: next in %v, want 2h", actual)
+	}
+	actual = nextPoll(&Source{PostInterval: 1})
+	if actual != minPollInterval {
+		t.Errorf("posts every minute: next in %v, want %v", actual, minPollInterval)
+	}
+	actual = nextPoll(&Source{PostInterval: 7 * 24 * 60})
+	if actual != maxPollInterval {
+		t.Errorf("posts every week: next in %v, want %v", actual, maxPollInterval)
+	}
+}
+
+/**
+ * ttl, sy:updatePeriod, skipHours など配信側の指定に従うか確かめる
+ * @function
+ * @param {*testing.T} t テスト
+ */
+func TestSchedulerPublisherHints(t *testing.T) {
+	var actual time.Duration
+	
+	actual = nextPoll(&Source{PostInterval: 60, TTL: 3 * 60})
+	if actual != 3 * time.Hour {
+		t.Errorf("ttl of 3 hours: next in %v", actual)
+	}
+	actual = nextPoll(&Source{PostInterval: 60, UpdateInterval: 24 * 60})
+	if actual != 24 * time.Hour {
+		t.Errorf("updated daily: next in %v", actual)
+	}
+	
+	// 14時と15時は受信しないので16時まで待つ
+	actual = nextPoll(&Source{PostInterval: 4 * 60, SkipHours: []int{14, 15}})
+	if actual != 4 * time.Hour {
+		t.Errorf("skip hours: next in %v", actual)
+	}
+}
+
+/**
+ * プッシュを受け取れる間は受信を控え、購読が切れたら短い間隔に戻すか確かめる
+ * @function
+ * @param {*testing.T} t テスト
+ */
+func TestSchedulerPush(t *testing.T) {
+	var now time.Time
+	var actual time.Duration
+	
+	now = time.Date(2013, 3, 5, 12, 0, 0, 0, time.UTC)
+	actual = nextPoll(&Source{PostInterval: 60, Push: true, LeaseExpires: now.Add(time.Hour)})
+	if actual != maxPollInterval {
+		t.Errorf("active push subscription: next in %v, want %v", actual, maxPollInterval)
+	}
+	actual = nextPoll(&Source{PostInterval: 60, Push: true, LeaseExpires: now.Add(-time.Hour)})
+	if actual != 30 * time.Minute {
+		t.Errorf("expired push subscription: next in %v, want 30m", actual)
+	}
+}
+
+/**
+ * sy:updatePeriod と sy:updateFrequency から更新間隔(分)を求められるか確かめる
+ * @function
+ * @param {*testing.T} t テスト
+ */
+func TestSchedulerUpdateInterval(t *testing.T) {
+	var expected map[[2]string]int
+	var scheduler *Scheduler
+	var hint [2]string
+	var minutes int
+	
+	expected = map[[2]string]int{
+		{"hourly", ""}: 60,
+		{"daily", "2"}: 12 * 60,
+		{" Weekly ", "7"}: 24 * 60,
+		{"daily", "0"}: 24 * 60,
+		{"daily", "x"}: 24 * 60,
+		{"", "2"}: 0,
+		{"sometimes", ""}: 0,
+	}
+	
+	scheduler = new(Scheduler)
+	for hint, minutes = range expected {
+		if scheduler.updateInterval(hint[0], hint[1]) != minutes {
+			t.Errorf("updateInterval(%q, %q) = %d, want %d", hint[0], hint[1], scheduler.updateInterval(hint[0], hint[1]), minutes)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+/**
+ * 開発サーバ用のWebSubハブ
+ * 外部のハブを使わずに購読からプッシュまでの流れを確認するために使う
+ * 本番環境では登録されない
+ *
+ * 使い方
+ * 1. フィードに <link rel="hub" href="http://localhost:8080/dev/hub"/> を書いて追加する
+ * 2. hub.mode=publish, hub.url=フィードのURL を /dev/hub へPOSTするとプッシュされる
+ */
+package okareader
+import (
+	"appengine"
+	"appengine/datastore"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+/**
+ * 開発用ハブ
+ * @class
+ */
+type StubHub struct {
+}
+
+/**
+ * 開発用ハブが保存する購読
+ * キーはコールバックURL
+ * @class
+ * @member {string} Callback 購読者のコールバックURL
+ * @member {string} Topic 購読するフィードのURL
+ * @member {string} Secret 署名に使う秘密鍵
+ */
+type StubSubscription struct {
+	Callback string
+	Topic string
+	Secret string `datastore:",noindex"`
+}
+
+/**
+ * ハブへのリクエストを処理する
+ * @methodOf StubHub
+ * @param {http.ResponseWriter} w 応答先
+ * @param {*http.Request} r リクエスト
+ * @param {HTTP POST} hub.mode "subscribe" / "unsubscribe" / "publish"
+ */
+func (this *StubHub) handle(w http.ResponseWriter, r *http.Request) {
+	var c appengine.Context
+	var subscription *StubSubscription
+	var mode string
+	var err error
+	
+	c = appengine.NewContext(r)
+	mode = r.FormValue("hub.mode")
+	
+	switch mode {
+		case "subscribe", "unsubscribe":
+			subscription = &StubSubscription{r.FormValue("hub.callback"), r.FormValue("hub.topic"), r.FormValue("hub.secret")}
+			err = this.verify(c, mode, subscription, r.FormValue("hub.lease_seconds"))
+			if err != nil {
+				check(c, err)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			this.save(c, mode, subscription)
+			w.WriteHeader(http.StatusAccepted)
+		case "publish":
+			err = this.publish(c, r.FormValue("hub.url"))
+			if err != nil {
+				check(c, err)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "unknown hub.mode", http.StatusBadRequest)
+	}
+}
+
+/**
+ * 購読者に購読の意思を確認する
+ * 本来のハブは非同期に確認するが、開発用なので応答する前に確認する
+ * @methodOf StubHub
+ * @param {appengine.Context} c コンテキスト
+ * @param {string} mode "subscribe" または "unsubscribe"
+ * @param {*StubSubscription} subscription 購読
+ * @param {string} lease 購読期間(秒)
+ * @returns {error} 購読者が確認に答えなかったときのエラー
+ */
+func (this *StubHub) verify(c appengine.Context, mode string, subscription *StubSubscription, lease string) error {
+	var callback *url.URL
+	var query url.Values
+	var challenge string
+	var response *http.Response
+	var body []byte
+	var err error
+	
+	callback, err = url.Parse(subscription.Callback)
+	if err != nil {
+		return err
+	}
+	
+	challenge = new(WebSub).newSecret()
+	query = callback.Query()
+	query.Set("hub.mode", mode)
+	query.Set("hub.topic", subscription.Topic)
+	query.Set("hub.challenge", challenge)
+	if lease != "" {
+		query.Set("hub.lease_seconds", lease)
+	}
+	callback.RawQuery = query.Encode()
+	
+	response, err = httpClient(c).Get(callback.String())
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	
+	body, err = ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode != http.StatusOK || string(body) != challenge {
+		return fmt.Errorf("stubhub: %s did not confirm %s", subscription.Callback, mode)
+	}
+	return nil
+}
+
+/**
+ * 購読を保存または削除する
+ * @methodOf StubHub
+ * @param {appengine.Context} c コンテキスト
+ * @param {string} mode "subscribe" または "unsubscribe"
+ * @param {*StubSubscription} subscription 購読
+ */
+func (this *StubHub) save(c appengine.Context, mode string, subscription *StubSubscription) {
+	var key *datastore.Key
+	var err error
+	
+	key = datastore.NewKey(c, "stubhub", subscription.Callback, 0, nil)
+	if mode == "subscribe" {
+		_, err = datastore.Put(c, key, subscription)
+	} else {
+		err = datastore.Delete(c, key)
+	}
+	check(c, err)
+}
+
+/**
+ * フィードを受信して購読者全員にプッシュする
+ * @methodOf StubHub
+ * @param {appengine.Context} c コンテキスト
+ * @param {string} topic 更新されたフィードのURL
+ * @returns {error} フィードを受信できなかったときのエラー
+ */
+func (this *StubHub) publish(c appengine.Context, topic string) error {
+	var fetched *FetchResult
+	var subscriptions []*StubSubscription
+	var subscription *StubSubscription
+	var request *http.Request
+	var response *http.Response
+	var mac []byte
+	var err error
+	
+	fetched, err = fetch(c, topic)
+	if err != nil {
+		return err
+	}
+	
+	_, err = datastore.NewQuery("stubhub").Filter("Topic =", topic).GetAll(c, &subscriptions)
+	if err != nil {
+		return err
+	}
+	
+	for _, subscription = range subscriptions {
+		request, err = http.NewRequest("POST", subscription.Callback, bytes.NewReader(fetched.Body))
+		if err != nil {
+			check(c, err)
+			continue
+		}
+		request.Header.Set("Content-Type", fetched.Header.Get("Content-Type"))
+		if subscription.Secret != "" {
+			mac = this.sign(subscription.Secret, fetched.Body)
+			request.Header.Set("X-Hub-Signature", "sha1=" + hex.EncodeToString(mac))
+		}
+		
+		response, err = httpClient(c).Do(request)
+		if err != nil {
+			check(c, err)
+			continue
+		}
+		response.Body.Close()
+	}
+	return nil
+}
+
+/**
+ * データに署名する
+ * @methodOf StubHub
+ * @param {string} secret 秘密鍵
+ * @param {[]byte} body 署名するデータ
+ * @returns {[]byte} HMAC-SHA1
+ */
+func (this *StubHub) sign(secret string, body []byte) []byte {
+	var mac hash.Hash
+	
+	mac = hmac.New(sha1.New, []byte(secret))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
@@ -10,7 +10,9 @@ import (
 	"appengine"
 	"appengine/user"
 	"net/http"
+	"sort"
 	text "text/template"
+	"time"
 )
 
 /**
@@ -29,17 +31,27 @@ type View struct {
  */
 func (this *View) showFolder(c appengine.Context, key string, w http.ResponseWriter) {
 	type ListItem struct {
-		Key string
-		Item interface{}
-		ItemType string
-		Count int
+		*FolderChild
+		IsFeed bool
+		Dead bool
+		Broken bool
+	}
+	type SortMode struct {
+		Mode string
+		Label string
+		Selected bool
 	}
 	var contents map[string]interface{}
 	var err error
 	var t *template.Template
 	var children []*ListItem
+	var child *FolderChild
+	var modes []*SortMode
+	var labels map[string]string
+	var mode string
 	var dao *DAO
 	var folder *Folder
+	var source *Source
 	var i int
 	
 	dao = new(DAO)
@@ -55,14 +67,40 @@ func (this *View) showFolder(c appengine.Context, key string, w http.ResponseWri
 	contents["Title"] = folder.Title
 	contents["Parent"] = folder.Parent
 	
-	children = make([]*ListItem, len(folder.Children))
-	for i, key = range folder.Children {
-		children[i] = new(ListItem)
-		children[i].Key = key
-		children[i].ItemType, children[i].Item = dao.getItem(c, key)
+	// ルートフォルダにはスター付きエントリの仮想フォルダを置く
+	contents["IsRoot"] = folder.Type == "root"
+	contents["StarredCount"] = 0
+	if folder.Type == "root" {
+		contents["StarredCount"] = dao.getStarredCount(c, user.Current(c))
+	}
+	
+	children = make([]*ListItem, 0, len(folder.Children))
+	for _, child = range dao.getChildren(c, folder) {
+		i = len(children)
+		children = append(children, &ListItem{FolderChild: child})
+		if child.Type == "feed" {
+			_, source = dao.sourceOf(c, child.Key)
+			children[i].IsFeed = true
+			children[i].Dead = source.Dead
+			children[i].Broken = dao.isBroken(source)
+		}
 	}
 	contents["Children"] = children
 	
+	// 並び順の選択肢
+	labels = map[string]string{
+		"manual": "手動",
+		"title": "名前順",
+		"unread": "未読の多い順",
+		"updated": "更新の新しい順",
+		"folders_first": "フォルダを先に",
+	}
+	modes = make([]*SortMode, 0, len(sortModes))
+	for _, mode = range sortModes {
+		modes = append(modes, &SortMode{mode, labels[mode], mode == folder.Sort || (mode == "manual" && folder.Sort == "")})
+	}
+	contents["SortModes"] = modes
+	
 	t, err = template.ParseFiles("server/html/folder.html")
 	check(c, err)
 	
@@ -74,35 +112,342 @@ func (this *View) showFolder(c appengine.Context, key string, w http.ResponseWri
  * @methodOf View
  * @param {appengine.Context} c コンテキスト
  * @param {string} feedKey 表示するフィードのキー
+ * @param {bool} showRead 既読のエントリも表示するならtrue
  * @param {http.ResponseWriter} w HTMLの出力先
  */
-func (this *View) showFeed(c appengine.Context, feedKey string, w http.ResponseWriter) {
+func (this *View) showFeed(c appengine.Context, feedKey string, showRead bool, w http.ResponseWriter) {
+	type ListItem struct {
+		*Entry
+		Age string
+		Playtime string
+	}
 	var dao *DAO
 	var entries []*Entry
 	var t *template.Template
 	var err error
 	var contents map[string]interface{}
 	var feed *Feed
+	var items []*ListItem
+	var readItems []*ListItem
+	var entry *Entry
+	var now time.Time
+	var i int
 	
 	dao = new(DAO)
 	feed = dao.getFeed(c, feedKey)
 	entries = dao.getEntries(c, feedKey)
 	
+	// 新しい順に並べて経過時間を付ける
+	sort.Stable(EntriesByDate(entries))
+	now = time.Now()
+	items = make([]*ListItem, len(entries))
+	for i = range entries {
+		items[i] = &ListItem{entries[i], relativeTime(entries[i].Published, now), formatDuration(entries[i].Duration)}
+	}
+	
+	// 既読のエントリは既読にした新しい順に並べる
+	readItems = make([]*ListItem, 0)
+	if showRead {
+		for _, entry = range dao.getReadEntries(c, feedKey) {
+			readItems = append(readItems, &ListItem{entry, relativeTime(entry.Published, now), formatDuration(entry.Duration)})
+		}
+	}
+	
 	t, err = template.ParseFiles("server/html/feed.html")
 	check(c, err)
 	
 	contents = make(map[string]interface{})
 	contents["Title"] = feed.Title
-	contents["Entries"] = entries
+	contents["Entries"] = items
+	contents["ShowRead"] = showRead
+	contents["ReadEntries"] = readItems
 	contents["Parent"] = feed.Parent
 	contents["FeedKey"] = feedKey
 	contents["SiteURL"] = feed.SiteURL
+	contents["Image"] = feed.Image
+	contents["LogoutURL"], err = user.LogoutURL(c, "/")
+	check(c, err)
+	
+	t.Execute(w, contents)
+}
+
+/**
+ * すべてのフィードのスター付きエントリをスターを付けた新しい順に一覧表示
+ * @methodOf View
+ * @param {appengine.Context} c コンテキスト
+ * @param {http.ResponseWriter} w HTMLの出力先
+ */
+func (this *View) showStarred(c appengine.Context, w http.ResponseWriter) {
+	type ListItem struct {
+		*Entry
+		FeedTitle string
+		Age string
+	}
+	var dao *DAO
+	var entries []*Entry
+	var items []*ListItem
+	var feedTitles map[string]string
+	var found bool
+	var rootKey string
+	var t *template.Template
+	var err error
+	var contents map[string]interface{}
+	var now time.Time
+	var i int
+	
+	dao = new(DAO)
+	entries = dao.getStarredEntries(c, user.Current(c))
+	rootKey, _ = dao.getRootFolder(c, user.Current(c))
+	
+	now = time.Now()
+	feedTitles = make(map[string]string)
+	items = make([]*ListItem, len(entries))
+	for i = range entries {
+		_, found = feedTitles[entries[i].Feed]
+		if !found {
+			feedTitles[entries[i].Feed] = dao.getFeed(c, entries[i].Feed).Title
+		}
+		items[i] = &ListItem{entries[i], feedTitles[entries[i].Feed], relativeTime(entries[i].Published, now)}
+	}
+	
+	t, err = template.ParseFiles("server/html/starred.html")
+	check(c, err)
+	
+	contents = make(map[string]interface{})
+	contents["Entries"] = items
+	contents["Parent"] = rootKey
+	contents["LogoutURL"], err = user.LogoutURL(c, "/")
+	check(c, err)
+	
+	t.Execute(w, contents)
+}
+
+/**
+ * フィードの受信状況を表示
+ * @methodOf View
+ * @param {appengine.Context} c コンテキスト
+ * @param {string} feedKey 表示するフィードのキー
+ * @param {http.ResponseWriter} w HTMLの出力先
+ */
+func (this *View) showHealth(c appengine.Context, feedKey string, w http.ResponseWriter) {
+	type LogItem struct {
+		Time string
+		StatusCode int
+		Error string
+	}
+	var dao *DAO
+	var feed *Feed
+	var source *Source
+	var t *template.Template
+	var err error
+	var contents map[string]interface{}
+	var history []*LogItem
+	var record FetchLog
+	var i int
+	
+	dao = new(DAO)
+	feed = dao.getFeed(c, feedKey)
+	_, source = dao.sourceOf(c, feedKey)
+	
+	// 新しい順に並べる
+	history = make([]*LogItem, len(source.History))
+	for i, record = range source.History {
+		history[len(history) - 1 - i] = &LogItem{this.formatTime(record.Time), record.StatusCode, record.Error}
+	}
+	
+	t, err = template.ParseFiles("server/html/health.html")
+	check(c, err)
+	
+	contents = make(map[string]interface{})
+	contents["Title"] = feed.Title
+	contents["Parent"] = feed.Parent
+	contents["FeedKey"] = feedKey
+	contents["URL"] = source.URL
+	contents["Dead"] = source.Dead
+	contents["Broken"] = dao.isBroken(source)
+	contents["LastFetch"] = this.formatTime(source.LastFetch)
+	contents["LastSuccess"] = this.formatTime(source.LastSuccess)
+	contents["FailureCount"] = source.FailureCount
+	contents["LastError"] = source.LastError
+	contents["NextFetch"] = ""
+	if time.Now().Before(source.NextFetch) {
+		contents["NextFetch"] = this.formatTime(source.NextFetch)
+	}
+	contents["History"] = history
+	contents["LogoutURL"], err = user.LogoutURL(c, "/")
+	check(c, err)
+	
+	t.Execute(w, contents)
+}
+
+/**
+ * 定期更新の実行記録を新しい順に一覧表示する
+ * @methodOf View
+ * @param {appengine.Context} c コンテキスト
+ * @param {http.ResponseWriter} w HTMLの出力先
+ */
+func (this *View) showUpdateLogs(c appengine.Context, w http.ResponseWriter) {
+	type LogItem struct {
+		Key string
+		Started string
+		Duration int64
+		Total int
+		New int
+		Failed int
+	}
+	var dao *DAO
+	var keys []string
+	var logs []*UpdateLog
+	var items []*LogItem
+	var t *template.Template
+	var err error
+	var contents map[string]interface{}
+	var i int
+	
+	dao = new(DAO)
+	keys, logs = dao.getUpdateLogs(c)
+	items = make([]*LogItem, len(logs))
+	for i = range logs {
+		items[i] = &LogItem{keys[i], this.formatTime(logs[i].Started), logs[i].Duration, logs[i].Total, logs[i].New, logs[i].Failed}
+	}
+	
+	t, err = template.ParseFiles("server/html/updatelogs.html")
+	check(c, err)
+	
+	contents = make(map[string]interface{})
+	contents["Logs"] = items
 	contents["LogoutURL"], err = user.LogoutURL(c, "/")
 	check(c, err)
 	
 	t.Execute(w, contents)
 }
 
+/**
+ * 定期更新の１回分の更新報告を表示する
+ * @methodOf View
+ * @param {appengine.Context} c コンテキスト
+ * @param {string} key 実行記録のキー
+ * @param {http.ResponseWriter} w HTMLの出力先
+ */
+func (this *View) showUpdateReport(c appengine.Context, key string, w http.ResponseWriter) {
+	var dao *DAO
+	var report *UpdateReport
+	var t *template.Template
+	var err error
+	var contents map[string]interface{}
+	
+	dao = new(DAO)
+	report = dao.getUpdateReport(c, key)
+	if report == nil {
+		http.Error(w, "update log not found", http.StatusNotFound)
+		return
+	}
+	
+	t, err = template.ParseFiles("server/html/updatereport.html")
+	check(c, err)
+	
+	contents = make(map[string]interface{})
+	contents["Started"] = this.formatTime(report.Started)
+	contents["Report"] = report
+	contents["LogoutURL"], err = user.LogoutURL(c, "/")
+	check(c, err)
+	
+	t.Execute(w, contents)
+}
+
+/**
+ * 最後に行ったフォルダ・フィード・エントリ・受信元の整合性の検査結果を表示する
+ * @methodOf View
+ * @param {appengine.Context} c コンテキスト
+ * @param {http.ResponseWriter} w HTMLの出力先
+ */
+func (this *View) showIntegrity(c appengine.Context, w http.ResponseWriter) {
+	type IssueItem struct {
+		*IntegrityIssue
+		Label string
+	}
+	type CountItem struct {
+		Label string
+		Count int
+	}
+	var labels map[string]string
+	var kinds []string
+	var kind string
+	var dao *DAO
+	var report *IntegrityReport
+	var run time.Time
+	var tasks int
+	var issues []*IssueItem
+	var counts []*CountItem
+	var t *template.Template
+	var err error
+	var contents map[string]interface{}
+	var i int
+	
+	labels = map[string]string{
+		"duplicate_root": "重複したルートフォルダ",
+		"dangling_child": "存在しない子への参照",
+		"duplicate_child": "重複した子への参照",
+		"cycle": "循環",
+		"wrong_parent": "親の食い違い",
+		"orphan_folder": "たどれないフォルダ",
+		"orphan_feed": "たどれないフィード",
+		"dangling_entry": "存在しないエントリへの参照",
+		"duplicate_entry": "重複したエントリへの参照",
+		"orphan_entry": "どこにもないエントリ",
+		"missing_source": "存在しない受信元",
+		"unsubscribed_feed": "購読者にないフィード",
+		"dangling_subscriber": "存在しない購読者",
+		"unused_source": "購読者のいない受信元",
+	}
+	kinds = []string{"duplicate_root", "dangling_child", "duplicate_child", "cycle", "wrong_parent", "orphan_folder", "orphan_feed", "dangling_entry", "duplicate_entry", "orphan_entry", "missing_source", "unsubscribed_feed", "dangling_subscriber", "unused_source"}
+	
+	dao = new(DAO)
+	report, run, tasks = dao.getIntegrityReport(c)
+	if report == nil {
+		report = &IntegrityReport{Counts: make(map[string]int), Issues: make([]*IntegrityIssue, 0)}
+	}
+	
+	issues = make([]*IssueItem, len(report.Issues))
+	for i = range report.Issues {
+		issues[i] = &IssueItem{report.Issues[i], labels[report.Issues[i].Kind]}
+	}
+	counts = make([]*CountItem, 0)
+	for _, kind = range kinds {
+		if report.Counts[kind] > 0 {
+			counts = append(counts, &CountItem{labels[kind], report.Counts[kind]})
+		}
+	}
+	
+	t, err = template.ParseFiles("server/html/integrity.html")
+	check(c, err)
+	
+	contents = make(map[string]interface{})
+	contents["Report"] = report
+	contents["Run"] = this.formatTime(run)
+	contents["Tasks"] = tasks
+	contents["Issues"] = issues
+	contents["Counts"] = counts
+	contents["Limited"] = len(report.Issues) >= integrityIssueLimit
+	contents["LogoutURL"], err = user.LogoutURL(c, "/")
+	check(c, err)
+	
+	t.Execute(w, contents)
+}
+
+/**
+ * 日時を画面表示用の文字列にする
+ * @methodOf View
+ * @param {time.Time} t 日時
+ * @returns {string} 日本時間の "2006/01/02 15:04" 形式　ゼロ値なら空文字列
+ */
+func (this *View) formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.In(jst).Format("2006/01/02 15:04")
+}
+
 /**
  * ログインを促す画面を表示
  * @methodOf View
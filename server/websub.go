@@ -0,0 +1,159 @@
+/**
+ * WebSub(PubSubHubbub)による購読
+ * ハブを宣言しているフィードは更新をプッシュで受け取る
+ */
+package okareader
+import (
+	"appengine"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/**
+ * WebSubの購読者
+ * @class
+ */
+type WebSub struct {
+}
+
+/**
+ * ハブに要求する購読期間(秒)
+ */
+const leaseSeconds = 10 * 24 * 60 * 60
+
+/**
+ * 購読期間の残りがこれより短くなったら購読を更新する
+ */
+const leaseRenewal = 24 * time.Hour
+
+/**
+ * 署名のアルゴリズム名とハッシュ関数の対応
+ */
+var signatureHashes = map[string]func() hash.Hash{
+	"sha1": sha1.New,
+	"sha256": sha256.New,
+	"sha384": sha512.New384,
+	"sha512": sha512.New,
+}
+
+/**
+ * ハブへ購読または購読解除を申し込む
+ * ハブはこの後コールバックURLへ確認のリクエストを送ってくる
+ * @methodOf WebSub
+ * @param {appengine.Context} c コンテキスト
+ * @param {string} mode "subscribe" または "unsubscribe"
+ * @param {string} sourceKey エンコード済みの受信元キー
+ * @param {*Source} source 対象の受信元　Hub, Topic, HubSecret が設定済みであること
+ * @returns {error} 申し込みが受け付けられなかったときのエラー
+ */
+func (this *WebSub) request(c appengine.Context, mode string, sourceKey string, source *Source) error {
+	var client *http.Client
+	var response *http.Response
+	var form url.Values
+	var err error
+	
+	form = url.Values{}
+	form.Set("hub.callback", this.callbackURL(c, sourceKey))
+	form.Set("hub.mode", mode)
+	form.Set("hub.topic", source.Topic)
+	if mode == "subscribe" {
+		form.Set("hub.secret", source.HubSecret)
+		form.Set("hub.lease_seconds", strconv.Itoa(leaseSeconds))
+	}
+	
+	client = httpClient(c)
+	response, err = client.PostForm(source.Hub, form)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return &HTTPStatusError{source.Hub, response.StatusCode}
+	}
+	return nil
+}
+
+/**
+ * ハブからのリクエストを受け取るURL
+ * @methodOf WebSub
+ * @param {appengine.Context} c コンテキスト
+ * @param {string} sourceKey エンコード済みの受信元キー
+ * @returns {string} コールバックURL
+ */
+func (this *WebSub) callbackURL(c appengine.Context, sourceKey string) string {
+	var scheme string
+	
+	scheme = "https"
+	if appengine.IsDevAppServer() {
+		scheme = "http"
+	}
+	return scheme + "://" + appengine.DefaultVersionHostname(c) + "/websub?key=" + url.QueryEscape(sourceKey)
+}
+
+/**
+ * 署名の検証に使う秘密鍵を作る
+ * @methodOf WebSub
+ * @returns {string} 秘密鍵(16進数)
+ */
+func (this *WebSub) newSecret() string {
+	var secret []byte
+	var err error
+	
+	secret = make([]byte, 20)
+	_, err = rand.Read(secret)
+	if err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(secret)
+}
+
+/**
+ * プッシュされたデータの署名を検証する
+ * @methodOf WebSub
+ * @param {string} secret 購読時にハブへ渡した秘密鍵
+ * @param {[]byte} body プッシュされたデータ
+ * @param {string} signature X-Hub-Signature ヘッダ("sha1=..." の形式)
+ * @returns {bool} 署名が正しければtrue
+ */
+func (this *WebSub) validSignature(secret string, body []byte, signature string) bool {
+	var parts []string
+	var newHash func() hash.Hash
+	var ok bool
+	var mac hash.Hash
+	var expected []byte
+	var actual []byte
+	var err error
+	
+	if secret == "" {
+		return false
+	}
+	
+	parts = strings.SplitN(strings.TrimSpace(signature), "=", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	newHash, ok = signatureHashes[strings.ToLower(parts[0])]
+	if !ok {
+		return false
+	}
+	actual, err = hex.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+	
+	mac = hmac.New(newHash, []byte(secret))
+	mac.Write(body)
+	expected = mac.Sum(nil)
+	return hmac.Equal(expected, actual)
+}
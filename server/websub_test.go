@@ -0,0 +1,73 @@
+/**
+ * WebSub のテスト
+ */
+package okareader
+import (
+	"testing"
+)
+
+/**
+ * 秘密鍵 "secret" で本文 "hello" に付けた署名
+ * openssl dgst -sha1 -hmac secret などで求めた値
+ */
+const (
+	helloSHA1 = "5112055c05f944f85755efc5cd8970e194e9f45b"
+	helloSHA256 = "88aab3ede8d3adf94d26ab90d3bafd4a2083070c3bcce9c014ee04a443847c0b"
+)
+
+/**
+ * 正しい署名はアルゴリズム名の大小や前後の空白に関係なく受け付けるか確かめる
+ * @function
+ * @param {*testing.T} t テスト
+ */
+func TestValidSignatureAccepts(t *testing.T) {
+	var websub *WebSub
+	var header string
+	
+	websub = new(WebSub)
+	for _, header = range []string{
+		"sha1=" + helloSHA1,
+		"SHA1=" + helloSHA1,
+		" sha1=" + helloSHA1 + " ",
+		"sha256=" + helloSHA256,
+	} {
+		if !websub.validSignature("secret", []byte("hello"), header) {
+			t.Errorf("rejected a valid signature %q", header)
+		}
+	}
+}
+
+/**
+ * 本文や秘密鍵が違うもの、形式が正しくないものを拒否するか確かめる
+ * @function
+ * @param {*testing.T} t テスト
+ */
+func TestValidSignatureRejects(t *testing.T) {
+	var websub *WebSub
+	
+	websub = new(WebSub)
+	if websub.validSignature("secret", []byte("hello!"), "sha1=" + helloSHA1) {
+		t.Error("accepted a signature for another body")
+	}
+	if websub.validSignature("other", []byte("hello"), "sha1=" + helloSHA1) {
+		t.Error("accepted a signature made with another secret")
+	}
+	
+	// 秘密鍵を渡していない購読ではどんな署名も信用しない
+	if websub.validSignature("", []byte("hello"), "sha1=" + helloSHA1) {
+		t.Error("accepted a signature without a secret")
+	}
+	
+	if websub.validSignature("secret", []byte("hello"), "md5=5d41402abc4b2a76b9719d911017c592") {
+		t.Error("accepted an unsupported algorithm")
+	}
+	if websub.validSignature("secret", []byte("hello"), "sha1=not-hex") {
+		t.Error("accepted a signature that is not hex")
+	}
+	if websub.validSignature("secret", []byte("hello"), helloSHA1) {
+		t.Error("accepted a signature without the algorithm")
+	}
+	if websub.validSignature("secret", []byte("hello"), "") {
+		t.Error("accepted an empty signature")
+	}
+}